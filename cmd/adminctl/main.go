@@ -0,0 +1,324 @@
+// Command adminctl drives the aggregator's admin API.
+//
+//	adminctl [-addr URL] [-token TOKEN] <command> [args]
+//
+// Commands:
+//
+//	jobs                     list services with their last and next run
+//	runs                     show the progress of running batches
+//	trigger <service> [id]   run a service's batch, or a single ID, now
+//	pause <service>          skip a service's scheduled runs
+//	resume <service>         undo pause
+//	maintenance              show whether maintenance mode is on
+//	maintenance on [-for D] [reason]
+//	                         hold back every run, for D or until switched off
+//	maintenance off          switch maintenance mode off
+//	reload                   reload the configuration, as SIGHUP does
+//	status                   show readiness, queues and upstream health by service
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/admin"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/blackout"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/reload"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/scheduler"
+)
+
+type client struct {
+	addr  string
+	token string
+	http  *http.Client
+}
+
+func main() {
+	addr := flag.String("addr", envOr("ADMIN_URL", "http://127.0.0.1:8081"), "admin API base URL")
+	token := flag.String("token", os.Getenv("ADMIN_TOKEN"), "admin API bearer token")
+	flag.Usage = usage
+	flag.Parse()
+
+	c := &client{addr: strings.TrimRight(*addr, "/"), token: *token, http: &http.Client{Timeout: 30 * time.Second}}
+	if err := c.run(flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "adminctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: adminctl [-addr URL] [-token TOKEN] jobs | runs | trigger <service> [id] | pause <service> | resume <service> | maintenance [on [-for D] [reason] | off] | reload | status")
+	flag.PrintDefaults()
+}
+
+func (c *client) run(args []string) error {
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch cmd, rest := args[0], args[1:]; {
+	case cmd == "jobs" && len(rest) == 0:
+		var jobs []scheduler.JobInfo
+		if err := c.do(http.MethodGet, "/jobs", nil, &jobs); err != nil {
+			return err
+		}
+		printJobs(os.Stdout, jobs)
+	case cmd == "runs" && len(rest) == 0:
+		var runs []scheduler.RunProgress
+		if err := c.do(http.MethodGet, "/runs", nil, &runs); err != nil {
+			return err
+		}
+		printRuns(os.Stdout, runs)
+	case cmd == "trigger" && (len(rest) == 1 || len(rest) == 2):
+		path := "/jobs/" + url.PathEscape(rest[0]) + "/trigger"
+		if len(rest) == 2 {
+			path += "/" + url.PathEscape(rest[1])
+		}
+		var resp struct {
+			RunID string `json:"run_id"`
+		}
+		if err := c.do(http.MethodPost, path, nil, &resp); err != nil {
+			return err
+		}
+		fmt.Println("triggered run", resp.RunID)
+	case (cmd == "pause" || cmd == "resume") && len(rest) == 1:
+		if err := c.do(http.MethodPost, "/jobs/"+url.PathEscape(rest[0])+"/"+cmd, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %sd\n", rest[0], cmd)
+	case cmd == "maintenance":
+		return c.maintenance(rest)
+	case cmd == "reload" && len(rest) == 0:
+		var res reload.Result
+		if err := c.do(http.MethodPost, "/reload", nil, &res); err != nil {
+			return err
+		}
+		printReload(os.Stdout, res)
+	case cmd == "status" && len(rest) == 0:
+		var st admin.Status
+		if err := c.do(http.MethodGet, "/status", nil, &st); err != nil {
+			return err
+		}
+		printStatus(os.Stdout, st)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+// maintenance shows or switches the maintenance mode.
+func (c *client) maintenance(args []string) error {
+	var mode blackout.Mode
+	switch {
+	case len(args) == 0:
+		if err := c.do(http.MethodGet, "/maintenance", nil, &mode); err != nil {
+			return err
+		}
+	case args[0] == "on":
+		fs := flag.NewFlagSet("maintenance on", flag.ExitOnError)
+		dur := fs.Duration("for", 0, "switch maintenance off again after this long")
+		fs.Parse(args[1:])
+		body := map[string]interface{}{
+			"reason": strings.Join(fs.Args(), " "),
+			"set_by": os.Getenv("USER"),
+		}
+		if *dur > 0 {
+			body["until"] = time.Now().Add(*dur).UTC()
+		}
+		if err := c.do(http.MethodPut, "/maintenance", body, &mode); err != nil {
+			return err
+		}
+	case args[0] == "off" && len(args) == 1:
+		path := "/maintenance?set_by=" + url.QueryEscape(os.Getenv("USER"))
+		if err := c.do(http.MethodDelete, path, nil, &mode); err != nil {
+			return err
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+	printMode(os.Stdout, mode)
+	return nil
+}
+
+// do sends a request with body, if given, as JSON and decodes a JSON
+// response into out, if given.
+func (c *client) do(method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.addr+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, e.Error)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func printJobs(w io.Writer, jobs []scheduler.JobInfo) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tSTATE\tLAST RUN\tLAST RESULT\tNEXT RUN")
+	for _, j := range jobs {
+		state := "idle"
+		switch {
+		case j.Running:
+			state = "running"
+		case j.Paused:
+			state = "paused"
+		}
+		last, result := "-", "-"
+		if j.LastRun != nil {
+			last = formatTime(j.LastRun.StartedAt)
+			result = fmt.Sprintf("%s: %d done, %d failed", j.LastRun.Status, j.LastRun.Done, j.LastRun.Failed)
+			if j.LastRun.Reason != "" {
+				result = fmt.Sprintf("%s: %s", j.LastRun.Status, j.LastRun.Reason)
+			}
+			if j.LastRun.Retried > 0 {
+				result += fmt.Sprintf(" (%d recovered on retry)", j.LastRun.Recovered)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", j.Service, state, last, result, formatTime(j.NextRun))
+	}
+	tw.Flush()
+}
+
+func printRuns(w io.Writer, runs []scheduler.RunProgress) {
+	if len(runs) == 0 {
+		fmt.Fprintln(w, "no batches running")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tRUN\tSTARTED\tQUEUED\tIN FLIGHT\tDONE\tFAILED")
+	for _, r := range runs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\n", r.Service, r.RunID, formatTime(r.StartedAt), r.Queued, r.InFlight, r.Done, r.Failed)
+	}
+	tw.Flush()
+}
+
+func printMode(w io.Writer, mode blackout.Mode) {
+	if !mode.Active(time.Now()) {
+		fmt.Fprintln(w, "maintenance: off")
+		return
+	}
+	line := "maintenance: on"
+	if mode.Reason != "" {
+		line += " (" + mode.Reason + ")"
+	}
+	if !mode.Until.IsZero() {
+		line += " until " + formatTime(mode.Until)
+	}
+	if mode.SetBy != "" {
+		line += ", set by " + mode.SetBy + " at " + formatTime(mode.SetAt)
+	}
+	fmt.Fprintln(w, line)
+}
+
+func printReload(w io.Writer, res reload.Result) {
+	if len(res.Applied) == 0 {
+		fmt.Fprintln(w, "reloaded, nothing to apply")
+	} else {
+		fmt.Fprintln(w, "reloaded, applied:", strings.Join(res.Applied, ", "))
+	}
+	if len(res.Restart) > 0 {
+		fmt.Fprintln(w, "needs a restart:", strings.Join(res.Restart, ", "))
+	}
+}
+
+func printStatus(w io.Writer, st admin.Status) {
+	checks := make([]string, 0, len(st.Checks))
+	for name, result := range st.Checks {
+		checks = append(checks, name+": "+result)
+	}
+	sort.Strings(checks)
+	ready := "ready"
+	if !st.Ready {
+		ready = "not ready"
+	}
+	fmt.Fprintf(w, "%s (%s)\n", ready, strings.Join(checks, ", "))
+	if st.SpoolPending != nil {
+		fmt.Fprintf(w, "spooled records: %d\n", *st.SpoolPending)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tLAST SUCCESS\tQUEUED\tIN FLIGHT\tREQUESTS\tTHROTTLED\tERRORS\tAVG LATENCY")
+	for _, svc := range st.Services {
+		upstream := "-\t-\t-\t-"
+		if u := svc.Upstream; u != nil {
+			upstream = fmt.Sprintf("%d\t%d\t%d\t%.0fms", u.Requests, u.Throttled, u.Errors, u.AvgLatencyMS)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", svc.Service, formatTime(svc.LastSuccess), svc.Queued, svc.InFlight, upstream)
+	}
+	tw.Flush()
+
+	if len(st.Stale) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%d stale params:\n", len(st.Stale))
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tPARAM\tLAST SUCCESS\tLAST ATTEMPT\tLAST ERROR")
+	now := time.Now()
+	for _, p := range st.Stale {
+		lastErr := p.LastError
+		if lastErr == "" {
+			lastErr = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s (%s ago)\t%s\t%s\n", p.Service, p.Param, formatTime(p.LastSuccess), p.Age(now).Round(time.Minute), formatTime(p.LastAttempt), lastErr)
+	}
+	tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -2,16 +2,35 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
-	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/admin"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/alert"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/api"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/blackout"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/config"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/db"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/history"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/lifecycle"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/lock"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/metrics"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/queue"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/reload"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/scheduler"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/spool"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/staleness"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/tracing"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/workpool"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
 	"github.com/AbdulWasayUl/go-api-parser-mono/services/aqi"
 	"github.com/AbdulWasayUl/go-api-parser-mono/services/country"
 	worldtime "github.com/AbdulWasayUl/go-api-parser-mono/services/time"
@@ -20,82 +39,528 @@ import (
 
 func main() {
 	logger.Init()
-	cfg := config.Load()
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	logger.Configure(logger.Options{Format: cfg.LogFormat, Level: cfg.LogLevel, Levels: cfg.LogLevels})
+	logger.Info("Loaded configuration (profile %s, file %q, secrets %q).", cfg.Profile, cfg.File, cfg.SecretsFile)
+	stopTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+		File:        cfg.TracingFile,
+		SampleRatio: cfg.TracingSampleRatio,
+		InstanceID:  cfg.InstanceID,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	// The spool keeps what is fetched while MongoDB is unreachable, and a
+	// copy of the fetch params so batches can run without it
+	var sp *spool.Spool
+	base := context.Background()
+	if cfg.SpoolDir != "" {
+		if sp, err = spool.Open(cfg.SpoolDir, int64(cfg.SpoolMaxMB)<<20); err != nil {
+			log.Fatalf("Failed to open spool: %v", err)
+		}
+		base = db.WithParamCache(base, sp)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// ctx is the workers' context; it is only cancelled once they have drained,
+	// or when the drain deadline passes
+	ctx, cancel := context.WithCancel(base)
 	defer cancel()
+	// Producers (the queue resumer) and background tasks (heartbeats,
+	// autoscalers) are stopped at their own point in the shutdown
+	prodCtx, stopProducers := context.WithCancel(ctx)
+	defer stopProducers()
+	bgCtx, stopBackground := context.WithCancel(ctx)
+	defer stopBackground()
+	var producers, background sync.WaitGroup
+	goProducer := func(fn func(ctx context.Context)) {
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			fn(prodCtx)
+		}()
+	}
+	goBackground := func(fn func(ctx context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			fn(bgCtx)
+		}()
+	}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	quit := lifecycle.Notify()
+	hup := reload.Notify()
+	shutdown := lifecycle.New()
 
-	client, err := db.ConnectMongoDB(ctx, cfg)
+	// migrated is reported by /readyz
+	var migrated atomic.Bool
+	// mongoUp is closed once MongoDB is reachable
+	mongoUp := make(chan struct{})
+	client, err := db.NewClient(cfg)
 	if err != nil {
-		logger.Error("Failed to connect to MongoDB: %v", err)
+		log.Fatalf("Invalid MongoDB settings: %v", err)
 	}
-	defer func() {
-		if err := db.DisconnectMongoDB(ctx, client); err != nil {
-			logger.Error("Error disconnecting MongoDB: %v", err)
+	if err := db.WaitReachable(ctx, client, cfg.MongoStartupTimeout); err != nil {
+		switch {
+		case sp == nil:
+			log.Fatalf("Failed to connect to MongoDB: %v", err)
+		case cfg.DurableQueue || cfg.Cluster:
+			log.Fatalf("Failed to connect to MongoDB, which DURABLE_QUEUE and CLUSTER need at startup: %v", err)
 		}
-	}()
-
-	if err := db.RunMigrations(ctx, client, cfg); err != nil {
+		// Run degraded: fetch with the cached params and spool the records
+		logger.Error("Failed to connect to MongoDB, starting without it and spooling records to %s: %v", cfg.SpoolDir, err)
+		goBackground(func(ctx context.Context) {
+			for db.WaitReachable(ctx, client, time.Hour) != nil {
+				if ctx.Err() != nil {
+					return
+				}
+			}
+			close(mongoUp)
+			if err := db.RunMigrations(ctx, client, cfg); err != nil {
+				logger.Error("Failed to run migrations: %v", err)
+				return
+			}
+			migrated.Store(true)
+		})
+	} else if err := db.RunMigrations(ctx, client, cfg); err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
+	} else {
+		close(mongoUp)
+		migrated.Store(true)
+	}
+	// whenMongoUp runs fn, such as creating indexes, straight away if
+	// MongoDB is reachable, or else in the background once it is
+	whenMongoUp := func(fn func(ctx context.Context)) {
+		select {
+		case <-mongoUp:
+			fn(ctx)
+		default:
+			goBackground(func(ctx context.Context) {
+				select {
+				case <-mongoUp:
+					fn(ctx)
+				case <-ctx.Done():
+				}
+			})
+		}
+	}
+
+	// Record when each fetch param was last stored, to report stale ones
+	freshness := staleness.NewMongoStore(client, cfg.DBCoordination, cfg.CollectionParamFreshness)
+	whenMongoUp(func(ctx context.Context) {
+		if err := freshness.EnsureIndexes(ctx, cfg.StalenessRetention); err != nil {
+			logger.Error("Failed to prepare param freshness: %v", err)
+		}
+	})
+	tracker := staleness.New(freshness, cfg.StalenessThreshold, cfg.StalenessRetention)
+	if cfg.AlertWebhookURL != "" {
+		tracker.Alerter = alert.NewWebhook(cfg.AlertWebhookURL)
+	}
+	goBackground(func(ctx context.Context) { tracker.Run(ctx, cfg.StalenessCheckInterval) })
+
+	weatherSvc := weather.NewService(cfg)
+	timeSvc := worldtime.NewService(cfg)
+	countrySvc := country.NewService(cfg)
+	aqiSvc := aqi.NewService(cfg)
+	if sp != nil {
+		weatherSvc.Spool = sp
+		timeSvc.Spool = sp
+		countrySvc.Spool = sp
+		aqiSvc.Spool = sp
+		// A spooled record is a success for its fetch param once replayed
+		sp.Outcomes = tracker
+		metrics.Gauge("spool_pending", "Records in the spool waiting for MongoDB.", nil, func() float64 {
+			return float64(sp.Pending())
+		})
+		goBackground(func(ctx context.Context) { sp.Run(ctx, client, cfg.SpoolReplayInterval) })
 	}
 
+	services := []scheduler.SchedulableService{weatherSvc, timeSvc, countrySvc, aqiSvc}
+	// services := []scheduler.SchedulableService{aqiSvc}
+	apiClients := []*api.Client{weatherSvc.Client, timeSvc.Client, countrySvc.Client, aqiSvc.Client}
+	rateLimits := []models.RateLimitSettings{weather.DefaultRateLimit, worldtime.DefaultRateLimit, country.DefaultRateLimit, aqi.DefaultRateLimit}
+
+	// SIGHUP and POST /reload load the configuration again and apply the
+	// settings registered with Live; the rest need a restart
+	reloader := reload.New(cfg, func() (*config.Config, error) { return config.Load(os.Args[1:]) })
+	reloader.Live(func(cfg *config.Config) error {
+		logger.SetLevels(cfg.LogLevel, cfg.LogLevels)
+		return nil
+	}, "LogLevel", "LogLevels")
+	reloader.Live(func(cfg *config.Config) error {
+		tracker.SetThreshold(cfg.StalenessThreshold)
+		return nil
+	}, "StalenessThreshold")
+
 	// chans := channels.New()
 
 	// wp := workpool.New(chans, 30)
 	// wp.Start(ctx)
-	// Create 1 channels + 1 workerpool for each service
+	// Create 1 channels for each service, served by 1 workerpool each or by a shared one
 	chanList := make([]*channels.Channels, 0)
 	wpList := make([]*workpool.WorkerPool, 0)
+	var autoscalers []*workpool.Autoscaler
 
-	// One per service
-	for i := 0; i < 4; i++ {
+	serviceNames := []string{weatherSvc.DBName, timeSvc.DBName, countrySvc.DBName, aqiSvc.DBName}
+	autoscale := workpool.AutoscaleConfig{
+		Min:      cfg.WorkerMin,
+		Max:      cfg.WorkerMax,
+		Interval: cfg.AutoscaleInterval,
+	}
+
+	for i := range services {
 		ch := channels.New()
 		chanList = append(chanList, ch)
+		labels := map[string]string{"service": serviceNames[i]}
+		metrics.Gauge("workpool_queued", "Requests waiting for a worker.", labels, func() float64 {
+			return float64(ch.Stats("").Queued)
+		})
+		metrics.Gauge("workpool_in_flight", "Requests being processed.", labels, func() float64 {
+			return float64(ch.Stats("").InFlight)
+		})
+	}
+	reloader.Live(func(cfg *config.Config) error {
+		for i, c := range apiClients {
+			if err := c.SetRateLimit(cfg.RateLimit(serviceNames[i], rateLimits[i])); err != nil {
+				return fmt.Errorf("%s: %w", serviceNames[i], err)
+			}
+		}
+		return nil
+	}, "RateLimits")
+
+	var resumer *queue.Resumer
+	if cfg.DurableQueue {
+		// Journal every request in Mongo so an interrupted run resumes after restart
+		q := queue.New(client, cfg.DBCoordination, cfg.CollectionQueue, cfg.InstanceID, cfg.QueueVisibility)
+		if err := q.EnsureIndexes(ctx); err != nil {
+			log.Fatalf("Failed to prepare job queue: %v", err)
+		}
+
+		builders := []scheduler.RequestBuilder{weatherSvc, timeSvc, countrySvc, aqiSvc}
+		targets := make(map[string]queue.Target)
+		for i, ch := range chanList {
+			ch.Journal = q
+			b := builders[i]
+			targets[serviceNames[i]] = queue.Target{
+				Channels: ch,
+				Build:    func(id string) models.DataRequest { return b.NewRequest(client, id) },
+			}
+		}
+
+		// Keep the leases alive until the pools have drained
+		goBackground(q.KeepAlive)
+		resumer = &queue.Resumer{Queue: q, Targets: targets, Interval: cfg.QueueVisibility}
+	}
 
-		wp := workpool.New(ch, 5)
+	if cfg.SharedPool {
+		// One pool for all services, shared fairly by weight
+		fair := workpool.NewFairQueue()
+		for i, ch := range chanList {
+			fair.Add(ch, cfg.ServiceWeights[serviceNames[i]])
+		}
+
+		wp := workpool.NewShared(fair, cfg.WorkerCount)
+		wp.Coalesce = cfg.CoalesceRequests
+		wp.Outcomes = tracker
 		wp.Start(ctx)
 		wpList = append(wpList, wp)
-	}
+		reloader.Live(func(cfg *config.Config) error {
+			for i, ch := range chanList {
+				fair.SetWeight(ch, cfg.ServiceWeights[serviceNames[i]])
+			}
+			return nil
+		}, "ServiceWeights")
 
-	weatherSvc := weather.NewService(cfg)
-	timeSvc := worldtime.NewService(cfg)
-	countrySvc := country.NewService(cfg)
-	aqiSvc := aqi.NewService(cfg)
+		if cfg.AutoscaleEnabled {
+			as := workpool.NewAutoscaler(wp, autoscale, func() api.Stats {
+				return api.SumStats(apiClients...)
+			})
+			goBackground(as.Run)
+			autoscalers = append(autoscalers, as)
+		}
+	} else {
+		// One per service
+		for i, ch := range chanList {
+			wp := workpool.New(ch, cfg.WorkerCount)
+			wp.Coalesce = cfg.CoalesceRequests
+			wp.Outcomes = tracker
+			wp.Start(ctx)
+			wpList = append(wpList, wp)
 
-	services := []scheduler.SchedulableService{weatherSvc, timeSvc, countrySvc, aqiSvc}
-	// services := []scheduler.SchedulableService{aqiSvc}
+			if cfg.AutoscaleEnabled {
+				as := workpool.NewAutoscaler(wp, autoscale, apiClients[i].Stats)
+				goBackground(as.Run)
+				autoscalers = append(autoscalers, as)
+			}
+		}
+	}
+	if cfg.AutoscaleEnabled {
+		reloader.Live(func(cfg *config.Config) error {
+			for _, as := range autoscalers {
+				as.SetLimits(cfg.WorkerMin, cfg.WorkerMax)
+			}
+			return nil
+		}, "WorkerMin", "WorkerMax")
+	} else {
+		// Removed workers finish their current request first
+		reloader.Live(func(cfg *config.Config) error {
+			for _, wp := range wpList {
+				wp.Resize(cfg.WorkerCount)
+			}
+			return nil
+		}, "WorkerCount")
+	}
 
 	sch, err := scheduler.New()
 	if err != nil {
 		log.Fatalf("Failed to initialize scheduler: %v", err)
 	}
+	sch.LocalTime = cfg.LocalTimeScheduling
+	if cfg.AlertWebhookURL != "" {
+		sch.Alerter = alert.NewWebhook(cfg.AlertWebhookURL)
+	}
+	applySchedule := func(cfg *config.Config) error {
+		settings, err := schedulerSettings(cfg)
+		if err != nil {
+			return err
+		}
+		return sch.Update(settings)
+	}
+	if err := applySchedule(cfg); err != nil {
+		log.Fatalf("Invalid scheduler settings: %v", err)
+	}
+	reloader.Live(applySchedule, "ScheduleAt", "CatchUpPolicy", "CatchUpMax", "RetryPasses", "RetryCooldown",
+		"PartialThreshold", "FailedThreshold", "AlertOn", "BlackoutWindows", "BlackoutPolicy")
+	maintenance := blackout.NewMaintenance(client, cfg.DBCoordination, cfg.CollectionMaintenance, cfg.InstanceID)
+	sch.Maintenance = maintenance
+	runHistory := history.New(client, cfg.DBCoordination, cfg.CollectionRunHistory, cfg.InstanceID)
+	whenMongoUp(func(ctx context.Context) {
+		if err := runHistory.EnsureIndexes(ctx); err != nil {
+			logger.Error("Failed to prepare run history: %v", err)
+		}
+	})
+	sch.History = runHistory
+	if cfg.Cluster {
+		// Split fetch params between the live instances
+		membership := cluster.NewMembership(client, cfg.DBCoordination, cfg.CollectionMembers, cfg.InstanceID, cfg.MemberTTL)
+		if err := membership.EnsureIndexes(ctx); err != nil {
+			log.Fatalf("Failed to prepare cluster membership: %v", err)
+		}
+		if err := membership.Heartbeat(ctx); err != nil {
+			log.Fatalf("Failed to join cluster: %v", err)
+		}
+		goBackground(membership.Run)
+		sch.Cluster = membership
+		sch.Settle = cfg.MemberTTL
+	}
+	if cfg.BatchLock && cfg.Cluster {
+		logger.Info("BATCH_LOCK is ignored with CLUSTER, every instance runs its own share of each batch.")
+	} else if cfg.BatchLock {
+		// Only one instance runs a given service's batch at a time
+		sch.Locker = &lock.Locker{
+			Store: lock.NewMongoStore(client, cfg.DBCoordination, cfg.CollectionLocks),
+			Owner: cfg.InstanceID,
+			TTL:   cfg.LockTTL,
+		}
+	}
+	if resumer != nil {
+		// Resumed requests are held to the same pauses, blackouts, shares
+		// and batch leases as the batches they came from
+		resumer.Gate = sch
+		goProducer(resumer.Run)
+	}
+
+	// Shut down in order: stop taking triggers, stop the runs and other
+	// producers, drain what they queued, then stop the background tasks
+	// that keep leases and membership alive, and disconnect last.
+	var adminDone chan struct{}
+	stopAdmin := func() {}
+	shutdown.Add("stop admin API", 10*time.Second, func(ctx context.Context) error {
+		stopAdmin()
+		if adminDone != nil {
+			<-adminDone
+		}
+		return nil
+	})
+	shutdown.Add("stop scheduler", 30*time.Second, sch.Shutdown)
+	shutdown.Add("stop producers", 10*time.Second, func(ctx context.Context) error {
+		stopProducers()
+		producers.Wait()
+		return nil
+	})
+	shutdown.Add("drain worker pools", cfg.DrainTimeout, func(drainCtx context.Context) error {
+		// Closing the channels makes late submits fail instead of blocking
+		for _, wp := range wpList {
+			wp.Stop()
+		}
+		for _, ch := range chanList {
+			if err := ch.Wait(drainCtx, ""); err != nil {
+				pending := 0
+				for _, ch := range chanList {
+					st := ch.Stats("")
+					pending += st.Queued + st.InFlight
+				}
+				// Abort what is left; journaled requests stay queued for the next start
+				cancel()
+				return fmt.Errorf("%d requests not finished: %w", pending, err)
+			}
+		}
+		return nil
+	})
+	shutdown.Add("stop background tasks", 10*time.Second, func(ctx context.Context) error {
+		stopBackground()
+		background.Wait()
+		return nil
+	})
+	if sp != nil {
+		shutdown.Add("close spool", 5*time.Second, func(ctx context.Context) error {
+			if n := sp.Pending(); n > 0 {
+				logger.Info("%d spooled records are replayed at the next start.", n)
+			}
+			return sp.Close()
+		})
+	}
+	shutdown.Add("disconnect MongoDB", 10*time.Second, func(ctx context.Context) error {
+		return db.DisconnectMongoDB(ctx, client)
+	})
+	shutdown.Add("flush traces", 5*time.Second, stopTracing)
+
+	// wait blocks until SIGINT or SIGTERM and shuts down. A second signal
+	// exits straight away.
+	wait := func() {
+		sig := <-quit
+		logger.Info("Received %s. Shutting down gracefully...", sig)
+		go func() {
+			sig := <-quit
+			logger.Error("Received %s again, exiting without waiting.", sig)
+			os.Exit(1)
+		}()
+		if err := shutdown.Shutdown(context.Background()); err != nil {
+			logger.Error("Shutdown finished with errors: %v", err)
+			os.Exit(1)
+		}
+		logger.Info("Shutdown complete.")
+	}
+
+	if cfg.OneShot {
+		// Run every service once and report the outcome through the exit code
+		done := make(chan struct{})
+		go func() {
+			select {
+			case sig := <-quit:
+				logger.Info("Received %s. Cancelling the run...", sig)
+				if err := sch.Shutdown(context.Background()); err != nil {
+					logger.Error("Error stopping the run: %v", err)
+				}
+			case <-done:
+			}
+		}()
+		result := sch.RunImmediateJob(ctx, client, chanList, services)
+		close(done)
+		if err := shutdown.Shutdown(context.Background()); err != nil {
+			logger.Error("Shutdown finished with errors: %v", err)
+		}
+		logger.Info("One-shot run %s finished: %s", result.RunID, result.Status)
+		os.Exit(result.Status.ExitCode())
+	}
 
 	if err := sch.StartJob(ctx, client, chanList, services); err != nil {
 		log.Fatalf("Failed to start scheduler job: %v", err)
 	}
 
-	logger.Info("Executing immediate startup data fetch and store.")
-	sch.RunImmediateJob(ctx, client, chanList, services)
+	goBackground(func(ctx context.Context) { reloader.Run(ctx, hup) })
 
-	<-quit
-	logger.Info("Received interrupt signal. Shutting down gracefully...")
+	if cfg.AdminAddr != "" {
+		adminSrv := admin.NewServer(cfg.AdminAddr, cfg.AdminToken, sch)
+		adminSrv.HandleMaintenance(maintenance)
+		adminSrv.HandleReload(reloader)
+		adminSrv.Handle("GET /metrics", metrics.Handler())
+		health := &admin.Health{
+			Checks: map[string]func(ctx context.Context) error{
+				"mongo": func(ctx context.Context) error { return client.Ping(ctx, nil) },
+				"migrations": func(context.Context) error {
+					if !migrated.Load() {
+						return errors.New("not applied yet")
+					}
+					return nil
+				},
+				"scheduler": func(context.Context) error {
+					if !sch.Active() {
+						return errors.New("not running")
+					}
+					return nil
+				},
+			},
+			Queues: func() map[string]channels.Stats {
+				queues := make(map[string]channels.Stats, len(chanList))
+				for i, ch := range chanList {
+					queues[serviceNames[i]] = ch.Stats("")
+				}
+				return queues
+			},
+			Upstream: func() map[string]api.Stats {
+				stats := make(map[string]api.Stats, len(apiClients))
+				for i, c := range apiClients {
+					stats[serviceNames[i]] = c.Stats()
+				}
+				return stats
+			},
+		}
+		if sp != nil {
+			health.SpoolPending = sp.Pending
+		}
+		health.Stale = func() []staleness.Param { return tracker.Stale(time.Now()) }
+		adminSrv.HandleHealth(health)
+		var adminCtx context.Context
+		adminCtx, stopAdmin = context.WithCancel(ctx)
+		adminDone = make(chan struct{})
+		go func() {
+			defer close(adminDone)
+			if err := adminSrv.Run(adminCtx); err != nil {
+				logger.Error("Admin API stopped: %v", err)
+			}
+		}()
+	}
 
-	sch.Cron.Stop()
+	// Only fetch on startup what the schedule says is overdue. This runs in
+	// the background so a signal during catch-up is handled straight away.
+	logger.Info("Checking run history for missed runs.")
+	go sch.CatchUp(ctx, client, chanList, services)
 
-	logger.Info("Waiting for pending worker jobs to finish...")
+	wait()
+}
 
-	// Stop all workerpools
-	for _, wp := range wpList {
-		wp.Stop()
+// schedulerSettings turns the scheduling settings of cfg into the
+// scheduler's, at startup and on every reload.
+func schedulerSettings(cfg *config.Config) (scheduler.Settings, error) {
+	settings := scheduler.Settings{
+		At:            cfg.ScheduleAt,
+		MaxCatchUp:    cfg.CatchUpMax,
+		RetryPasses:   cfg.RetryPasses,
+		RetryCooldown: cfg.RetryCooldown,
+		Thresholds:    scheduler.Thresholds{Partial: cfg.PartialThreshold, Failed: cfg.FailedThreshold},
 	}
-
-	// Wait for remaining work
-	for _, ch := range chanList {
-		ch.WG.Wait()
+	var err error
+	if settings.AlertOn, err = scheduler.ParseRunStatus(cfg.AlertOn); err != nil {
+		return settings, fmt.Errorf("ALERT_ON: %w", err)
 	}
-
-	logger.Info("All worker jobs finished. Shutdown complete.")
+	if settings.Policy, err = scheduler.ParseCatchUpPolicy(cfg.CatchUpPolicy); err != nil {
+		return settings, fmt.Errorf("CATCHUP_POLICY: %w", err)
+	}
+	if settings.Blackouts, err = blackout.Parse(cfg.BlackoutWindows); err != nil {
+		return settings, fmt.Errorf("BLACKOUT_WINDOWS: %w", err)
+	}
+	if settings.BlackoutPolicy, err = scheduler.ParseBlackoutPolicy(cfg.BlackoutPolicy); err != nil {
+		return settings, fmt.Errorf("BLACKOUT_POLICY: %w", err)
+	}
+	if cfg.OneShot && settings.BlackoutPolicy == scheduler.BlackoutDefer {
+		// The process is gone by the time a deferred run would start
+		settings.BlackoutPolicy = scheduler.BlackoutSkip
+	}
+	return settings, nil
 }
@@ -0,0 +1,105 @@
+// Command secretsctl makes and reads the encrypted SECRETS_FILE the app
+// loads at startup.
+//
+//	secretsctl [-key FILE] <command> [file]
+//
+// Commands:
+//
+//	keygen         print a new key; keep it out of the image and the repo
+//	seal [file]    encrypt a .env-format file (or stdin) to stdout
+//	open [file]    decrypt a sealed file (or stdin) to stdout
+//
+// The key is read from -key, else SECRETS_KEY_FILE, else SECRETS_KEY.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/secrets"
+)
+
+func main() {
+	keyFile := flag.String("key", os.Getenv("SECRETS_KEY_FILE"), "file holding the base64 key")
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := run(*keyFile, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "secretsctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: secretsctl [-key FILE] keygen | seal [file] | open [file]")
+	flag.PrintDefaults()
+}
+
+func run(keyFile string, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, rest := args[0], args[1:]
+	if cmd == "keygen" && len(rest) == 0 {
+		key, err := secrets.GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	}
+	if cmd != "seal" && cmd != "open" {
+		usage()
+		os.Exit(2)
+	}
+
+	key, err := readKey(keyFile)
+	if err != nil {
+		return err
+	}
+	in := io.Reader(os.Stdin)
+	if len(rest) == 1 {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	if cmd == "seal" {
+		out, err = secrets.Seal(key, data)
+	} else {
+		out, err = secrets.Open(key, data)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+func readKey(keyFile string) ([]byte, error) {
+	encoded := os.Getenv("SECRETS_KEY")
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, errors.New("no key: pass -key or set SECRETS_KEY_FILE or SECRETS_KEY")
+	}
+	return secrets.ParseKey(encoded)
+}
@@ -0,0 +1,244 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/blackout"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/reload"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/scheduler"
+)
+
+// shutdownTimeout bounds how long in-flight admin requests get on shutdown.
+const shutdownTimeout = 5 * time.Second
+
+// Controller is the part of the scheduler the admin API drives.
+type Controller interface {
+	Jobs() []scheduler.JobInfo
+	Progress() []scheduler.RunProgress
+	Trigger(service string) (string, error)
+	TriggerID(service, id string) (string, error)
+	Pause(service string) error
+	Resume(service string) error
+}
+
+// Server exposes the admin API over HTTP. Other endpoints can be added to
+// the same listener with Handle.
+type Server struct {
+	Addr string
+	// Token, when set, must be sent as a bearer token with every request.
+	Token string
+
+	ctl Controller
+	mux *http.ServeMux
+	// public holds the paths served without a token
+	public map[string]bool
+}
+
+func NewServer(addr, token string, ctl Controller) *Server {
+	s := &Server{Addr: addr, Token: token, ctl: ctl, mux: http.NewServeMux(), public: make(map[string]bool)}
+
+	s.mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, ctl.Jobs())
+	})
+	s.mux.HandleFunc("GET /runs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, ctl.Progress())
+	})
+	s.mux.HandleFunc("POST /jobs/{service}/trigger", func(w http.ResponseWriter, r *http.Request) {
+		runID, err := ctl.Trigger(r.PathValue("service"))
+		writeRun(w, runID, err)
+	})
+	s.mux.HandleFunc("POST /jobs/{service}/trigger/{id}", func(w http.ResponseWriter, r *http.Request) {
+		runID, err := ctl.TriggerID(r.PathValue("service"), r.PathValue("id"))
+		writeRun(w, runID, err)
+	})
+	s.mux.HandleFunc("POST /jobs/{service}/pause", func(w http.ResponseWriter, r *http.Request) {
+		writeAction(w, ctl.Pause(r.PathValue("service")))
+	})
+	s.mux.HandleFunc("POST /jobs/{service}/resume", func(w http.ResponseWriter, r *http.Request) {
+		writeAction(w, ctl.Resume(r.PathValue("service")))
+	})
+	return s
+}
+
+// MaintenanceStore reads and switches the global maintenance mode.
+type MaintenanceStore interface {
+	Current(ctx context.Context) (blackout.Mode, error)
+	Set(ctx context.Context, mode blackout.Mode) (blackout.Mode, error)
+}
+
+// maintenanceRequest switches maintenance on. Until is optional.
+type maintenanceRequest struct {
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+	SetBy  string    `json:"set_by"`
+}
+
+// HandleMaintenance adds GET /maintenance to show the maintenance mode, PUT
+// to switch it on and DELETE to switch it off.
+func (s *Server) HandleMaintenance(m MaintenanceStore) {
+	s.mux.HandleFunc("GET /maintenance", func(w http.ResponseWriter, r *http.Request) {
+		mode, err := m.Current(r.Context())
+		writeMode(w, mode, err)
+	})
+	s.mux.HandleFunc("PUT /maintenance", func(w http.ResponseWriter, r *http.Request) {
+		// The body is optional
+		var req maintenanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+			return
+		}
+		if !req.Until.IsZero() && !req.Until.After(time.Now()) {
+			writeError(w, http.StatusBadRequest, errors.New("until is in the past"))
+			return
+		}
+		mode, err := m.Set(r.Context(), blackout.Mode{Enabled: true, Reason: req.Reason, Until: req.Until, SetBy: req.SetBy})
+		if err == nil {
+			logger.Info("Maintenance switched on by %s: %s", mode.SetBy, mode.Reason)
+		}
+		writeMode(w, mode, err)
+	})
+	s.mux.HandleFunc("DELETE /maintenance", func(w http.ResponseWriter, r *http.Request) {
+		mode, err := m.Set(r.Context(), blackout.Mode{SetBy: r.URL.Query().Get("set_by")})
+		if err == nil {
+			logger.Info("Maintenance switched off by %s.", mode.SetBy)
+		}
+		writeMode(w, mode, err)
+	})
+}
+
+// Reloader reloads the configuration, as SIGHUP does.
+type Reloader interface {
+	Reload() (reload.Result, error)
+}
+
+// HandleReload adds POST /reload to reload the configuration. A
+// configuration that does not load is rejected with 422.
+func (s *Server) HandleReload(rl Reloader) {
+	s.mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		res, err := rl.Reload()
+		switch {
+		case errors.Is(err, reload.ErrInvalid):
+			writeError(w, http.StatusUnprocessableEntity, err)
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err)
+		default:
+			writeJSON(w, http.StatusOK, res)
+		}
+	})
+}
+
+// Handle registers an additional endpoint.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// Handler returns the API with token checking applied, except to the paths
+// made public.
+func (s *Server) Handler() http.Handler {
+	if s.Token == "" {
+		return s.mux
+	}
+	want := []byte("Bearer " + s.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.public[r.URL.Path] {
+			s.mux.ServeHTTP(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		s.mux.ServeHTTP(w, r)
+	})
+}
+
+// Run serves until ctx is cancelled.
+func (s *Server) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.Addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		logger.Info("Admin API listening on %s", s.Addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+type runResponse struct {
+	RunID string `json:"run_id"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeRun(w http.ResponseWriter, runID string, err error) {
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, runResponse{RunID: runID})
+}
+
+func writeMode(w http.ResponseWriter, mode blackout.Mode, err error) {
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, mode)
+}
+
+func writeAction(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, scheduler.ErrUnknownService):
+		return http.StatusNotFound
+	case errors.Is(err, scheduler.ErrPaused), errors.Is(err, scheduler.ErrRunning), errors.Is(err, scheduler.ErrBlackedOut):
+		return http.StatusConflict
+	case errors.Is(err, scheduler.ErrNoSingleID):
+		return http.StatusBadRequest
+	case errors.Is(err, scheduler.ErrNotStarted), errors.Is(err, scheduler.ErrStopped):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("Failed to write admin response: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/blackout"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/reload"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/scheduler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeController records the calls made by the API.
+type fakeController struct {
+	calls []string
+	err   error
+}
+
+func (f *fakeController) Jobs() []scheduler.JobInfo {
+	return []scheduler.JobInfo{{Service: "weather_db"}}
+}
+
+func (f *fakeController) Progress() []scheduler.RunProgress {
+	return []scheduler.RunProgress{{Service: "weather_db", RunID: "run-1", Queued: 3}}
+}
+
+func (f *fakeController) Trigger(service string) (string, error) {
+	f.calls = append(f.calls, "trigger "+service)
+	return "run-1", f.err
+}
+
+func (f *fakeController) TriggerID(service, id string) (string, error) {
+	f.calls = append(f.calls, "trigger "+service+" "+id)
+	return "run-2", f.err
+}
+
+func (f *fakeController) Pause(service string) error {
+	f.calls = append(f.calls, "pause "+service)
+	return f.err
+}
+
+func (f *fakeController) Resume(service string) error {
+	f.calls = append(f.calls, "resume "+service)
+	return f.err
+}
+
+func TestServer_Routes(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		err        error
+		wantStatus int
+		wantCall   string
+		wantBody   string
+	}{
+		{"GET", "/jobs", nil, http.StatusOK, "", `"service":"weather_db"`},
+		{"GET", "/runs", nil, http.StatusOK, "", `"queued":3`},
+		{"POST", "/jobs/weather_db/trigger", nil, http.StatusAccepted, "trigger weather_db", `"run_id":"run-1"`},
+		{"POST", "/jobs/weather_db/trigger/Kabul", nil, http.StatusAccepted, "trigger weather_db Kabul", `"run_id":"run-2"`},
+		{"POST", "/jobs/weather_db/pause", nil, http.StatusNoContent, "pause weather_db", ""},
+		{"POST", "/jobs/weather_db/resume", nil, http.StatusNoContent, "resume weather_db", ""},
+		{"POST", "/jobs/nope/trigger", scheduler.ErrUnknownService, http.StatusNotFound, "trigger nope", "unknown service"},
+		{"POST", "/jobs/weather_db/trigger", scheduler.ErrRunning, http.StatusConflict, "trigger weather_db", "already running"},
+		{"POST", "/jobs/weather_db/trigger/Kabul", scheduler.ErrBlackedOut, http.StatusConflict, "trigger weather_db Kabul", "blackout"},
+		{"GET", "/jobs/weather_db/trigger", nil, http.StatusMethodNotAllowed, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			ctl := &fakeController{err: tt.err}
+			srv := NewServer("", "", ctl)
+
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantCall != "" {
+				assert.Equal(t, []string{tt.wantCall}, ctl.calls)
+			}
+			assert.Contains(t, rec.Body.String(), tt.wantBody)
+		})
+	}
+}
+
+func TestServer_Token(t *testing.T) {
+	srv := NewServer("", "secret", &fakeController{})
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/jobs", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest("GET", "/jobs", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var jobs []scheduler.JobInfo
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&jobs))
+	assert.Len(t, jobs, 1)
+}
+
+func TestServer_Handle(t *testing.T) {
+	srv := NewServer("", "", &fakeController{})
+	srv.Handle("GET /extra", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/extra", nil))
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+}
+
+// fakeMaintenance keeps the mode in memory.
+type fakeMaintenance struct {
+	mode blackout.Mode
+}
+
+func (f *fakeMaintenance) Current(ctx context.Context) (blackout.Mode, error) {
+	return f.mode, nil
+}
+
+func (f *fakeMaintenance) Set(ctx context.Context, mode blackout.Mode) (blackout.Mode, error) {
+	f.mode = mode
+	return mode, nil
+}
+
+func TestServer_Maintenance(t *testing.T) {
+	m := &fakeMaintenance{}
+	srv := NewServer("", "", &fakeController{})
+	srv.HandleMaintenance(m)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	rec := do("PUT", "/maintenance", `{"reason":"reindexing","until":"`+until.Format(time.RFC3339)+`","set_by":"ops"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.True(t, m.mode.Active(time.Now()))
+	assert.Equal(t, "reindexing", m.mode.Reason)
+	assert.Equal(t, "ops", m.mode.SetBy)
+	assert.True(t, until.Equal(m.mode.Until))
+
+	rec = do("GET", "/maintenance", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"enabled":true`)
+
+	rec = do("DELETE", "/maintenance", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, m.mode.Enabled)
+
+	// No body switches it on until switched off
+	rec = do("PUT", "/maintenance", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, m.mode.Active(time.Now().Add(24*time.Hour)))
+
+	rec = do("PUT", "/maintenance", `{"until":"2020-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do("PUT", "/maintenance", `{`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// fakeReloader returns a canned outcome.
+type fakeReloader struct {
+	res reload.Result
+	err error
+}
+
+func (f *fakeReloader) Reload() (reload.Result, error) {
+	return f.res, f.err
+}
+
+func TestServer_Reload(t *testing.T) {
+	rl := &fakeReloader{res: reload.Result{Applied: []string{"WorkerCount"}, Restart: []string{"AdminAddr"}}}
+	srv := NewServer("", "", &fakeController{})
+	srv.HandleReload(rl)
+	do := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/reload", nil))
+		return rec
+	}
+
+	rec := do()
+	require.Equal(t, http.StatusOK, rec.Code)
+	var res reload.Result
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	assert.Equal(t, rl.res, res)
+
+	rl.err = fmt.Errorf("%w: WORKER_COUNT=0 must be at least 1", reload.ErrInvalid)
+	rec = do()
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "WORKER_COUNT=0")
+
+	rl.err = errors.New("boom")
+	rec = do()
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
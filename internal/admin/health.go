@@ -0,0 +1,157 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/api"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/scheduler"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/staleness"
+)
+
+// checkTimeout bounds each readiness check.
+const checkTimeout = 2 * time.Second
+
+// Health feeds the health, readiness and status endpoints.
+type Health struct {
+	// Checks must all pass, by name, for the app to be ready
+	Checks map[string]func(ctx context.Context) error
+	// Queues returns the requests queued and in flight by service
+	Queues func() map[string]channels.Stats
+	// Upstream returns the API client statistics by service
+	Upstream func() map[string]api.Stats
+	// SpoolPending, if set, returns how many records wait for MongoDB
+	SpoolPending func() int
+	// Stale, if set, returns the fetch params without a recent success
+	Stale func() []staleness.Param
+}
+
+// readiness is the body of GET /readyz, with "ok" or the error of each check.
+type readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// ServiceStatus is a service in GET /status.
+type ServiceStatus struct {
+	scheduler.JobInfo
+	Queued   int             `json:"queued"`
+	InFlight int             `json:"in_flight"`
+	Upstream *UpstreamStatus `json:"upstream,omitempty"`
+}
+
+// UpstreamStatus is what a service's API client has seen.
+type UpstreamStatus struct {
+	Requests     int64   `json:"requests"`
+	Throttled    int64   `json:"throttled"`
+	Errors       int64   `json:"errors"`
+	AvgLatencyMS float64 `json:"avg_latency_ms"`
+}
+
+// Status is the body of GET /status.
+type Status struct {
+	Ready        bool              `json:"ready"`
+	Checks       map[string]string `json:"checks"`
+	Services     []ServiceStatus   `json:"services"`
+	SpoolPending *int              `json:"spool_pending,omitempty"`
+	Stale        []staleness.Param `json:"stale"`
+}
+
+// HandleHealth adds GET /healthz, which answers as long as the process
+// serves requests, GET /readyz, which fails with 503 until every check in h
+// passes, and GET /status with the readiness and the state of each service.
+// /healthz and /readyz need no token, so probes can reach them.
+func (s *Server) HandleHealth(h *Health) {
+	s.public["/healthz"] = true
+	s.public["/readyz"] = true
+
+	s.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	s.mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready := h.ready(r.Context())
+		status := http.StatusOK
+		if !ready.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, ready)
+	})
+	s.mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, h.status(r.Context(), s.ctl.Jobs()))
+	})
+}
+
+// ready runs the checks concurrently.
+func (h *Health) ready(ctx context.Context) readiness {
+	res := readiness{Ready: true, Checks: make(map[string]string, len(h.Checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range h.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			result := "ok"
+			if err := check(ctx); err != nil {
+				result = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.Checks[name] = result
+			if result != "ok" {
+				res.Ready = false
+			}
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+func (h *Health) status(ctx context.Context, jobs []scheduler.JobInfo) Status {
+	ready := h.ready(ctx)
+	st := Status{Ready: ready.Ready, Checks: ready.Checks}
+	var queues map[string]channels.Stats
+	if h.Queues != nil {
+		queues = h.Queues()
+	}
+	var upstream map[string]api.Stats
+	if h.Upstream != nil {
+		upstream = h.Upstream()
+	}
+
+	for _, job := range jobs {
+		svc := ServiceStatus{JobInfo: job}
+		// Time-zone buckets share their service's queue and client
+		name, _, _ := strings.Cut(job.Service, "@")
+		if q, ok := queues[name]; ok {
+			svc.Queued, svc.InFlight = q.Queued, q.InFlight
+		}
+		if u, ok := upstream[name]; ok {
+			svc.Upstream = &UpstreamStatus{
+				Requests:     u.Requests,
+				Throttled:    u.Throttled,
+				Errors:       u.Errors,
+				AvgLatencyMS: float64(u.AvgLatency) / float64(time.Millisecond),
+			}
+		}
+		st.Services = append(st.Services, svc)
+	}
+
+	if h.SpoolPending != nil {
+		n := h.SpoolPending()
+		st.SpoolPending = &n
+	}
+	st.Stale = []staleness.Param{}
+	if h.Stale != nil {
+		if stale := h.Stale(); stale != nil {
+			st.Stale = stale
+		}
+	}
+	return st
+}
@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/api"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/staleness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Health(t *testing.T) {
+	var migrated bool
+	srv := NewServer("", "secret", &fakeController{})
+	srv.HandleHealth(&Health{
+		Checks: map[string]func(ctx context.Context) error{
+			"mongo": func(ctx context.Context) error { return nil },
+			"migrations": func(ctx context.Context) error {
+				if !migrated {
+					return errors.New("not applied yet")
+				}
+				return nil
+			},
+		},
+		Queues: func() map[string]channels.Stats {
+			return map[string]channels.Stats{"weather_db": {Queued: 4, InFlight: 2}}
+		},
+		Upstream: func() map[string]api.Stats {
+			return map[string]api.Stats{"weather_db": {Requests: 10, Throttled: 1, AvgLatency: 250 * time.Millisecond}}
+		},
+		SpoolPending: func() int { return 7 },
+		Stale: func() []staleness.Param {
+			return []staleness.Param{{Service: "weather_db", Param: "Lahore", LastError: "status 503"}}
+		},
+	})
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Probes need no token
+	assert.Equal(t, http.StatusOK, get("/healthz", "").Code)
+
+	rec := get("/readyz", "")
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var ready readiness
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&ready))
+	assert.False(t, ready.Ready)
+	assert.Equal(t, map[string]string{"mongo": "ok", "migrations": "not applied yet"}, ready.Checks)
+
+	migrated = true
+	assert.Equal(t, http.StatusOK, get("/readyz", "").Code)
+
+	// The status does
+	assert.Equal(t, http.StatusUnauthorized, get("/status", "").Code)
+	rec = get("/status", "secret")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var st Status
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&st))
+	assert.True(t, st.Ready)
+	require.Len(t, st.Services, 1)
+	svc := st.Services[0]
+	assert.Equal(t, "weather_db", svc.Service)
+	assert.Equal(t, 4, svc.Queued)
+	assert.Equal(t, 2, svc.InFlight)
+	require.NotNil(t, svc.Upstream)
+	assert.Equal(t, UpstreamStatus{Requests: 10, Throttled: 1, AvgLatencyMS: 250}, *svc.Upstream)
+	require.NotNil(t, st.SpoolPending)
+	assert.Equal(t, 7, *st.SpoolPending)
+	require.Len(t, st.Stale, 1)
+	assert.Equal(t, "Lahore", st.Stale[0].Param)
+	assert.Equal(t, "status 503", st.Stale[0].LastError)
+}
@@ -0,0 +1,82 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/scheduler"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/staleness"
+)
+
+// Webhook posts run and stale param alerts as JSON. The payload carries a
+// human-readable "text" field, which chat webhooks such as Slack's display
+// as is, next to the full run summary or the stale params.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+type payload struct {
+	Text string               `json:"text"`
+	Run  scheduler.RunSummary `json:"run"`
+}
+
+type stalePayload struct {
+	Text  string            `json:"text"`
+	Stale []staleness.Param `json:"stale"`
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Alert implements scheduler.Alerter.
+func (w *Webhook) Alert(ctx context.Context, summary scheduler.RunSummary) error {
+	text := fmt.Sprintf("[%s] run %s %s: %d of %d requests failed", summary.Service, summary.RunID, summary.Status, summary.Failed, summary.Done)
+	if summary.Error != "" {
+		text += " (" + summary.Error + ")"
+	}
+
+	return w.post(ctx, payload{Text: text, Run: summary})
+}
+
+// AlertStale implements staleness.Alerter.
+func (w *Webhook) AlertStale(ctx context.Context, params []staleness.Param) error {
+	now := time.Now()
+	lines := make([]string, 0, len(params))
+	for _, p := range params {
+		line := fmt.Sprintf("[%s] %s has not been stored for %s", p.Service, p.Param, p.Age(now).Round(time.Minute))
+		if p.LastError != "" {
+			line += " (" + p.LastError + ")"
+		}
+		lines = append(lines, line)
+	}
+	return w.post(ctx, stalePayload{Text: strings.Join(lines, "\n"), Stale: params})
+}
+
+func (w *Webhook) post(ctx context.Context, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
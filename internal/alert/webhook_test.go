@@ -0,0 +1,64 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/scheduler"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/staleness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhook_Alert(t *testing.T) {
+	var got payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer srv.Close()
+
+	summary := scheduler.RunSummary{Service: "weather_db", RunID: "run-1", Status: scheduler.StatusFailed, Done: 10, Failed: 4}
+	require.NoError(t, NewWebhook(srv.URL).Alert(context.Background(), summary))
+
+	assert.Equal(t, summary.RunID, got.Run.RunID)
+	assert.True(t, strings.Contains(got.Text, "weather_db"), got.Text)
+	assert.True(t, strings.Contains(got.Text, "4 of 10"), got.Text)
+}
+
+func TestWebhook_AlertStale(t *testing.T) {
+	var got stalePayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	params := []staleness.Param{
+		{Service: "weather_db", Param: "Lahore", LastSuccess: now.Add(-72 * time.Hour), LastError: "status 503"},
+		{Service: "openaq_db", Param: "2178", FirstSeen: now.Add(-50 * time.Hour)},
+	}
+	require.NoError(t, NewWebhook(srv.URL).AlertStale(context.Background(), params))
+
+	require.Len(t, got.Stale, 2)
+	assert.Equal(t, "Lahore", got.Stale[0].Param)
+	lines := strings.Split(got.Text, "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, "[weather_db] Lahore has not been stored for 72h0m0s (status 503)", lines[0])
+	assert.Equal(t, "[openaq_db] 2178 has not been stored for 50h0m0s", lines[1])
+}
+
+func TestWebhook_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	err := NewWebhook(srv.URL).Alert(context.Background(), scheduler.RunSummary{})
+	assert.Error(t, err)
+}
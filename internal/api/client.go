@@ -7,32 +7,95 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/metrics"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/tracing"
 	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
 )
 
+// latencyWeight is how much a new sample moves the average latency.
+const latencyWeight = 0.2
+
 type Client struct {
 	httpClient *http.Client
-	rateLimit  models.RateLimitSettings
-	tokens     chan struct{} // token bucket
+	// Provider labels the client's metrics, e.g. weather_db
+	Provider string
+
+	// limitMu guards the token bucket, which SetRateLimit replaces. Closing
+	// changed tells waiters on the old bucket to move to the new one.
+	limitMu   sync.Mutex
+	rateLimit models.RateLimitSettings
+	tokens    chan struct{} // token bucket
+	changed   chan struct{}
+
+	mu    sync.Mutex
+	stats Stats
 }
 
+// Stats summarises what the client has seen from the upstream API.
+type Stats struct {
+	Requests  int64
+	Throttled int64 // 429 responses
+	Errors    int64 // network errors and 5xx responses
+	// AvgLatency is an exponentially weighted average of response times.
+	AvgLatency time.Duration
+}
+
+// ErrInvalidRateLimit is returned by SetRateLimit for settings without a
+// positive Interval.
+var ErrInvalidRateLimit = errors.New("api: invalid rate limit")
+
+// NewClient returns a client limited to rl, which must be valid; the
+// configuration checks it.
 func NewClient(rl models.RateLimitSettings) *Client {
-	bucket := make(chan struct{}, rl.MaxRequests)
+	c := &Client{httpClient: &http.Client{Timeout: 30 * time.Second}}
+	if err := c.SetRateLimit(rl); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// SetRateLimit replaces the client's rate limit. Tokens left in the old
+// bucket carry over, up to the new size, and requests waiting for a token
+// wait on the new bucket. Invalid settings leave the current limit in place.
+func (c *Client) SetRateLimit(rl models.RateLimitSettings) error {
+	interval := rl.Interval()
+	if interval <= 0 {
+		return fmt.Errorf("%w: %d requests per %v", ErrInvalidRateLimit, rl.MaxRequests, rl.PerDuration)
+	}
+
+	c.limitMu.Lock()
+	defer c.limitMu.Unlock()
 
-	// fill bucket initially
-	for i := 0; i < rl.MaxRequests; i++ {
+	// fill bucket initially, or with what is left of the old one
+	fill := rl.MaxRequests
+	if c.tokens != nil {
+		fill = min(len(c.tokens), rl.MaxRequests)
+		close(c.changed)
+	}
+	bucket := make(chan struct{}, rl.MaxRequests)
+	for i := 0; i < fill; i++ {
 		bucket <- struct{}{}
 	}
+	changed := make(chan struct{})
+	c.rateLimit, c.tokens, c.changed = rl, bucket, changed
 
-	// refill bucket at interval
+	// refill bucket at interval, until it is replaced
 	go func() {
-		ticker := time.NewTicker(rl.PerDuration / time.Duration(rl.MaxRequests))
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
-		for range ticker.C {
+		for {
+			select {
+			case <-changed:
+				return
+			case <-ticker.C:
+			}
 			select {
 			case bucket <- struct{}{}:
 			default:
@@ -40,23 +103,47 @@ func NewClient(rl models.RateLimitSettings) *Client {
 			}
 		}
 	}()
+	return nil
+}
 
-	return &Client{
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		rateLimit:  rl,
-		tokens:     bucket,
+// RateLimit returns the rate limit in effect.
+func (c *Client) RateLimit() models.RateLimitSettings {
+	c.limitMu.Lock()
+	defer c.limitMu.Unlock()
+	return c.rateLimit
+}
+
+// acquire takes a rate-limit token, following the bucket if SetRateLimit
+// replaces it in the meantime.
+func (c *Client) acquire(ctx context.Context) error {
+	for {
+		c.limitMu.Lock()
+		tokens, changed := c.tokens, c.changed
+		c.limitMu.Unlock()
+
+		select {
+		case <-tokens:
+			return nil
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 }
 
 func (c *Client) Do(ctx context.Context, url string, headers map[string]string) ([]byte, error) {
 	// Acquire a rate-limit token
-	select {
-	case <-c.tokens:
-	case <-ctx.Done():
-		return nil, ctx.Err()
+	_, span := tracing.Start(ctx, "rate_limit_wait", tracing.Provider.String(c.Provider))
+	waitStart := time.Now()
+	err := c.acquire(ctx)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
 	}
+	metrics.RateLimitWait.WithLabelValues(c.Provider).Observe(time.Since(waitStart).Seconds())
 
 	const maxRetries = 5
+	log := logger.From(ctx)
 
 	for i := 0; i < maxRetries; i++ {
 		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -68,31 +155,48 @@ func (c *Client) Do(ctx context.Context, url string, headers map[string]string)
 			req.Header.Set(k, v)
 		}
 
-		logger.Info("Making request to %s (attempt %d)", url, i+1)
+		log.Debug("Making request to %s (attempt %d)", url, i+1)
+		// The query is left out as it may hold an API key
+		_, span := tracing.Start(ctx, "GET", tracing.Provider.String(c.Provider), tracing.Attempt.Int(i+1),
+			semconv.HTTPRequestMethodGet, semconv.ServerAddress(req.URL.Hostname()), semconv.URLPath(req.URL.Path))
+		start := time.Now()
 		resp, err := c.httpClient.Do(req)
 
 		if err != nil {
 			// network error, retry with backoff
-			logger.Error("HTTP request failed (attempt %d): %v", i+1, err)
+			log.Warn("HTTP request failed (attempt %d): %v", i+1, err)
+			c.record(i+1, 0, time.Since(start))
+			tracing.End(span, err)
 
 			if ctx.Err() != nil {
 				return nil, ctx.Err()
 			}
 
-			backoff(i)
+			if err := backoff(ctx, i); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
+		c.record(i+1, resp.StatusCode, time.Since(start))
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+		var statusErr error
+		if resp.StatusCode != 200 {
+			statusErr = fmt.Errorf("API returned %d", resp.StatusCode)
+		}
+		tracing.End(span, statusErr)
 
 		if resp.StatusCode == 200 {
 			return body, nil
 		}
 
 		if resp.StatusCode == 429 || resp.StatusCode >= 500 {
-			logger.Error("Server returned %d → retry (attempt %d)", resp.StatusCode, i+1)
-			backoff(i)
+			log.Warn("Server returned %d → retry (attempt %d)", resp.StatusCode, i+1)
+			if err := backoff(ctx, i); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -103,9 +207,73 @@ func (c *Client) Do(ctx context.Context, url string, headers map[string]string)
 	return nil, errors.New("max retries exceeded")
 }
 
-func backoff(i int) {
-	// exponential backoff with jitter
+// Stats returns a snapshot of the client's counters.
+func (c *Client) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// SumStats combines the counters of several clients, e.g. for a pool shared
+// between services. AvgLatency is the mean of the clients that have one.
+func SumStats(clients ...*Client) Stats {
+	var sum Stats
+	var withLatency int
+	for _, c := range clients {
+		st := c.Stats()
+		sum.Requests += st.Requests
+		sum.Throttled += st.Throttled
+		sum.Errors += st.Errors
+		if st.AvgLatency > 0 {
+			sum.AvgLatency += st.AvgLatency
+			withLatency++
+		}
+	}
+	if withLatency > 0 {
+		sum.AvgLatency /= time.Duration(withLatency)
+	}
+	return sum
+}
+
+// record counts one attempt; status 0 means the request never got a response.
+func (c *Client) record(attempt, status int, latency time.Duration) {
+	label := "error"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	metrics.APIRequests.WithLabelValues(c.Provider, label, strconv.Itoa(attempt)).Inc()
+	metrics.APIRequestDuration.WithLabelValues(c.Provider).Observe(latency.Seconds())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Requests++
+	switch {
+	case status == http.StatusTooManyRequests:
+		c.stats.Throttled++
+	case status == 0 || status >= 500:
+		c.stats.Errors++
+	}
+
+	if c.stats.AvgLatency == 0 {
+		c.stats.AvgLatency = latency
+		return
+	}
+	c.stats.AvgLatency += time.Duration(latencyWeight * float64(latency-c.stats.AvgLatency))
+}
+
+func backoff(ctx context.Context, i int) error {
+	// exponential backoff with jitter, cut short if ctx is cancelled
 	base := time.Duration(1<<i) * time.Second
 	jitter := time.Duration(rand.Intn(500)) * time.Millisecond
-	time.Sleep(base + jitter)
+
+	timer := time.NewTimer(base + jitter)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
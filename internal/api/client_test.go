@@ -2,6 +2,7 @@ package api_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,9 +13,11 @@ import (
 	"time"
 
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/api"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/metrics"
 	"github.com/AbdulWasayUl/go-api-parser-mono/models"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 // load env from project root
@@ -184,3 +187,94 @@ func TestClient_Do_RateLimit(t *testing.T) {
 		t.Fatalf("rate limiting not enforced")
 	}
 }
+
+// Test that a new rate limit applies to requests already waiting for a token
+func TestClient_SetRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	}))
+	defer ts.Close()
+
+	client := api.NewClient(models.RateLimitSettings{MaxRequests: 1, PerDuration: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Do(ctx, ts.URL, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Do(ctx, ts.URL, nil)
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("second request was not held back by the rate limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	faster := models.RateLimitSettings{MaxRequests: 100, PerDuration: 100 * time.Millisecond}
+	if err := client.SetRateLimit(faster); err != nil {
+		t.Fatalf("SetRateLimit failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting request did not move to the new rate limit")
+	}
+	if got := client.RateLimit(); got != faster {
+		t.Errorf("expected rate limit %+v, got %+v", faster, got)
+	}
+
+	// Less than a nanosecond per request is refused rather than ticking at 0
+	tooFast := models.RateLimitSettings{MaxRequests: 2, PerDuration: time.Nanosecond}
+	if err := client.SetRateLimit(tooFast); !errors.Is(err, api.ErrInvalidRateLimit) {
+		t.Fatalf("expected ErrInvalidRateLimit, got %v", err)
+	}
+	if got := client.RateLimit(); got != faster {
+		t.Errorf("expected rate limit %+v to stay, got %+v", faster, got)
+	}
+}
+
+// Test that the client counts throttled responses and tracks latency
+func TestClient_Stats(t *testing.T) {
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if hits == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+		fmt.Fprintln(w, "ok")
+	}))
+	defer ts.Close()
+
+	client := api.NewClient(models.RateLimitSettings{MaxRequests: 5, PerDuration: time.Second})
+	client.Provider = "stats_test"
+	if _, err := client.Do(context.Background(), ts.URL, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stats := client.Stats()
+	if stats.Requests != 2 {
+		t.Errorf("expected 2 requests, got %d", stats.Requests)
+	}
+	if stats.Throttled != 1 {
+		t.Errorf("expected 1 throttled response, got %d", stats.Throttled)
+	}
+	if stats.AvgLatency <= 0 {
+		t.Errorf("expected a positive average latency, got %v", stats.AvgLatency)
+	}
+
+	// The attempts are also counted by status and attempt number
+	if n := testutil.ToFloat64(metrics.APIRequests.WithLabelValues("stats_test", "429", "1")); n != 1 {
+		t.Errorf("expected 1 throttled first attempt, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.APIRequests.WithLabelValues("stats_test", "200", "2")); n != 1 {
+		t.Errorf("expected 1 successful second attempt, got %v", n)
+	}
+}
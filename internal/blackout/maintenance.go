@@ -0,0 +1,60 @@
+package blackout
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maintenanceID is the _id of the single maintenance document.
+const maintenanceID = "maintenance"
+
+// Mode is the global maintenance switch. While it is active no service runs.
+type Mode struct {
+	Enabled bool   `bson:"enabled" json:"enabled"`
+	Reason  string `bson:"reason,omitempty" json:"reason,omitempty"`
+	// Until ends maintenance on its own; zero keeps it on until switched off.
+	Until time.Time `bson:"until,omitempty" json:"until,omitzero"`
+	SetBy string    `bson:"set_by,omitempty" json:"set_by,omitempty"`
+	SetAt time.Time `bson:"set_at,omitempty" json:"set_at,omitzero"`
+}
+
+// Active reports whether maintenance is on at now.
+func (m Mode) Active(now time.Time) bool {
+	return m.Enabled && (m.Until.IsZero() || now.Before(m.Until))
+}
+
+// Maintenance keeps the maintenance mode in Mongo so every instance sees it.
+type Maintenance struct {
+	Coll *mongo.Collection
+	// Instance is recorded as SetBy when a change does not name anyone.
+	Instance string
+}
+
+func NewMaintenance(client *mongo.Client, db, coll, instance string) *Maintenance {
+	return &Maintenance{Coll: client.Database(db).Collection(coll), Instance: instance}
+}
+
+// Current returns the stored mode; maintenance is off if none was ever set.
+func (m *Maintenance) Current(ctx context.Context) (Mode, error) {
+	var mode Mode
+	err := m.Coll.FindOne(ctx, bson.M{"_id": maintenanceID}).Decode(&mode)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Mode{}, nil
+	}
+	return mode, err
+}
+
+// Set stores mode, stamping when and by whom it was set.
+func (m *Maintenance) Set(ctx context.Context, mode Mode) (Mode, error) {
+	mode.SetAt = time.Now().UTC()
+	if mode.SetBy == "" {
+		mode.SetBy = m.Instance
+	}
+	_, err := m.Coll.ReplaceOne(ctx, bson.M{"_id": maintenanceID}, mode, options.Replace().SetUpsert(true))
+	return mode, err
+}
@@ -0,0 +1,39 @@
+package blackout_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/blackout"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/testutil/mongotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenance(t *testing.T) {
+	ctx := context.Background()
+	m := blackout.NewMaintenance(mongotest.Client(t, ctx), "blackout_test", "maintenance", "instance-a")
+
+	mode, err := m.Current(ctx)
+	require.NoError(t, err)
+	assert.False(t, mode.Active(time.Now()))
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+	set, err := m.Set(ctx, blackout.Mode{Enabled: true, Reason: "reindexing", Until: until})
+	require.NoError(t, err)
+	assert.Equal(t, "instance-a", set.SetBy)
+
+	mode, err = m.Current(ctx)
+	require.NoError(t, err)
+	assert.True(t, mode.Active(time.Now()))
+	assert.Equal(t, "reindexing", mode.Reason)
+	assert.True(t, until.Equal(mode.Until))
+
+	_, err = m.Set(ctx, blackout.Mode{SetBy: "ops"})
+	require.NoError(t, err)
+	mode, err = m.Current(ctx)
+	require.NoError(t, err)
+	assert.False(t, mode.Active(time.Now()))
+	assert.Equal(t, "ops", mode.SetBy)
+}
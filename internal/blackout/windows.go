@@ -0,0 +1,140 @@
+// Package blackout decides when fetches must not run: per-service windows,
+// recurring or one-off, and a global maintenance mode kept in Mongo.
+package blackout
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// AllServices is the service name of a window that covers every service.
+const AllServices = "*"
+
+// Window suppresses the runs of one service, or of all with AllServices.
+type Window struct {
+	Service string
+	// Spec is the window as configured, for logs and run history.
+	Spec string
+	// A one-off window runs from From to To.
+	From, To time.Time
+	// A recurring window opens at every time of Schedule and lasts Duration.
+	Schedule cron.Schedule
+	Duration time.Duration
+}
+
+// Until reports whether now falls in the window and, if so, when it closes.
+func (w Window) Until(now time.Time) (time.Time, bool) {
+	if w.Schedule == nil {
+		return w.To, !now.Before(w.From) && now.Before(w.To)
+	}
+	// Open if it last opened less than Duration ago
+	start := w.Schedule.Next(now.Add(-w.Duration))
+	if start.After(now) {
+		return time.Time{}, false
+	}
+	return start.Add(w.Duration), true
+}
+
+func (w Window) String() string {
+	return w.Service + "=" + w.Spec
+}
+
+// Windows is a set of blackout windows.
+type Windows []Window
+
+// Active returns the open window covering any of names at now. When several
+// are open it returns the one that closes last.
+func (ws Windows) Active(now time.Time, names ...string) (Window, time.Time, bool) {
+	var found Window
+	var until time.Time
+	ok := false
+	for _, w := range ws {
+		if !w.covers(names) {
+			continue
+		}
+		if end, open := w.Until(now); open && (!ok || end.After(until)) {
+			found, until, ok = w, end, true
+		}
+	}
+	return found, until, ok
+}
+
+func (w Window) covers(names []string) bool {
+	if w.Service == AllServices {
+		return true
+	}
+	for _, name := range names {
+		if w.Service == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse reads windows separated by ";", each as service=spec. The service is
+// a database name such as aqi_db, or * for all services. The spec is either
+// a one-off range of RFC 3339 times, "2026-11-01T00:00:00Z..2026-11-01T06:00:00Z",
+// or a cron expression with a duration, "0 2 * * SUN for 2h". Cron times are
+// in UTC unless the expression starts with CRON_TZ=<zone>.
+func Parse(v string) (Windows, error) {
+	var ws Windows
+	for _, entry := range strings.Split(v, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		w, err := parseWindow(entry)
+		if err != nil {
+			return nil, fmt.Errorf("blackout window %q: %w", entry, err)
+		}
+		ws = append(ws, w)
+	}
+	return ws, nil
+}
+
+func parseWindow(entry string) (Window, error) {
+	service, spec, ok := strings.Cut(entry, "=")
+	service, spec = strings.TrimSpace(service), strings.TrimSpace(spec)
+	// CRON_TZ=... has an "=" of its own, so the service must come first
+	if !ok || service == "" || spec == "" || strings.HasPrefix(service, "CRON_TZ") {
+		return Window{}, fmt.Errorf("want service=spec")
+	}
+	w := Window{Service: service, Spec: spec}
+
+	if from, to, ok := strings.Cut(spec, ".."); ok {
+		var err error
+		if w.From, err = time.Parse(time.RFC3339, strings.TrimSpace(from)); err != nil {
+			return Window{}, err
+		}
+		if w.To, err = time.Parse(time.RFC3339, strings.TrimSpace(to)); err != nil {
+			return Window{}, err
+		}
+		if !w.To.After(w.From) {
+			return Window{}, fmt.Errorf("window ends before it starts")
+		}
+		return w, nil
+	}
+
+	expr, dur, ok := strings.Cut(spec, " for ")
+	if !ok {
+		return Window{}, fmt.Errorf(`want "<from>..<to>" or "<cron> for <duration>"`)
+	}
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "CRON_TZ=") && !strings.HasPrefix(expr, "TZ=") {
+		expr = "CRON_TZ=UTC " + expr
+	}
+	var err error
+	if w.Schedule, err = cron.ParseStandard(expr); err != nil {
+		return Window{}, err
+	}
+	if w.Duration, err = time.ParseDuration(strings.TrimSpace(dur)); err != nil {
+		return Window{}, err
+	}
+	if w.Duration <= 0 {
+		return Window{}, fmt.Errorf("duration must be positive")
+	}
+	return w, nil
+}
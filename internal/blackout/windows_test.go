@@ -0,0 +1,91 @@
+package blackout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	ws, err := Parse("aqi_db=0 2 * * SUN for 2h; *=2026-11-01T00:00:00Z..2026-11-01T06:00:00Z;")
+	require.NoError(t, err)
+	require.Len(t, ws, 2)
+	assert.Equal(t, "aqi_db", ws[0].Service)
+	assert.Equal(t, 2*time.Hour, ws[0].Duration)
+	assert.Equal(t, AllServices, ws[1].Service)
+	assert.Equal(t, time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC), ws[1].To)
+
+	for _, bad := range []string{
+		"aqi_db",
+		"=0 2 * * * for 1h",
+		"aqi_db=0 2 * * *",
+		"aqi_db=0 2 * * * for soon",
+		"aqi_db=0 2 * * * for -1h",
+		"aqi_db=not a cron for 1h",
+		"aqi_db=2026-11-01T06:00:00Z..2026-11-01T00:00:00Z",
+		"aqi_db=yesterday..today",
+		"CRON_TZ=UTC 0 2 * * * for 1h",
+	} {
+		_, err := Parse(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestWindow_Until(t *testing.T) {
+	ws, err := Parse("aqi_db=0 2 * * SUN for 2h;weather_db=CRON_TZ=Asia/Karachi 0 9 * * * for 30m")
+	require.NoError(t, err)
+	sunday := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		w     Window
+		now   time.Time
+		until time.Time
+		open  bool
+	}{
+		{"before", ws[0], sunday.Add(time.Hour), time.Time{}, false},
+		{"at start", ws[0], sunday.Add(2 * time.Hour), sunday.Add(4 * time.Hour), true},
+		{"inside", ws[0], sunday.Add(3 * time.Hour), sunday.Add(4 * time.Hour), true},
+		{"at end", ws[0], sunday.Add(4 * time.Hour), time.Time{}, false},
+		{"another day", ws[0], sunday.Add(27 * time.Hour), time.Time{}, false},
+		{"local time zone", ws[1], sunday.Add(4*time.Hour + 10*time.Minute), sunday.Add(4*time.Hour + 30*time.Minute), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, open := tt.w.Until(tt.now)
+			assert.Equal(t, tt.open, open)
+			if tt.open {
+				assert.True(t, tt.until.Equal(until), "until %v, want %v", until, tt.until)
+			}
+		})
+	}
+}
+
+func TestWindows_Active(t *testing.T) {
+	ws, err := Parse("aqi_db=2026-11-01T00:00:00Z..2026-11-01T06:00:00Z;*=2026-11-01T00:00:00Z..2026-11-01T03:00:00Z")
+	require.NoError(t, err)
+	now := time.Date(2026, 11, 1, 1, 0, 0, 0, time.UTC)
+
+	w, until, ok := ws.Active(now, "aqi_db")
+	require.True(t, ok)
+	assert.Equal(t, "aqi_db", w.Service)
+	assert.Equal(t, time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC), until)
+
+	// A time-zone bucket is covered through its base name
+	w, _, ok = ws.Active(now, "weather_db@Asia/Kabul", "weather_db")
+	require.True(t, ok)
+	assert.Equal(t, AllServices, w.Service)
+
+	_, _, ok = ws.Active(now.Add(4*time.Hour), "weather_db")
+	assert.False(t, ok)
+}
+
+func TestMode_Active(t *testing.T) {
+	now := time.Now()
+	assert.False(t, Mode{}.Active(now))
+	assert.True(t, Mode{Enabled: true}.Active(now))
+	assert.True(t, Mode{Enabled: true, Until: now.Add(time.Minute)}.Active(now))
+	assert.False(t, Mode{Enabled: true, Until: now.Add(-time.Minute)}.Active(now))
+}
@@ -1,20 +1,348 @@
 package channels
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
 	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrClosed is returned by Submit once the channels have been closed.
+var ErrClosed = errors.New("channels: closed")
+
+// ackTimeout bounds how long Finish waits on the journal.
+const ackTimeout = 10 * time.Second
+
+// Journal records submitted requests outside the process so that work queued
+// when it stops can be resumed after a restart.
+type Journal interface {
+	// Append records req and returns the key it is stored under.
+	Append(ctx context.Context, req models.DataRequest) (string, error)
+	// Ack removes a finished request; a non-nil err records it as failed.
+	Ack(ctx context.Context, key string, err error) error
+}
+
 type Channels struct {
 	DataRequest chan models.DataRequest
-	WG          *sync.WaitGroup
+	// WG counts every tracked request from Submit until a worker finishes it.
+	WG *sync.WaitGroup
+	// Journal, when set, makes every submitted request durable until it finishes.
+	Journal Journal
+
+	initOnce  sync.Once
+	closeOnce sync.Once
+	// closeMu keeps Close from closing DataRequest while a Submit is sending on it.
+	closeMu sync.RWMutex
+	closing chan struct{}
+	closed  bool
+
+	mu     sync.Mutex
+	nextID uint64
+	jobs   map[uint64]*Job
+	runs   map[string]*run
+	all    *run
 }
 
 func New() *Channels {
 	const bufferSize = 100
-	return &Channels{
+	c := &Channels{
 		DataRequest: make(chan models.DataRequest, bufferSize),
 		WG:          &sync.WaitGroup{},
 	}
+	c.init()
+	return c
+}
+
+// init lets Channels built as struct literals (as the tests do) work with tracking.
+func (c *Channels) init() {
+	c.initOnce.Do(func() {
+		if c.WG == nil {
+			c.WG = &sync.WaitGroup{}
+		}
+		c.closing = make(chan struct{})
+		c.jobs = make(map[uint64]*Job)
+		c.runs = make(map[string]*run)
+		c.all = newRun()
+	})
+}
+
+// Submit queues req and returns a handle to it. The request is counted as
+// queued before it is sent, so Wait never misses work sitting in the buffer.
+// The run ID is taken from req.RunID or, if empty, from ctx (see WithRunID).
+func (c *Channels) Submit(ctx context.Context, req models.DataRequest) (*Job, error) {
+	c.init()
+
+	c.closeMu.RLock()
+	defer c.closeMu.RUnlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	if req.RunID == "" {
+		req.RunID = RunIDFromContext(ctx)
+	}
+	if !req.Parent.IsValid() {
+		req.Parent = trace.SpanContextFromContext(ctx)
+	}
+	if req.Fence == nil {
+		req.Fence = FenceFromContext(ctx)
+	}
+	if c.Journal != nil && req.QueueKey == "" {
+		key, err := c.Journal.Append(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to journal request %s: %w", req.ID, err)
+		}
+		req.QueueKey = key
+	}
+	job := c.track(&req)
+
+	select {
+	case c.DataRequest <- req:
+		return job, nil
+	case <-ctx.Done():
+		c.untrack(job, ctx.Err())
+		return nil, ctx.Err()
+	case <-c.closing:
+		c.untrack(job, ErrClosed)
+		return nil, ErrClosed
+	}
+}
+
+// Start marks req as picked up by a worker. Requests sent straight to
+// DataRequest without Submit are tracked from this point on; the returned
+// request carries the job ID that must be passed to Finish.
+func (c *Channels) Start(req models.DataRequest) models.DataRequest {
+	c.init()
+
+	c.mu.Lock()
+	job, ok := c.jobs[req.JobID]
+	c.mu.Unlock()
+
+	if !ok {
+		job = c.track(&req)
+	}
+
+	job.setState(JobRunning)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.runsOf(job) {
+		r.stats.Queued--
+		r.stats.InFlight++
+	}
+	return req
+}
+
+// Finish records the outcome of a request previously passed to Start.
+func (c *Channels) Finish(req models.DataRequest, err error) {
+	c.init()
+	c.ack(req, err)
+
+	c.mu.Lock()
+	job, ok := c.jobs[req.JobID]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.jobs, job.ID)
+	if err != nil && job.RunID != "" {
+		if r, ok := c.runs[job.RunID]; ok {
+			r.failed = append(r.failed, req)
+		}
+	}
+	for _, r := range c.runsOf(job) {
+		r.stats.InFlight--
+		r.stats.Done++
+		if err != nil {
+			r.stats.Failed++
+		}
+		r.release()
+	}
+	c.mu.Unlock()
+
+	job.finish(err)
+	c.WG.Done()
+}
+
+// Wait blocks until every request of runID has finished or ctx is done.
+// An empty runID waits for all tracked requests.
+func (c *Channels) Wait(ctx context.Context, runID string) error {
+	c.init()
+
+	c.mu.Lock()
+	r := c.runFor(runID)
+	if r == nil || r.pending == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	idle := r.idle
+	c.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the counters of runID, or of all tracked requests when runID is empty.
+func (c *Channels) Stats(runID string) Stats {
+	c.init()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.runFor(runID)
+	if r == nil {
+		return Stats{}
+	}
+	return r.stats
+}
+
+// TakeFailed returns the requests of runID that failed since the last call,
+// so they can be submitted again.
+func (c *Channels) TakeFailed(runID string) []models.DataRequest {
+	c.init()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.runs[runID]
+	if !ok {
+		return nil
+	}
+	failed := r.failed
+	r.failed = nil
+	return failed
+}
+
+// Forget drops the bookkeeping for a finished run.
+func (c *Channels) Forget(runID string) {
+	c.init()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.runs[runID]; ok && r.pending == 0 {
+		delete(c.runs, runID)
+	}
+}
+
+// Close stops accepting new requests and closes DataRequest so workers drain
+// what is left and exit. Submits blocked on a full buffer return ErrClosed.
+func (c *Channels) Close() {
+	c.init()
+
+	c.closeOnce.Do(func() {
+		close(c.closing)
+
+		c.closeMu.Lock()
+		defer c.closeMu.Unlock()
+		c.closed = true
+		close(c.DataRequest)
+	})
+}
+
+// ack clears req from the journal. Requests cut short by cancellation stay
+// journaled so they are resumed after a restart.
+func (c *Channels) ack(req models.DataRequest, err error) {
+	if c.Journal == nil || req.QueueKey == "" || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ackTimeout)
+	defer cancel()
+	if ackErr := c.Journal.Ack(ctx, req.QueueKey, err); ackErr != nil {
+		logger.Error("[%s] Failed to ack journaled request %s: %v", req.Service, req.ID, ackErr)
+	}
+}
+
+func (c *Channels) track(req *models.DataRequest) *Job {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	req.JobID = c.nextID
+	job := &Job{
+		ID:      req.JobID,
+		RunID:   req.RunID,
+		Service: req.Service,
+		Key:     req.ID,
+		done:    make(chan struct{}),
+	}
+	c.jobs[job.ID] = job
+	for _, r := range c.runsOf(job) {
+		r.stats.Queued++
+		r.acquire()
+	}
+	c.WG.Add(1)
+	return job
+}
+
+func (c *Channels) untrack(job *Job, err error) {
+	c.mu.Lock()
+	delete(c.jobs, job.ID)
+	for _, r := range c.runsOf(job) {
+		r.stats.Queued--
+		r.release()
+	}
+	c.mu.Unlock()
+
+	job.finish(err)
+	c.WG.Done()
+}
+
+// runsOf returns the global run and, if the job belongs to one, its own run.
+// Callers must hold c.mu.
+func (c *Channels) runsOf(job *Job) []*run {
+	if job.RunID == "" {
+		return []*run{c.all}
+	}
+	r, ok := c.runs[job.RunID]
+	if !ok {
+		r = newRun()
+		c.runs[job.RunID] = r
+	}
+	return []*run{c.all, r}
+}
+
+// runFor must be called with c.mu held.
+func (c *Channels) runFor(runID string) *run {
+	if runID == "" {
+		return c.all
+	}
+	return c.runs[runID]
+}
+
+// run tracks the requests of one batch run.
+type run struct {
+	stats   Stats
+	pending int
+	// idle is closed whenever pending drops to zero.
+	idle chan struct{}
+	// failed holds the requests that failed, for TakeFailed.
+	failed []models.DataRequest
+}
+
+func newRun() *run {
+	idle := make(chan struct{})
+	close(idle)
+	return &run{idle: idle}
+}
+
+func (r *run) acquire() {
+	if r.pending == 0 {
+		r.idle = make(chan struct{})
+	}
+	r.pending++
+}
+
+func (r *run) release() {
+	r.pending--
+	if r.pending == 0 {
+		close(r.idle)
+	}
 }
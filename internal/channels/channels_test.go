@@ -1,7 +1,11 @@
 package channels_test
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
 	"github.com/AbdulWasayUl/go-api-parser-mono/models"
@@ -31,3 +35,207 @@ func TestChannels_Table(t *testing.T) {
 		})
 	}
 }
+
+func TestChannels_SubmitTracksRun(t *testing.T) {
+	ch := channels.New()
+	ctx := channels.WithRunID(context.Background(), "run-1")
+
+	job, err := ch.Submit(ctx, models.DataRequest{ID: "a", Service: "svc"})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if job.RunID != "run-1" || job.Key != "a" || job.Service != "svc" {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+	if got := ch.Stats("run-1"); got.Queued != 1 {
+		t.Fatalf("expected 1 queued, got %+v", got)
+	}
+
+	req := ch.Start(<-ch.DataRequest)
+	if got := ch.Stats("run-1"); got.Queued != 0 || got.InFlight != 1 {
+		t.Fatalf("expected 1 in flight, got %+v", got)
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ch.Wait(waitCtx, "run-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected Wait to block while in flight, got %v", err)
+	}
+
+	ch.Finish(req, errors.New("boom"))
+
+	if err := ch.Wait(context.Background(), "run-1"); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if got := ch.Stats("run-1"); got.Done != 1 || got.Failed != 1 || got.InFlight != 0 {
+		t.Fatalf("unexpected stats after finish: %+v", got)
+	}
+	if job.State() != channels.JobDone || job.Err() == nil {
+		t.Fatalf("expected job done with error, got state %v err %v", job.State(), job.Err())
+	}
+
+	ch.Forget("run-1")
+	if got := ch.Stats("run-1"); got != (channels.Stats{}) {
+		t.Fatalf("expected forgotten run to have no stats, got %+v", got)
+	}
+	if got := ch.Stats(""); got.Done != 1 {
+		t.Fatalf("expected global stats to keep the request, got %+v", got)
+	}
+}
+
+func TestChannels_UnsubmittedRequestTrackedOnStart(t *testing.T) {
+	ch := channels.New()
+	ch.DataRequest <- models.DataRequest{ID: "raw"}
+
+	req := ch.Start(<-ch.DataRequest)
+	if req.JobID == 0 {
+		t.Fatal("expected Start to assign a job ID")
+	}
+	if got := ch.Stats(""); got.InFlight != 1 {
+		t.Fatalf("expected 1 in flight, got %+v", got)
+	}
+
+	ch.Finish(req, nil)
+	if err := ch.Wait(context.Background(), ""); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+}
+
+func TestChannels_SubmitCancelled(t *testing.T) {
+	ch := &channels.Channels{DataRequest: make(chan models.DataRequest)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := ch.Submit(ctx, models.DataRequest{ID: "blocked"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if got := ch.Stats(""); got.Queued != 0 {
+		t.Fatalf("cancelled submit should not stay queued, got %+v", got)
+	}
+	if err := ch.Wait(context.Background(), ""); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+}
+
+func TestChannels_CloseUnblocksSubmit(t *testing.T) {
+	ch := &channels.Channels{DataRequest: make(chan models.DataRequest)}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ch.Submit(context.Background(), models.DataRequest{ID: "blocked"})
+		errc <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	ch.Close()
+	ch.Close()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, channels.ErrClosed) {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Submit still blocked after Close")
+	}
+
+	if _, ok := <-ch.DataRequest; ok {
+		t.Fatal("expected DataRequest to be closed")
+	}
+}
+
+// fakeJournal records appends and acks in memory.
+type fakeJournal struct {
+	mu       sync.Mutex
+	appended []string
+	acked    map[string]error
+}
+
+func (j *fakeJournal) Append(ctx context.Context, req models.DataRequest) (string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.appended = append(j.appended, req.ID)
+	return "key-" + req.ID, nil
+}
+
+func (j *fakeJournal) Ack(ctx context.Context, key string, err error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.acked[key] = err
+	return nil
+}
+
+func TestChannels_Journal(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantAcked bool
+	}{
+		{"SuccessAcked", nil, true},
+		{"FailureAcked", errors.New("API returned 404"), true},
+		{"CancelledLeftForResume", context.Canceled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			journal := &fakeJournal{acked: make(map[string]error)}
+			ch := channels.New()
+			ch.Journal = journal
+
+			if _, err := ch.Submit(context.Background(), models.DataRequest{ID: "Kabul"}); err != nil {
+				t.Fatalf("Submit failed: %v", err)
+			}
+			req := ch.Start(<-ch.DataRequest)
+			if req.QueueKey != "key-Kabul" {
+				t.Fatalf("expected queue key to be set, got %q", req.QueueKey)
+			}
+			ch.Finish(req, tt.err)
+
+			err, acked := journal.acked["key-Kabul"]
+			if acked != tt.wantAcked {
+				t.Fatalf("expected acked=%t, got %t", tt.wantAcked, acked)
+			}
+			if acked && !errors.Is(err, tt.err) {
+				t.Errorf("expected ack error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestChannels_JournalKeepsExistingKey(t *testing.T) {
+	journal := &fakeJournal{acked: make(map[string]error)}
+	ch := channels.New()
+	ch.Journal = journal
+
+	if _, err := ch.Submit(context.Background(), models.DataRequest{ID: "Kabul", QueueKey: "resumed"}); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if len(journal.appended) != 0 {
+		t.Errorf("resumed request should not be journaled twice, got %v", journal.appended)
+	}
+}
+
+func TestChannels_TakeFailed(t *testing.T) {
+	ch := channels.New()
+	ctx := channels.WithRunID(context.Background(), "run-1")
+
+	for _, id := range []string{"Kabul", "Tirana"} {
+		if _, err := ch.Submit(ctx, models.DataRequest{ID: id}); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+	ch.Finish(ch.Start(<-ch.DataRequest), errors.New("API returned 503"))
+	ch.Finish(ch.Start(<-ch.DataRequest), nil)
+
+	failed := ch.TakeFailed("run-1")
+	if len(failed) != 1 || failed[0].ID != "Kabul" {
+		t.Fatalf("expected Kabul to be reported as failed, got %v", failed)
+	}
+	if again := ch.TakeFailed("run-1"); len(again) != 0 {
+		t.Errorf("expected failed requests to be taken once, got %v", again)
+	}
+	if other := ch.TakeFailed("unknown"); other != nil {
+		t.Errorf("expected nothing for an unknown run, got %v", other)
+	}
+}
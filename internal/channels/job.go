@@ -0,0 +1,107 @@
+package channels
+
+import (
+	"context"
+	"sync"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+)
+
+type JobState int
+
+const (
+	JobQueued JobState = iota
+	JobRunning
+	JobDone
+)
+
+// Stats counts requests by where they are in the pipeline. Done includes
+// Failed.
+type Stats struct {
+	Queued   int
+	InFlight int
+	Done     int
+	Failed   int
+}
+
+// Job is the handle returned by Submit for a single request.
+type Job struct {
+	ID      uint64
+	RunID   string
+	Service string
+	Key     string
+
+	mu    sync.Mutex
+	state JobState
+	err   error
+	done  chan struct{}
+}
+
+// Done is closed once a worker has finished the job.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Err returns the error the job finished with, if any.
+func (j *Job) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// State reports whether the job is still queued, running or done.
+func (j *Job) State() JobState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// Wait blocks until the job is finished and returns its error.
+func (j *Job) Wait(ctx context.Context) error {
+	select {
+	case <-j.done:
+		return j.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (j *Job) setState(state JobState) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = state
+}
+
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	j.state = JobDone
+	j.err = err
+	j.mu.Unlock()
+	close(j.done)
+}
+
+type runIDKey struct{}
+
+// WithRunID tags every request submitted with ctx as part of runID.
+func WithRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// RunIDFromContext returns the run ID set by WithRunID, or "".
+func RunIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
+
+type fenceKey struct{}
+
+// WithFence makes every request submitted with ctx carry fence.
+func WithFence(ctx context.Context, fence models.Fence) context.Context {
+	return context.WithValue(ctx, fenceKey{}, fence)
+}
+
+// FenceFromContext returns the fence set by WithFence, or nil.
+func FenceFromContext(ctx context.Context) models.Fence {
+	fence, _ := ctx.Value(fenceKey{}).(models.Fence)
+	return fence
+}
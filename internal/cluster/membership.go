@@ -0,0 +1,130 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Membership tracks the live instances through heartbeats in Mongo. An
+// instance that misses heartbeats for TTL drops out, and its keys move to
+// the others, which also run them in the run they notice it in.
+type Membership struct {
+	Coll *mongo.Collection
+	// ID identifies this instance.
+	ID  string
+	TTL time.Duration
+}
+
+type member struct {
+	ID        string    `bson:"_id"`
+	StartedAt time.Time `bson:"started_at"`
+	LastSeen  time.Time `bson:"last_seen"`
+	ExpireAt  time.Time `bson:"expire_at"`
+}
+
+func NewMembership(client *mongo.Client, dbName, collectionName, id string, ttl time.Duration) *Membership {
+	return &Membership{
+		Coll: client.Database(dbName).Collection(collectionName),
+		ID:   id,
+		TTL:  ttl,
+	}
+}
+
+// EnsureIndexes lets Mongo clean up instances that have been gone for a while.
+func (m *Membership) EnsureIndexes(ctx context.Context) error {
+	_, err := m.Coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expire_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// Heartbeat records this instance as alive.
+func (m *Membership) Heartbeat(ctx context.Context) error {
+	now := time.Now()
+	_, err := m.Coll.UpdateOne(ctx,
+		bson.M{"_id": m.ID},
+		bson.M{
+			"$set":         bson.M{"last_seen": now, "expire_at": now.Add(10 * m.TTL)},
+			"$setOnInsert": bson.M{"started_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Leave removes this instance so its keys move to the others straight away.
+func (m *Membership) Leave(ctx context.Context) error {
+	_, err := m.Coll.DeleteOne(ctx, bson.M{"_id": m.ID})
+	return err
+}
+
+// Members returns the IDs of the instances seen within TTL, sorted.
+func (m *Membership) Members(ctx context.Context) ([]string, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cur, err := m.Coll.Find(ctx, bson.M{"last_seen": bson.M{"$gte": time.Now().Add(-m.TTL)}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var members []member
+	if err := cur.All(ctx, &members); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(members))
+	for _, mb := range members {
+		ids = append(ids, mb.ID)
+	}
+	return ids, nil
+}
+
+// Partition returns this instance's share of the keys among the live members.
+// This instance is always included, even if its own heartbeat is late.
+func (m *Membership) Partition(ctx context.Context) (*Partition, error) {
+	members, err := m.Members(ctx)
+	if err != nil {
+		return nil, err
+	}
+	self := false
+	for _, id := range members {
+		if id == m.ID {
+			self = true
+			break
+		}
+	}
+	if !self {
+		members = append(members, m.ID)
+	}
+	return NewPartition(m.ID, members), nil
+}
+
+// Run heartbeats every TTL/3 until ctx is cancelled, then leaves.
+func (m *Membership) Run(ctx context.Context) {
+	if err := m.Heartbeat(ctx); err != nil {
+		logger.Error("Cluster heartbeat failed: %v", err)
+	}
+
+	ticker := time.NewTicker(m.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			leaveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := m.Leave(leaveCtx); err != nil {
+				logger.Error("Failed to leave cluster: %v", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := m.Heartbeat(ctx); err != nil && ctx.Err() == nil {
+				logger.Error("Cluster heartbeat failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/testutil/mongotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMembership(t *testing.T) {
+	ctx := context.Background()
+	client := mongotest.Client(t, ctx)
+	ttl := 200 * time.Millisecond
+
+	a := cluster.NewMembership(client, "cluster_test", "members", "a", ttl)
+	b := cluster.NewMembership(client, "cluster_test", "members", "b", ttl)
+	require.NoError(t, a.EnsureIndexes(ctx))
+	require.NoError(t, a.Heartbeat(ctx))
+	require.NoError(t, b.Heartbeat(ctx))
+
+	members, err := a.Members(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, members)
+
+	// b stops heartbeating and drops out once its TTL passes.
+	time.Sleep(ttl + 50*time.Millisecond)
+	require.NoError(t, a.Heartbeat(ctx))
+	p, err := a.Partition(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, p.Members)
+	assert.True(t, p.Owns("weather_db/Kabul"))
+
+	// A partition always includes its own instance.
+	require.NoError(t, a.Leave(ctx))
+	p, err = b.Partition(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, p.Members)
+}
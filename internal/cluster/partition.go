@@ -0,0 +1,62 @@
+package cluster
+
+import "context"
+
+type partitionKey struct{}
+
+// Partition is one instance's share of the keys, as seen at the start of a run.
+type Partition struct {
+	Self    string
+	Members []string
+	ring    *Ring
+	// prev, if set, is the ring whose share of Self is left out, see Since
+	prev *Ring
+}
+
+func NewPartition(self string, members []string) *Partition {
+	return &Partition{Self: self, Members: members, ring: NewRing(members, 0)}
+}
+
+// Owns reports whether key belongs to this instance.
+func (p *Partition) Owns(key string) bool {
+	if p.prev != nil && p.prev.Owner(key) == p.Self {
+		return false
+	}
+	return p.ring.Owner(key) == p.Self
+}
+
+// Departed returns the members of prev that are no longer members of p.
+func (p *Partition) Departed(prev *Partition) []string {
+	live := make(map[string]bool, len(p.Members))
+	for _, m := range p.Members {
+		live[m] = true
+	}
+	var gone []string
+	for _, m := range prev.Members {
+		if !live[m] {
+			gone = append(gone, m)
+		}
+	}
+	return gone
+}
+
+// Since returns the keys this instance owns in p but did not own in prev,
+// e.g. those it took over from members that departed since.
+func (p *Partition) Since(prev *Partition) *Partition {
+	return &Partition{Self: p.Self, Members: p.Members, ring: p.ring, prev: prev.ring}
+}
+
+// WithPartition attaches p to ctx for the services' param loops.
+func WithPartition(ctx context.Context, p *Partition) context.Context {
+	return context.WithValue(ctx, partitionKey{}, p)
+}
+
+// Owns reports whether the instance running ctx is responsible for the given
+// service's key. Without a partition in ctx every key is owned.
+func Owns(ctx context.Context, service, key string) bool {
+	p, ok := ctx.Value(partitionKey{}).(*Partition)
+	if !ok || p == nil {
+		return true
+	}
+	return p.Owns(service + "/" + key)
+}
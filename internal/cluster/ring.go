@@ -0,0 +1,59 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// defaultReplicas is how many points each member gets on the ring. More
+// points spread keys more evenly at the cost of a larger ring.
+const defaultReplicas = 128
+
+// Ring assigns keys to members by consistent hashing, so adding or removing a
+// member only moves the keys that member gains or loses.
+type Ring struct {
+	points  []uint32
+	members map[uint32]string
+}
+
+// NewRing builds a ring over members. A replicas value below 1 uses the default.
+func NewRing(members []string, replicas int) *Ring {
+	if replicas < 1 {
+		replicas = defaultReplicas
+	}
+	r := &Ring{members: make(map[uint32]string, len(members)*replicas)}
+	for _, m := range members {
+		for i := 0; i < replicas; i++ {
+			h := hash(m + "#" + strconv.Itoa(i))
+			if _, taken := r.members[h]; taken {
+				continue
+			}
+			r.members[h] = m
+			r.points = append(r.points, h)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the member responsible for key, or "" for an empty ring.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.members[r.points[i]]
+}
+
+// hash uses SHA-1 for its mixing: keys and member names differ in only a few
+// characters, which cheaper checksums spread unevenly around the ring.
+func hash(s string) uint32 {
+	sum := sha1.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
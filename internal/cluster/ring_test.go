@@ -0,0 +1,106 @@
+package cluster_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
+)
+
+func keys(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("city-%d", i)
+	}
+	return out
+}
+
+func TestRing_Empty(t *testing.T) {
+	if got := cluster.NewRing(nil, 0).Owner("Kabul"); got != "" {
+		t.Errorf("expected no owner on an empty ring, got %q", got)
+	}
+}
+
+func TestRing_Balanced(t *testing.T) {
+	members := []string{"a", "b", "c"}
+	ring := cluster.NewRing(members, 0)
+
+	counts := make(map[string]int)
+	for _, k := range keys(3000) {
+		counts[ring.Owner(k)]++
+	}
+	for _, m := range members {
+		// Each member should get roughly a third of the keys.
+		if counts[m] < 700 || counts[m] > 1300 {
+			t.Errorf("member %s owns %d of 3000 keys, expected about 1000", m, counts[m])
+		}
+	}
+}
+
+func TestRing_RemovalOnlyMovesLostKeys(t *testing.T) {
+	before := cluster.NewRing([]string{"a", "b", "c"}, 0)
+	after := cluster.NewRing([]string{"a", "b"}, 0)
+
+	for _, k := range keys(1000) {
+		prev, next := before.Owner(k), after.Owner(k)
+		if prev != "c" && prev != next {
+			t.Fatalf("key %s moved from %s to %s although %s is still live", k, prev, next, prev)
+		}
+		if next == "c" {
+			t.Fatalf("key %s still owned by removed member", k)
+		}
+	}
+}
+
+func TestPartition_CoversEveryKeyOnce(t *testing.T) {
+	members := []string{"a", "b", "c"}
+	parts := make([]*cluster.Partition, len(members))
+	for i, m := range members {
+		parts[i] = cluster.NewPartition(m, members)
+	}
+
+	for _, k := range keys(500) {
+		owners := 0
+		for _, p := range parts {
+			if cluster.Owns(cluster.WithPartition(context.Background(), p), "weather_db", k) {
+				owners++
+			}
+		}
+		if owners != 1 {
+			t.Fatalf("key %s owned by %d instances, expected 1", k, owners)
+		}
+	}
+}
+
+func TestOwns_WithoutPartition(t *testing.T) {
+	if !cluster.Owns(context.Background(), "weather_db", "Kabul") {
+		t.Error("expected every key to be owned without a partition")
+	}
+}
+
+func TestPartition_Since(t *testing.T) {
+	before := cluster.NewPartition("a", []string{"a", "b", "c"})
+	after := cluster.NewPartition("a", []string{"a", "b"})
+
+	if gone := after.Departed(before); len(gone) != 1 || gone[0] != "c" {
+		t.Fatalf("expected c to have departed, got %v", gone)
+	}
+	if gone := before.Departed(after); len(gone) != 0 {
+		t.Fatalf("expected nobody to have departed, got %v", gone)
+	}
+
+	gained := after.Since(before)
+	taken := 0
+	for _, k := range keys(1000) {
+		if gained.Owns(k) != (after.Owns(k) && !before.Owns(k)) {
+			t.Fatalf("key %s: owned since is %v, owned before %v and after %v", k, gained.Owns(k), before.Owns(k), after.Owns(k))
+		}
+		if gained.Owns(k) {
+			taken++
+		}
+	}
+	if taken == 0 {
+		t.Error("expected a to take over some of c's keys")
+	}
+}
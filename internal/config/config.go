@@ -1,18 +1,31 @@
 package config
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"reflect"
+	"strconv"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/blackout"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
 )
 
 // Config holds the application configuration
 type Config struct {
+	// Profile is the configuration profile in use, File the config file read
+	// and SecretsFile the encrypted secrets file, if any
+	Profile     string
+	File        string
+	SecretsFile string
+
 	WeatherAPIKey               string
 	OpenAQAPIKey                string
-	MongoURI                    string
-	MongoAuthDB                 string
 	DBWeather                   string
 	DBOpenAQ                    string
 	DBWorldTime                 string
@@ -24,41 +37,463 @@ type Config struct {
 	OpenAQAPIBaseURL            string
 	WorldTimeAPIBaseURL         string
 	RestCountriesAPIBaseURL     string
+
+	// MongoURI is MONGO_URI, or built from MONGO_HOST and MONGO_PORT. The
+	// other Mongo settings override the URI's options when they are set.
+	MongoURI    string
+	MongoUser   string
+	MongoPass   string
+	MongoAuthDB string
+	// MongoAuthMechanism is negotiated with the server when empty
+	MongoAuthMechanism string
+	// MongoTLS is implied by a CA or client certificate file. The client
+	// certificate's key is read from MongoTLSKeyFile, or from the
+	// certificate file itself when that is empty.
+	MongoTLS         bool
+	MongoTLSCAFile   string
+	MongoTLSCertFile string
+	MongoTLSKeyFile  string
+	MongoTLSInsecure bool
+	MongoReplicaSet  string
+	// MongoReadPreference is a mode such as primary or secondaryPreferred
+	MongoReadPreference string
+	// MongoWriteConcern is majority or a number of nodes
+	MongoWriteConcern string
+	// Zero leaves pool sizes and timeouts to the driver
+	MongoMaxPoolSize            int
+	MongoMinPoolSize            int
+	MongoConnectTimeout         time.Duration
+	MongoServerSelectionTimeout time.Duration
+	// MongoStartupTimeout bounds how long startup retries an unreachable
+	// server before giving up, or going on without it when SpoolDir is set
+	MongoStartupTimeout time.Duration
+
+	// SpoolDir holds the records that could not be written while MongoDB was
+	// unreachable, until they are replayed; empty disables spooling
+	SpoolDir            string
+	SpoolMaxMB          int
+	SpoolReplayInterval time.Duration
+
+	// Worker pool sizing, per service unless SharedPool is set, in which case
+	// the counts bound all services together
+	SharedPool        bool
+	ServiceWeights    map[string]int
+	WorkerCount       int
+	WorkerMin         int
+	WorkerMax         int
+	AutoscaleEnabled  bool
+	AutoscaleInterval time.Duration
+	CoalesceRequests  bool
+	// RateLimits overrides the upstream rate limit of the services it names
+	RateLimits map[string]models.RateLimitSettings
+	// RequestTimeout bounds fetch, parse and store for a single ID, retries
+	// included, unless RequestTimeouts sets one for the service
+	RequestTimeout  time.Duration
+	RequestTimeouts map[string]time.Duration
+
+	// InstanceID identifies this process in leases and locks
+	InstanceID string
+	// DBCoordination holds state shared between runs and instances
+	DBCoordination  string
+	DurableQueue    bool
+	CollectionQueue string
+	QueueVisibility time.Duration
+	BatchLock       bool
+	CollectionLocks string
+	LockTTL         time.Duration
+	// Cluster splits fetch params between the instances heartbeating in
+	// CollectionMembers
+	Cluster           bool
+	CollectionMembers string
+	MemberTTL         time.Duration
+
+	// ScheduleAt is the daily time of the scheduled run, in UTC unless
+	// LocalTimeScheduling runs params with a "tz" at that time locally
+	ScheduleAt          string
+	LocalTimeScheduling bool
+	// CatchUpPolicy is skip, run-once or run-all-missed
+	CatchUpPolicy        string
+	CatchUpMax           int
+	CollectionRunHistory string
+	// RetryPasses extra passes resubmit a run's failed requests after RetryCooldown
+	RetryPasses   int
+	RetryCooldown time.Duration
+
+	// Failure ratios above which a service's run is partial or failed
+	PartialThreshold float64
+	FailedThreshold  float64
+	// AlertOn is the status (partial or failed) from which runs are alerted
+	AlertOn         string
+	AlertWebhookURL string
+	// OneShot runs every service once and exits with the run's status
+	OneShot bool
+	// DrainTimeout bounds how long shutdown waits for queued requests
+	DrainTimeout time.Duration
+
+	// BlackoutWindows hold back runs, see blackout.Parse for the format
+	BlackoutWindows string
+	// BlackoutPolicy is defer or skip
+	BlackoutPolicy        string
+	CollectionMaintenance string
+
+	// AdminAddr is where the admin API listens; empty disables it
+	AdminAddr  string
+	AdminToken string
+
+	// LogFormat is text or json
+	LogFormat string
+	LogLevel  slog.Level
+	// LogLevels override LogLevel for single packages, such as workpool
+	LogLevels map[string]slog.Level
+
+	// TracingExporter is none, otlp, stdout or file
+	TracingExporter string
+	// TracingEndpoint is the OTLP/HTTP collector URL; empty leaves it to
+	// the OTEL_EXPORTER_OTLP_* variables
+	TracingEndpoint    string
+	TracingFile        string
+	TracingSampleRatio float64
+
+	// StalenessThreshold is how long a fetch param may go without being
+	// stored before it is reported stale; zero disables the reports
+	StalenessThreshold     time.Duration
+	StalenessCheckInterval time.Duration
+	// StalenessRetention is how long a param no longer attempted is kept
+	StalenessRetention       time.Duration
+	CollectionParamFreshness string
+}
+
+// Profiles known to validation. A config file may define others.
+const (
+	ProfileDev  = "dev"
+	ProfileTest = "test"
+	ProfileProd = "prod"
+)
+
+// defaultConfigFile is read when it exists and no file is named.
+const defaultConfigFile = "config.yaml"
+
+// Load builds the configuration from, in increasing priority: defaults, a
+// YAML config file (its base settings, then the profile's section), the
+// encrypted SECRETS_FILE, environment variables (.env.<profile> and .env
+// fill in unset ones), and -set KEY=VALUE flags in args. The file is -config
+// or CONFIG_FILE, else config.yaml if present; the profile is -profile or
+// APP_PROFILE, else dev. Every missing or malformed setting is reported in
+// the returned error.
+func Load(args []string) (*Config, error) {
+	src := newSource()
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML config file")
+	profile := fs.String("profile", getEnv("APP_PROFILE", ProfileDev), "config profile: dev, test, prod or one defined in the config file")
+	fs.Var(setFlags(src.flags), "set", "override a setting, as KEY=VALUE (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	src.loadDotEnv(*profile)
+	path := *file
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			path = defaultConfigFile
+		}
+	}
+	inFile := false
+	if path != "" {
+		inFile = src.loadFile(path, *profile)
+	}
+	secretsFile := src.str("SECRETS_FILE", "")
+	if secretsFile != "" {
+		src.loadSecrets(secretsFile)
+	}
+	if !inFile && *profile != ProfileDev && *profile != ProfileTest && *profile != ProfileProd {
+		src.errorf("unknown profile %q", *profile)
+	}
+
+	cfg := load(src, *profile)
+	cfg.File = path
+	cfg.SecretsFile = secretsFile
+	src.unknown()
+	if len(src.errs) > 0 {
+		return nil, errors.Join(src.errs...)
+	}
+	return cfg, nil
+}
+
+func load(src *source, profile string) *Config {
+	prod := profile == ProfileProd
+	// Tests run against stub servers and need no API keys
+	needKeys := profile != ProfileTest
+
+	cfg := &Config{
+		Profile:                     profile,
+		WeatherAPIKey:               src.requiredIf(needKeys, "WEATHER_API_KEY"),
+		OpenAQAPIKey:                src.requiredIf(needKeys, "OPENAQ_API_KEY"),
+		DBWeather:                   src.required("DB_WEATHER_NAME"),
+		DBOpenAQ:                    src.required("DB_OPENAQ_NAME"),
+		DBWorldTime:                 src.required("DB_WORLDTIME_NAME"),
+		DBRestCountries:             src.required("DB_RESTCOUNTRIES_NAME"),
+		CollectionFetchParams:       src.required("COLLECTION_FETCH_PARAMS"),
+		CollectionDailyData:         src.required("COLLECTION_DAILY_DATA"),
+		CollectionMigrationsHistory: src.required("COLLECTION_MIGRATIONS_HISTORY"),
+		WeatherAPIBaseURL:           src.url("WEATHER_API_BASE_URL", true),
+		OpenAQAPIBaseURL:            src.url("OPENAQ_API_BASE_URL", true),
+		WorldTimeAPIBaseURL:         src.url("WORLDTIME_API_BASE_URL", true),
+		RestCountriesAPIBaseURL:     src.url("RESTCOUNTRIES_API_BASE_URL", true),
+		SharedPool:                  src.bool("SHARED_POOL", false),
+		ServiceWeights:              src.weights("SERVICE_WEIGHTS"),
+		WorkerCount:                 src.int("WORKER_COUNT", 5),
+		WorkerMin:                   src.int("WORKER_MIN", 1),
+		WorkerMax:                   src.int("WORKER_MAX", 10),
+		AutoscaleEnabled:            src.bool("AUTOSCALE_ENABLED", false),
+		AutoscaleInterval:           src.duration("AUTOSCALE_INTERVAL", 10*time.Second),
+		CoalesceRequests:            src.bool("COALESCE_REQUESTS", true),
+		RateLimits:                  src.rateLimits("RATE_LIMITS"),
+		RequestTimeout:              src.duration("REQUEST_TIMEOUT", 3*time.Minute),
+		RequestTimeouts:             src.durations("REQUEST_TIMEOUTS"),
+		InstanceID:                  src.str("INSTANCE_ID", defaultInstanceID()),
+		DBCoordination:              src.str("DB_COORDINATION_NAME", "aggregator"),
+		DurableQueue:                src.bool("DURABLE_QUEUE", false),
+		CollectionQueue:             src.str("COLLECTION_QUEUE", "job_queue"),
+		QueueVisibility:             src.duration("QUEUE_VISIBILITY", 2*time.Minute),
+		BatchLock:                   src.bool("BATCH_LOCK", false),
+		CollectionLocks:             src.str("COLLECTION_LOCKS", "locks"),
+		LockTTL:                     src.duration("LOCK_TTL", time.Minute),
+		Cluster:                     src.bool("CLUSTER", false),
+		CollectionMembers:           src.str("COLLECTION_MEMBERS", "members"),
+		MemberTTL:                   src.duration("MEMBER_TTL", 30*time.Second),
+		ScheduleAt:                  src.str("SCHEDULE_AT", "07:30"),
+		LocalTimeScheduling:         src.bool("LOCAL_TIME_SCHEDULING", false),
+		CatchUpPolicy:               src.oneOf("CATCHUP_POLICY", "run-once", "skip", "run-once", "run-all-missed"),
+		CatchUpMax:                  src.int("CATCHUP_MAX", 7),
+		CollectionRunHistory:        src.str("COLLECTION_RUN_HISTORY", "run_history"),
+		RetryPasses:                 src.int("RETRY_PASSES", 1),
+		RetryCooldown:               src.duration("RETRY_COOLDOWN", 2*time.Minute),
+		PartialThreshold:            src.float("PARTIAL_THRESHOLD", 0),
+		FailedThreshold:             src.float("FAILED_THRESHOLD", 0.10),
+		AlertOn:                     src.oneOf("ALERT_ON", "failed", "partial", "failed"),
+		AlertWebhookURL:             src.url("ALERT_WEBHOOK_URL", false),
+		OneShot:                     src.bool("ONE_SHOT", false),
+		DrainTimeout:                src.duration("DRAIN_TIMEOUT", 30*time.Second),
+		BlackoutWindows:             src.str("BLACKOUT_WINDOWS", ""),
+		BlackoutPolicy:              src.oneOf("BLACKOUT_POLICY", "defer", "defer", "skip"),
+		CollectionMaintenance:       src.str("COLLECTION_MAINTENANCE", "maintenance"),
+		AdminAddr:                   src.str("ADMIN_ADDR", "127.0.0.1:8081"),
+		SpoolDir:                    src.str("SPOOL_DIR", "spool"),
+		SpoolMaxMB:                  src.int("SPOOL_MAX_MB", 512),
+		SpoolReplayInterval:         src.duration("SPOOL_REPLAY_INTERVAL", 30*time.Second),
+		LogFormat:                   src.oneOf("LOG_FORMAT", "text", "text", "json"),
+		LogLevel:                    src.level("LOG_LEVEL", slog.LevelInfo),
+		LogLevels:                   src.levels("LOG_LEVELS"),
+		TracingExporter:             src.oneOf("TRACING_EXPORTER", "none", "none", "otlp", "stdout", "file"),
+		TracingEndpoint:             src.url("TRACING_ENDPOINT", false),
+		TracingFile:                 src.str("TRACING_FILE", "traces.jsonl"),
+		TracingSampleRatio:          src.float("TRACING_SAMPLE_RATIO", 1),
+		StalenessThreshold:          src.duration("STALENESS_THRESHOLD", 48*time.Hour),
+		StalenessCheckInterval:      src.duration("STALENESS_CHECK_INTERVAL", 15*time.Minute),
+		StalenessRetention:          src.duration("STALENESS_RETENTION", 30*24*time.Hour),
+		CollectionParamFreshness:    src.str("COLLECTION_PARAM_FRESHNESS", "param_freshness"),
+	}
+	loadMongo(src, cfg, prod)
+	// An admin API reachable from outside must be protected in production
+	cfg.AdminToken = src.requiredIf(prod && cfg.AdminAddr != "" && !loopback(cfg.AdminAddr), "ADMIN_TOKEN")
+
+	validate(src, cfg)
+	return cfg
+}
+
+// validate checks settings against each other and their allowed ranges.
+func validate(src *source, cfg *Config) {
+	if cfg.WorkerCount < 1 {
+		src.errorf("WORKER_COUNT=%d must be at least 1", cfg.WorkerCount)
+	}
+	if cfg.WorkerMin < 1 || cfg.WorkerMin > cfg.WorkerMax {
+		src.errorf("WORKER_MIN=%d and WORKER_MAX=%d must satisfy 1 <= min <= max", cfg.WorkerMin, cfg.WorkerMax)
+	}
+	if cfg.PartialThreshold < 0 || cfg.FailedThreshold > 1 || cfg.PartialThreshold > cfg.FailedThreshold {
+		src.errorf("PARTIAL_THRESHOLD=%g and FAILED_THRESHOLD=%g must satisfy 0 <= partial <= failed <= 1", cfg.PartialThreshold, cfg.FailedThreshold)
+	}
+	if _, err := time.Parse("15:04", cfg.ScheduleAt); err != nil {
+		src.errorf("SCHEDULE_AT=%q is not a time of day (HH:MM)", cfg.ScheduleAt)
+	}
+	if cfg.CatchUpMax < 1 {
+		src.errorf("CATCHUP_MAX=%d must be at least 1", cfg.CatchUpMax)
+	}
+	if cfg.RetryPasses < 0 {
+		src.errorf("RETRY_PASSES=%d must not be negative", cfg.RetryPasses)
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"AUTOSCALE_INTERVAL", cfg.AutoscaleInterval},
+		{"REQUEST_TIMEOUT", cfg.RequestTimeout},
+		{"QUEUE_VISIBILITY", cfg.QueueVisibility},
+		{"LOCK_TTL", cfg.LockTTL},
+		{"MEMBER_TTL", cfg.MemberTTL},
+		{"DRAIN_TIMEOUT", cfg.DrainTimeout},
+		{"SPOOL_REPLAY_INTERVAL", cfg.SpoolReplayInterval},
+		{"STALENESS_CHECK_INTERVAL", cfg.StalenessCheckInterval},
+		{"STALENESS_RETENTION", cfg.StalenessRetention},
+	} {
+		if d.value <= 0 {
+			src.errorf("%s=%v must be positive", d.key, d.value)
+		}
+	}
+	if cfg.StalenessThreshold < 0 {
+		src.errorf("STALENESS_THRESHOLD=%v must not be negative", cfg.StalenessThreshold)
+	}
+	// A param must be remembered long enough to go stale
+	if cfg.StalenessRetention > 0 && cfg.StalenessRetention <= cfg.StalenessThreshold {
+		src.errorf("STALENESS_RETENTION=%v must be longer than STALENESS_THRESHOLD=%v", cfg.StalenessRetention, cfg.StalenessThreshold)
+	}
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		src.errorf("TRACING_SAMPLE_RATIO=%v must be between 0 and 1", cfg.TracingSampleRatio)
+	}
+	if cfg.TracingExporter == "file" && cfg.TracingFile == "" {
+		src.errorf("TRACING_FILE must be set with TRACING_EXPORTER=file")
+	}
+	if cfg.SpoolDir != "" && cfg.SpoolMaxMB < 1 {
+		src.errorf("SPOOL_MAX_MB=%d must be at least 1", cfg.SpoolMaxMB)
+	}
+	if cfg.RetryCooldown < 0 {
+		src.errorf("RETRY_COOLDOWN=%v must not be negative", cfg.RetryCooldown)
+	}
+	if _, err := blackout.Parse(cfg.BlackoutWindows); err != nil {
+		src.errorf("BLACKOUT_WINDOWS: %v", err)
+	}
 }
 
-// Load reads the .env file and loads the configuration
-func Load() *Config {
-	err := godotenv.Load()
-	// Ignore err if .env file is not found in deployment
+// RateLimit returns the rate limit RATE_LIMITS sets for service, or def.
+func (c *Config) RateLimit(service string, def models.RateLimitSettings) models.RateLimitSettings {
+	if rl, ok := c.RateLimits[service]; ok {
+		return rl
+	}
+	return def
+}
+
+// Timeout returns the request timeout REQUEST_TIMEOUTS sets for service, or
+// REQUEST_TIMEOUT.
+func (c *Config) Timeout(service string) time.Duration {
+	if d, ok := c.RequestTimeouts[service]; ok {
+		return d
+	}
+	return c.RequestTimeout
+}
+
+// Changed returns the names of the fields that differ between old and new,
+// in the order Config declares them.
+func Changed(old, new *Config) []string {
+	a, b := reflect.ValueOf(*old), reflect.ValueOf(*new)
+	var names []string
+	for i := 0; i < a.NumField(); i++ {
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			names = append(names, a.Type().Field(i).Name)
+		}
+	}
+	return names
+}
+
+// loopback reports whether addr only listens on the local host.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
-	}
-
-	return &Config{
-		WeatherAPIKey:               os.Getenv("WEATHER_API_KEY"),
-		OpenAQAPIKey:                os.Getenv("OPENAQ_API_KEY"),
-		MongoURI:                    getMongoURI(),
-		MongoAuthDB:                 os.Getenv("MONGO_AUTH_DB"),
-		DBWeather:                   os.Getenv("DB_WEATHER_NAME"),
-		DBOpenAQ:                    os.Getenv("DB_OPENAQ_NAME"),
-		DBWorldTime:                 os.Getenv("DB_WORLDTIME_NAME"),
-		DBRestCountries:             os.Getenv("DB_RESTCOUNTRIES_NAME"),
-		CollectionFetchParams:       os.Getenv("COLLECTION_FETCH_PARAMS"),
-		CollectionDailyData:         os.Getenv("COLLECTION_DAILY_DATA"),
-		CollectionMigrationsHistory: os.Getenv("COLLECTION_MIGRATIONS_HISTORY"),
-		WeatherAPIBaseURL:           os.Getenv("WEATHER_API_BASE_URL"),
-		OpenAQAPIBaseURL:            os.Getenv("OPENAQ_API_BASE_URL"),
-		WorldTimeAPIBaseURL:         os.Getenv("WORLDTIME_API_BASE_URL"),
-		RestCountriesAPIBaseURL:     os.Getenv("RESTCOUNTRIES_API_BASE_URL"),
+		return false
+	}
+	if host == "localhost" {
+		return true
 	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
-// getMongoURI constructs the MongoDB URI from environment variables
-func getMongoURI() string {
-	host := os.Getenv("MONGO_HOST")
-	port := os.Getenv("MONGO_PORT")
-	user := os.Getenv("MONGO_USER")
-	pass := os.Getenv("MONGO_PASS")
+// getEnv returns the value of key, or def when unset
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// defaultInstanceID combines the hostname (the container ID under Docker) and pid
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// loadMongo reads the MongoDB connection settings. Production needs
+// credentials, unless they are in MONGO_URI or the client certificate is
+// used to authenticate.
+func loadMongo(src *source, cfg *Config, prod bool) {
+	cfg.MongoURI = src.str("MONGO_URI", "")
+	if cfg.MongoURI == "" {
+		host := src.required("MONGO_HOST")
+		port := src.required("MONGO_PORT")
+		if port != "" {
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+				src.errorf("MONGO_PORT=%q is not a port number", port)
+			}
+		}
+		cfg.MongoURI = "mongodb://" + net.JoinHostPort(host, port)
+	}
+	u, err := url.Parse(cfg.MongoURI)
+	if err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") {
+		src.errorf("MONGO_URI is not a mongodb:// or mongodb+srv:// URI")
+	}
 
-	return "mongodb://" + user + ":" + pass + "@" + host + ":" + port
+	cfg.MongoAuthMechanism = src.oneOf("MONGO_AUTH_MECHANISM", "", "", "SCRAM-SHA-256", "SCRAM-SHA-1", "MONGODB-X509", "MONGODB-AWS", "PLAIN", "GSSAPI")
+	uriCreds := u != nil && u.User != nil
+	needCreds := prod && !uriCreds && cfg.MongoAuthMechanism != "MONGODB-X509"
+	cfg.MongoUser = src.requiredIf(needCreds, "MONGO_USER")
+	cfg.MongoPass = src.requiredIf(needCreds, "MONGO_PASS")
+	cfg.MongoAuthDB = src.str("MONGO_AUTH_DB", "")
+
+	cfg.MongoTLS = src.bool("MONGO_TLS", false)
+	cfg.MongoTLSCAFile = src.str("MONGO_TLS_CA_FILE", "")
+	cfg.MongoTLSCertFile = src.str("MONGO_TLS_CERT_FILE", "")
+	cfg.MongoTLSKeyFile = src.str("MONGO_TLS_KEY_FILE", "")
+	cfg.MongoTLSInsecure = src.bool("MONGO_TLS_INSECURE", false)
+	for _, f := range []struct{ key, path string }{
+		{"MONGO_TLS_CA_FILE", cfg.MongoTLSCAFile},
+		{"MONGO_TLS_CERT_FILE", cfg.MongoTLSCertFile},
+		{"MONGO_TLS_KEY_FILE", cfg.MongoTLSKeyFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			src.errorf("%s: %v", f.key, err)
+		}
+	}
+	if cfg.MongoTLSKeyFile != "" && cfg.MongoTLSCertFile == "" {
+		src.errorf("MONGO_TLS_KEY_FILE needs MONGO_TLS_CERT_FILE")
+	}
+	if cfg.MongoAuthMechanism == "MONGODB-X509" && cfg.MongoTLSCertFile == "" {
+		src.errorf("MONGO_AUTH_MECHANISM=MONGODB-X509 needs MONGO_TLS_CERT_FILE")
+	}
+	if prod && cfg.MongoTLSInsecure {
+		src.errorf("MONGO_TLS_INSECURE is not allowed in production")
+	}
+
+	cfg.MongoReplicaSet = src.str("MONGO_REPLICA_SET", "")
+	cfg.MongoReadPreference = src.oneOf("MONGO_READ_PREFERENCE", "", "", "primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest")
+	cfg.MongoWriteConcern = src.str("MONGO_WRITE_CONCERN", "")
+	if wc := cfg.MongoWriteConcern; wc != "" && wc != "majority" {
+		if n, err := strconv.Atoi(wc); err != nil || n < 0 {
+			src.errorf("MONGO_WRITE_CONCERN=%q must be majority or a number of nodes", wc)
+		}
+	}
+	cfg.MongoMaxPoolSize = src.int("MONGO_MAX_POOL_SIZE", 0)
+	cfg.MongoMinPoolSize = src.int("MONGO_MIN_POOL_SIZE", 0)
+	if cfg.MongoMinPoolSize < 0 || cfg.MongoMaxPoolSize < 0 || (cfg.MongoMaxPoolSize > 0 && cfg.MongoMinPoolSize > cfg.MongoMaxPoolSize) {
+		src.errorf("MONGO_MIN_POOL_SIZE=%d and MONGO_MAX_POOL_SIZE=%d must satisfy 0 <= min <= max", cfg.MongoMinPoolSize, cfg.MongoMaxPoolSize)
+	}
+	cfg.MongoConnectTimeout = src.duration("MONGO_CONNECT_TIMEOUT", 0)
+	cfg.MongoServerSelectionTimeout = src.duration("MONGO_SERVER_SELECTION_TIMEOUT", 0)
+	cfg.MongoStartupTimeout = src.duration("MONGO_STARTUP_TIMEOUT", time.Minute)
+	if cfg.MongoStartupTimeout < 0 {
+		src.errorf("MONGO_STARTUP_TIMEOUT=%v must not be negative", cfg.MongoStartupTimeout)
+	}
 }
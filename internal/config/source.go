@@ -0,0 +1,491 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/secrets"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// source looks settings up in layers, highest priority first: flags, the
+// environment, .env files, the decrypted secrets file, the profile's section
+// of the config file, then the rest of the file. Defaults
+// are given by each getter. Every problem found is kept so they can be
+// reported together.
+type source struct {
+	flags   map[string]string
+	dotenv  map[string]string
+	secrets map[string]string
+	profile map[string]string
+	file    map[string]string
+	layers  []layer
+	// used holds every key looked up, to catch unknown keys in the file
+	used map[string]bool
+	errs []error
+}
+
+// layer is one place settings come from, named in error messages.
+type layer struct {
+	name string
+	get  func(key string) (string, bool)
+}
+
+func newSource() *source {
+	s := &source{flags: map[string]string{}, dotenv: map[string]string{}, secrets: map[string]string{}, profile: map[string]string{}, file: map[string]string{}, used: map[string]bool{}}
+	s.layers = []layer{
+		{"flags", fromMap(s.flags)},
+		{"the environment", func(key string) (string, bool) {
+			v := os.Getenv(key)
+			return v, v != ""
+		}},
+		{".env", fromMap(s.dotenv)},
+		{"the secrets file", fromMap(s.secrets)},
+		{"the config file profile", fromMap(s.profile)},
+		{"the config file", fromMap(s.file)},
+	}
+	return s
+}
+
+func fromMap(m map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := m[key]
+		return v, ok
+	}
+}
+
+func (s *source) errorf(format string, v ...interface{}) {
+	s.errs = append(s.errs, fmt.Errorf(format, v...))
+}
+
+// lookup returns the value of key from the highest layer that sets it. An
+// empty environment variable or .env entry counts as unset; an empty value in the file
+// (such as admin_addr: "") does not. In any layer, KEY_FILE names a file
+// holding the value instead, as Docker and Kubernetes secrets are mounted.
+func (s *source) lookup(key string) (string, bool) {
+	s.used[key] = true
+	s.used[key+"_FILE"] = true
+	for _, l := range s.layers {
+		v, ok := l.get(key)
+		path, indirect := l.get(key + "_FILE")
+		if ok && indirect {
+			s.errorf("%s and %s_FILE are both set in %s; set one", key, key, l.name)
+		}
+		if ok {
+			return v, true
+		}
+		if indirect {
+			return s.readFile(key, path), true
+		}
+	}
+	return "", false
+}
+
+// readFile returns the contents of the file KEY_FILE names, less the
+// trailing newline most tools write.
+func (s *source) readFile(key, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		s.errorf("%s_FILE: %v", key, err)
+		return ""
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+// str returns the value of key, or def when unset
+func (s *source) str(key, def string) string {
+	if v, ok := s.lookup(key); ok {
+		return v
+	}
+	return def
+}
+
+// required returns the value of key, recording an error when it is unset
+// or empty
+func (s *source) required(key string) string {
+	v, _ := s.lookup(key)
+	if v == "" {
+		s.errorf("%s is required", key)
+	}
+	return v
+}
+
+// requiredIf is required when cond holds and str with no default otherwise
+func (s *source) requiredIf(cond bool, key string) string {
+	if cond {
+		return s.required(key)
+	}
+	return s.str(key, "")
+}
+
+// int returns the integer value of key, or def when unset
+func (s *source) int(key string, def int) int {
+	v, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		s.errorf("%s=%q is not an integer", key, v)
+		return def
+	}
+	return n
+}
+
+// float returns the float value of key, or def when unset
+func (s *source) float(key string, def float64) float64 {
+	v, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		s.errorf("%s=%q is not a number", key, v)
+		return def
+	}
+	return f
+}
+
+// bool returns the boolean value of key, or def when unset
+func (s *source) bool(key string, def bool) bool {
+	v, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		s.errorf("%s=%q is not a boolean", key, v)
+		return def
+	}
+	return b
+}
+
+// duration returns the duration value of key, or def when unset
+func (s *source) duration(key string, def time.Duration) time.Duration {
+	v, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		s.errorf("%s=%q is not a duration (e.g. 30s, 2m)", key, v)
+		return def
+	}
+	return d
+}
+
+// weights parses key as a comma separated list of name=weight pairs
+func (s *source) weights(key string) map[string]int {
+	weights := make(map[string]int)
+	v, ok := s.lookup(key)
+	if !ok {
+		return weights
+	}
+	for _, pair := range strings.Split(v, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		n, err := strconv.Atoi(weight)
+		if !ok || err != nil || n < 1 {
+			s.errorf("%s entry %q is not name=weight with a positive weight", key, pair)
+			continue
+		}
+		weights[name] = n
+	}
+	return weights
+}
+
+// rateLimits parses key as a comma separated list of name=requests/period
+// pairs, such as weather_db=30/1m
+func (s *source) rateLimits(key string) map[string]models.RateLimitSettings {
+	limits := make(map[string]models.RateLimitSettings)
+	v, ok := s.lookup(key)
+	if !ok || v == "" {
+		return limits
+	}
+	for _, pair := range strings.Split(v, ",") {
+		name, limit, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		n, per, _ := strings.Cut(limit, "/")
+		requests, err := strconv.Atoi(n)
+		period, perr := time.ParseDuration(per)
+		if name == "" || err != nil || perr != nil || requests < 1 || period <= 0 {
+			s.errorf("%s entry %q is not name=requests/period, such as weather_db=30/1m", key, pair)
+			continue
+		}
+		rl := models.RateLimitSettings{MaxRequests: requests, PerDuration: period}
+		if rl.Interval() <= 0 {
+			s.errorf("%s entry %q allows more than one request per nanosecond", key, pair)
+			continue
+		}
+		limits[name] = rl
+	}
+	return limits
+}
+
+// durations parses key as a comma separated list of name=duration pairs,
+// such as worldtime_db=3m
+func (s *source) durations(key string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	v, ok := s.lookup(key)
+	if !ok || v == "" {
+		return durations
+	}
+	for _, pair := range strings.Split(v, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		d, err := time.ParseDuration(value)
+		if name == "" || err != nil || d <= 0 {
+			s.errorf("%s entry %q is not name=duration with a positive duration, such as worldtime_db=3m", key, pair)
+			continue
+		}
+		durations[name] = d
+	}
+	return durations
+}
+
+// level returns key as a log level: debug, info, warn or error
+func (s *source) level(key string, def slog.Level) slog.Level {
+	v, ok := s.lookup(key)
+	if !ok || v == "" {
+		return def
+	}
+	level, ok := parseLevel(v)
+	if !ok {
+		s.errorf("%s=%q must be one of debug, info, warn, error", key, v)
+		return def
+	}
+	return level
+}
+
+// levels parses key as a comma separated list of package=level pairs, such
+// as workpool=debug
+func (s *source) levels(key string) map[string]slog.Level {
+	levels := make(map[string]slog.Level)
+	v, ok := s.lookup(key)
+	if !ok || v == "" {
+		return levels
+	}
+	for _, pair := range strings.Split(v, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		level, ok := parseLevel(value)
+		if name == "" || !ok {
+			s.errorf("%s entry %q is not package=level with a level of debug, info, warn or error", key, pair)
+			continue
+		}
+		levels[name] = level
+	}
+	return levels
+}
+
+func parseLevel(v string) (slog.Level, bool) {
+	switch strings.ToLower(v) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "info":
+		return slog.LevelInfo, true
+	case "warn":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return 0, false
+}
+
+// oneOf returns the value of key, or def when unset, recording an error if
+// it is not one of allowed
+func (s *source) oneOf(key, def string, allowed ...string) string {
+	v := s.str(key, def)
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	var names []string
+	for _, a := range allowed {
+		if a != "" {
+			names = append(names, a)
+		}
+	}
+	s.errorf("%s=%q must be one of %s", key, v, strings.Join(names, ", "))
+	return v
+}
+
+// url returns the value of key, recording an error unless it is an absolute
+// http(s) URL. Unset values are left to required.
+func (s *source) url(key string, required bool) string {
+	v := s.requiredIf(required, key)
+	if v == "" {
+		return v
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		s.errorf("%s=%q is not an http(s) URL", key, v)
+	}
+	return v
+}
+
+// loadDotEnv reads the variables of .env.<profile> and .env into a layer
+// below the environment, which is left untouched so a reload sees edits to
+// the files. Files that do not exist are skipped, and .env.<profile> wins
+// over .env.
+func (s *source) loadDotEnv(profile string) {
+	for _, name := range []string{".env." + profile, ".env"} {
+		if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		vars, err := godotenv.Read(name)
+		if err != nil {
+			s.errorf("reading %s: %v", name, err)
+			continue
+		}
+		for key, v := range vars {
+			// Empty counts as unset, as in the environment
+			if s.dotenv[key] == "" && v != "" {
+				s.dotenv[key] = v
+			}
+		}
+	}
+}
+
+// loadSecrets decrypts the secrets file at path with the key in SECRETS_KEY
+// (or the file SECRETS_KEY_FILE names) and adds its settings as a layer.
+func (s *source) loadSecrets(path string) {
+	encoded, _ := s.lookup("SECRETS_KEY")
+	if encoded == "" {
+		s.errorf("SECRETS_FILE is set but neither SECRETS_KEY nor SECRETS_KEY_FILE is")
+		return
+	}
+	key, err := secrets.ParseKey(encoded)
+	if err != nil {
+		s.errorf("SECRETS_KEY: %v", err)
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		s.errorf("reading secrets file: %v", err)
+		return
+	}
+	plaintext, err := secrets.Open(key, data)
+	if err != nil {
+		s.errorf("opening secrets file %s: %v", path, err)
+		return
+	}
+	vars, err := godotenv.Unmarshal(string(plaintext))
+	if err != nil {
+		s.errorf("parsing secrets file %s: %v", path, err)
+		return
+	}
+	for key, v := range vars {
+		s.secrets[strings.ToUpper(key)] = v
+	}
+}
+
+// loadFile reads a YAML config file. Top-level keys are setting names, in
+// any case (worker_count or WORKER_COUNT); the "profiles" key holds a section
+// per profile that overrides them. It reports whether profile has a section.
+func (s *source) loadFile(path, profile string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		s.errorf("reading config file: %v", err)
+		return false
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		s.errorf("parsing config file %s: %v", path, err)
+		return false
+	}
+
+	found := false
+	for key, v := range doc {
+		if strings.EqualFold(key, "profiles") {
+			profiles, ok := v.(map[string]interface{})
+			if !ok {
+				s.errorf("%s: profiles must be a mapping of profile name to settings", path)
+				continue
+			}
+			if section, ok := profiles[profile]; ok {
+				found = true
+				s.addSection(path+": profiles."+profile, section, s.profile)
+			}
+			continue
+		}
+		s.addValue(path, key, v, s.file)
+	}
+	return found
+}
+
+func (s *source) addSection(where string, section interface{}, into map[string]string) {
+	if section == nil {
+		return
+	}
+	values, ok := section.(map[string]interface{})
+	if !ok {
+		s.errorf("%s must be a mapping of settings", where)
+		return
+	}
+	for key, v := range values {
+		s.addValue(where, key, v, into)
+	}
+}
+
+// addValue stores a scalar setting, or a name: weight mapping as the
+// name=weight list the environment uses.
+func (s *source) addValue(where, key string, v interface{}, into map[string]string) {
+	key = strings.ToUpper(key)
+	switch v := v.(type) {
+	case nil:
+		into[key] = ""
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		pairs := make([]string, 0, len(v))
+		for _, name := range names {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", name, v[name]))
+		}
+		into[key] = strings.Join(pairs, ",")
+	case []interface{}:
+		s.errorf("%s: %s must be a single value", where, key)
+	default:
+		into[key] = fmt.Sprint(v)
+	}
+}
+
+// unknown records an error for every key set in the file or flags that no
+// setting looked up, which is most likely a typo.
+func (s *source) unknown() {
+	for _, layer := range []map[string]string{s.flags, s.secrets, s.profile, s.file} {
+		keys := make([]string, 0, len(layer))
+		for key := range layer {
+			if !s.used[key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s.errorf("unknown setting %s", key)
+		}
+	}
+}
+
+// setFlags collects repeated -set KEY=VALUE flags.
+type setFlags map[string]string
+
+func (f setFlags) String() string { return "" }
+
+func (f setFlags) Set(v string) error {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("want KEY=VALUE")
+	}
+	f[strings.ToUpper(key)] = value
+	return nil
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ParamCache keeps a copy of every fetch param collection, so batches can
+// still run while MongoDB is unreachable.
+type ParamCache interface {
+	SaveParams(dbName, collectionName string, params []map[string]interface{}) error
+	LoadParams(dbName, collectionName string) ([]map[string]interface{}, error)
+}
+
+type paramCacheKey struct{}
+
+// WithParamCache makes GetFetchParams and DistinctParamValues calls made
+// with ctx keep cache up to date, and fall back to it when MongoDB cannot
+// be reached.
+func WithParamCache(ctx context.Context, cache ParamCache) context.Context {
+	return context.WithValue(ctx, paramCacheKey{}, cache)
+}
+
+func paramCacheFrom(ctx context.Context) ParamCache {
+	cache, _ := ctx.Value(paramCacheKey{}).(ParamCache)
+	return cache
+}
+
+// cachedParams returns the cached params matching filter in place of a read
+// that failed with err. Only an unreachable server falls back to the cache.
+func cachedParams(cache ParamCache, err error, dbName, collectionName string, filter bson.M) ([]map[string]interface{}, error) {
+	if !Unreachable(err) {
+		return nil, err
+	}
+	params, cacheErr := cache.LoadParams(dbName, collectionName)
+	if cacheErr != nil {
+		return nil, fmt.Errorf("%w (no cached fetch params: %v)", err, cacheErr)
+	}
+
+	var out []map[string]interface{}
+	for _, p := range params {
+		ok, matchErr := matches(p, filter)
+		if matchErr != nil {
+			return nil, fmt.Errorf("%w (cached fetch params: %v)", err, matchErr)
+		}
+		if ok {
+			out = append(out, p)
+		}
+	}
+	logger.Info("MongoDB unreachable, using %d cached fetch params of %s.%s.", len(out), dbName, collectionName)
+	return out, nil
+}
+
+// matches applies the subset of the query language param filters use:
+// equality, $in and $nin on top-level fields.
+func matches(param map[string]interface{}, filter bson.M) (bool, error) {
+	for field, cond := range filter {
+		value, present := param[field]
+		ops, isOps := cond.(bson.M)
+		if !isOps {
+			if !present || !reflect.DeepEqual(value, cond) {
+				return false, nil
+			}
+			continue
+		}
+		for op, arg := range ops {
+			switch op {
+			case "$in":
+				if !present || !contains(arg, value) {
+					return false, nil
+				}
+			case "$nin":
+				if present && contains(arg, value) {
+					return false, nil
+				}
+			default:
+				return false, fmt.Errorf("unsupported operator %s on %s", op, field)
+			}
+		}
+	}
+	return true, nil
+}
+
+func contains(list, value interface{}) bool {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice {
+		return false
+	}
+	for i := 0; i < v.Len(); i++ {
+		if reflect.DeepEqual(v.Index(i).Interface(), value) {
+			return true
+		}
+	}
+	return false
+}
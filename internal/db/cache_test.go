@@ -0,0 +1,123 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/config"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memCache is an in-memory db.ParamCache.
+type memCache map[string][]map[string]interface{}
+
+func (c memCache) SaveParams(dbName, collectionName string, params []map[string]interface{}) error {
+	c[dbName+"."+collectionName] = params
+	return nil
+}
+
+func (c memCache) LoadParams(dbName, collectionName string) ([]map[string]interface{}, error) {
+	params, ok := c[dbName+"."+collectionName]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return params, nil
+}
+
+// unreachableClient returns a client for a server that is not there.
+func unreachableClient(t *testing.T) *mongo.Client {
+	t.Helper()
+	client, err := db.NewClient(&config.Config{
+		MongoURI:                    "mongodb://127.0.0.1:1",
+		MongoServerSelectionTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client
+}
+
+func TestWaitReachable(t *testing.T) {
+	client := unreachableClient(t)
+
+	start := time.Now()
+	err := db.WaitReachable(context.Background(), client, 1500*time.Millisecond)
+	if !errors.Is(err, db.ErrUnreachable) {
+		t.Fatalf("expected ErrUnreachable, got %v", err)
+	}
+	// Two attempts, one second apart; a third would start after the deadline
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 3*time.Second {
+		t.Errorf("gave up after %v, expected between 1s and 3s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.WaitReachable(ctx, client, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestGetFetchParams_Cache(t *testing.T) {
+	client := unreachableClient(t)
+	ctx := context.Background()
+
+	// Without a cache an unreachable server is an error
+	if _, err := db.GetFetchParams(ctx, client, "weather_db", "fetch_params"); !db.Unreachable(err) {
+		t.Fatalf("expected an unreachable error, got %v", err)
+	}
+
+	cache := memCache{}
+	ctx = db.WithParamCache(ctx, cache)
+	if _, err := db.GetFetchParams(ctx, client, "weather_db", "fetch_params"); err == nil {
+		t.Fatal("expected an error with nothing cached")
+	}
+
+	kabul := map[string]interface{}{"city": "Kabul", "tz": "Asia/Kabul"}
+	lahore := map[string]interface{}{"city": "Lahore", "tz": "Asia/Karachi"}
+	oslo := map[string]interface{}{"city": "Oslo"}
+	cache.SaveParams("weather_db", "fetch_params", []map[string]interface{}{kabul, lahore, oslo})
+
+	for _, tc := range []struct {
+		name   string
+		filter bson.M
+		want   []map[string]interface{}
+	}{
+		{"all", nil, []map[string]interface{}{kabul, lahore, oslo}},
+		{"zone", bson.M{"tz": "Asia/Kabul"}, []map[string]interface{}{kabul}},
+		{"outside zones", bson.M{"tz": bson.M{"$nin": []string{"Asia/Kabul"}}}, []map[string]interface{}{lahore, oslo}},
+		{"in", bson.M{"city": bson.M{"$in": []string{"Oslo", "Paris"}}}, []map[string]interface{}{oslo}},
+	} {
+		ctx := ctx
+		if tc.filter != nil {
+			ctx = db.WithParamFilter(ctx, tc.filter)
+		}
+		got, err := db.GetFetchParams(ctx, client, "weather_db", "fetch_params")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	zones, err := db.DistinctParamValues(ctx, client, "weather_db", "fetch_params", "tz")
+	if err != nil {
+		t.Fatalf("DistinctParamValues failed: %v", err)
+	}
+	if !reflect.DeepEqual(zones, []string{"Asia/Kabul", "Asia/Karachi"}) {
+		t.Errorf("got zones %v", zones)
+	}
+
+	// A filter the cache cannot apply is reported rather than ignored
+	filtered := db.WithParamFilter(ctx, bson.M{"priority": bson.M{"$gt": 1}})
+	if _, err := db.GetFetchParams(filtered, client, "weather_db", "fetch_params"); err == nil {
+		t.Error("expected an error for an unsupported filter")
+	}
+}
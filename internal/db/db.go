@@ -2,6 +2,13 @@ package db
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/config"
@@ -11,31 +18,196 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 const migrationCollectionName = "migrations_history"
 
-func ConnectMongoDB(ctx context.Context, cfg *config.Config) (*mongo.Client, error) {
-	clientOptions := options.Client().ApplyURI(cfg.MongoURI).SetAuth(options.Credential{
-		Username:   "admin",
-		Password:   "password",
-		AuthSource: cfg.MongoAuthDB,
-	})
+// ErrUnreachable is returned when the server did not answer before the
+// startup deadline.
+var ErrUnreachable = errors.New("MongoDB unreachable")
+
+const (
+	pingTimeout   = 10 * time.Second
+	maxRetryDelay = 30 * time.Second
+)
 
-	client, err := mongo.Connect(ctx, clientOptions)
+// ConnectMongoDB connects and pings the server, retrying with backoff for up
+// to cfg.MongoStartupTimeout.
+func ConnectMongoDB(ctx context.Context, cfg *config.Config) (*mongo.Client, error) {
+	client, err := NewClient(cfg)
 	if err != nil {
 		return nil, err
 	}
+	if err := WaitReachable(ctx, client, cfg.MongoStartupTimeout); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return client, nil
+}
 
-	ctxTimeout, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-	err = client.Ping(ctxTimeout, nil)
+// NewClient returns a client without waiting for the server. The driver
+// connects in the background and reconnects on its own, so the client can
+// be used once the server becomes reachable.
+func NewClient(cfg *config.Config) (*mongo.Client, error) {
+	clientOptions, err := ClientOptions(cfg)
 	if err != nil {
 		return nil, err
 	}
+	return mongo.Connect(context.Background(), clientOptions)
+}
 
-	logger.Info("Successfully connected to MongoDB!")
-	return client, nil
+// WaitReachable pings the server until it answers, doubling the wait between
+// attempts up to 30s. It gives up with ErrUnreachable once the next attempt
+// would start after timeout; a zero timeout makes a single attempt.
+func WaitReachable(ctx context.Context, client *mongo.Client, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		wait := pingTimeout
+		if timeout > 0 {
+			// The last attempt ends around the deadline
+			wait = min(pingTimeout, max(time.Until(deadline), time.Second))
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, wait)
+		err := client.Ping(pingCtx, nil)
+		cancel()
+		if err == nil {
+			logger.Info("Successfully connected to MongoDB!")
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		delay := min(time.Duration(1<<(attempt-1))*time.Second, maxRetryDelay)
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("%w after %d attempts: %w", ErrUnreachable, attempt, err)
+		}
+		logger.Warn("MongoDB not reachable (attempt %d), retrying in %v: %v", attempt, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Unreachable reports whether err means the server could not be reached, as
+// opposed to it rejecting the operation.
+func Unreachable(err error) bool {
+	return err != nil && (mongo.IsNetworkError(err) || mongo.IsTimeout(err))
+}
+
+// ClientOptions builds the driver options from cfg: MongoURI first, then
+// every other Mongo setting that is set on top of it.
+func ClientOptions(cfg *config.Config) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(cfg.MongoURI)
+
+	if cfg.MongoUser != "" || cfg.MongoAuthMechanism != "" {
+		cred := options.Credential{
+			Username:      cfg.MongoUser,
+			Password:      cfg.MongoPass,
+			PasswordSet:   cfg.MongoPass != "",
+			AuthSource:    cfg.MongoAuthDB,
+			AuthMechanism: cfg.MongoAuthMechanism,
+		}
+		if opts.Auth != nil && cfg.MongoUser == "" {
+			// Only the mechanism is set here; keep the URI's credentials
+			cred.Username, cred.Password, cred.PasswordSet = opts.Auth.Username, opts.Auth.Password, opts.Auth.PasswordSet
+		}
+		if cred.AuthSource == "" && opts.Auth != nil {
+			cred.AuthSource = opts.Auth.AuthSource
+		}
+		opts.SetAuth(cred)
+	} else if cfg.MongoAuthDB != "" && opts.Auth != nil {
+		opts.Auth.AuthSource = cfg.MongoAuthDB
+	}
+
+	if cfg.MongoTLS || cfg.MongoTLSCAFile != "" || cfg.MongoTLSCertFile != "" {
+		tlsConfig, err := tlsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	if cfg.MongoReplicaSet != "" {
+		opts.SetReplicaSet(cfg.MongoReplicaSet)
+	}
+	if cfg.MongoReadPreference != "" {
+		mode, err := readpref.ModeFromString(cfg.MongoReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(rp)
+	}
+	if wc := cfg.MongoWriteConcern; wc != "" {
+		var w interface{} = wc
+		if n, err := strconv.Atoi(wc); err == nil {
+			w = n
+		}
+		opts.SetWriteConcern(&writeconcern.WriteConcern{W: w})
+	}
+	if cfg.MongoMaxPoolSize > 0 {
+		opts.SetMaxPoolSize(uint64(cfg.MongoMaxPoolSize))
+	}
+	if cfg.MongoMinPoolSize > 0 {
+		opts.SetMinPoolSize(uint64(cfg.MongoMinPoolSize))
+	}
+	if cfg.MongoConnectTimeout > 0 {
+		opts.SetConnectTimeout(cfg.MongoConnectTimeout)
+	}
+	if cfg.MongoServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(cfg.MongoServerSelectionTimeout)
+	}
+
+	return opts, opts.Validate()
+}
+
+// tlsConfig loads the CA and client certificate files named in cfg.
+func tlsConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.MongoTLSInsecure,
+	}
+
+	if cfg.MongoTLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.MongoTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading Mongo CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in Mongo CA file %s", cfg.MongoTLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.MongoTLSCertFile != "" {
+		certPEM, err := os.ReadFile(cfg.MongoTLSCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading Mongo client certificate: %w", err)
+		}
+		keyPEM := certPEM
+		if cfg.MongoTLSKeyFile != "" {
+			if keyPEM, err = os.ReadFile(cfg.MongoTLSKeyFile); err != nil {
+				return nil, fmt.Errorf("reading Mongo client key: %w", err)
+			}
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("loading Mongo client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
 }
 
 func DisconnectMongoDB(ctx context.Context, client *mongo.Client) error {
@@ -52,6 +224,7 @@ func RunMigrations(ctx context.Context, client *mongo.Client, cfg *config.Config
 		{Name: "initial_data_openaq", Func: migrations.MigrateOpenAQData(cfg)},
 		{Name: "initial_data_worldtime", Func: migrations.MigrateWorldTimeData(cfg)},
 		{Name: "initial_data_restcountries", Func: migrations.MigrateRestCountriesData(cfg)},
+		{Name: "weather_zones_tirane", Func: migrations.FixWeatherZones(cfg)},
 	}
 
 	db := client.Database(cfg.DBWeather)
@@ -82,13 +255,53 @@ func RunMigrations(ctx context.Context, client *mongo.Client, cfg *config.Config
 	return nil
 }
 
+type paramFilterKey struct{}
+
+// WithParamFilter restricts GetFetchParams calls made with ctx to the params
+// matching filter, e.g. to run a batch for a subset of cities.
+func WithParamFilter(ctx context.Context, filter bson.M) context.Context {
+	return context.WithValue(ctx, paramFilterKey{}, filter)
+}
+
+func paramFilter(ctx context.Context) bson.M {
+	if filter, ok := ctx.Value(paramFilterKey{}).(bson.M); ok {
+		return filter
+	}
+	return bson.M{}
+}
+
 func GetFetchParams(ctx context.Context, client interface{}, dbName, collectionName string) ([]map[string]interface{}, error) {
 	mongoClient, _ := client.(*mongo.Client)
 
-	db := mongoClient.Database(dbName)
-	coll := db.Collection(collectionName)
+	filter := paramFilter(ctx)
+	results, err := findParams(ctx, mongoClient, dbName, collectionName, filter)
+	cache := paramCacheFrom(ctx)
+	if cache == nil {
+		return results, err
+	}
+	if err != nil {
+		return cachedParams(cache, err, dbName, collectionName, filter)
+	}
+
+	all := results
+	if len(filter) > 0 {
+		if all, err = findParams(ctx, mongoClient, dbName, collectionName, bson.M{}); err != nil {
+			logger.Error("Failed to refresh cached fetch params of %s.%s: %v", dbName, collectionName, err)
+			return results, nil
+		}
+	}
+	if err := cache.SaveParams(dbName, collectionName, all); err != nil {
+		logger.Error("Failed to cache fetch params of %s.%s: %v", dbName, collectionName, err)
+	}
+	return results, nil
+}
+
+func findParams(ctx context.Context, client *mongo.Client, dbName, collectionName string, filter bson.M) ([]map[string]interface{}, error) {
+	coll := client.Database(dbName).Collection(collectionName)
 
-	cursor, err := coll.Find(ctx, bson.M{})
+	// Highest priority first; params without one sort last.
+	findOpts := options.Find().SetSort(bson.D{{Key: "priority", Value: -1}})
+	cursor, err := coll.Find(ctx, filter, findOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -101,3 +314,69 @@ func GetFetchParams(ctx context.Context, client interface{}, dbName, collectionN
 
 	return results, nil
 }
+
+// DistinctParamValues returns the distinct non-empty string values of field
+// across the fetch params.
+func DistinctParamValues(ctx context.Context, client interface{}, dbName, collectionName, field string) ([]string, error) {
+	mongoClient, _ := client.(*mongo.Client)
+
+	coll := mongoClient.Database(dbName).Collection(collectionName)
+	values, err := coll.Distinct(ctx, field, bson.M{})
+	if cache := paramCacheFrom(ctx); cache != nil && err != nil {
+		var params []map[string]interface{}
+		if params, err = cachedParams(cache, err, dbName, collectionName, bson.M{}); err != nil {
+			return nil, err
+		}
+		values = nil
+		for _, p := range params {
+			if v, ok := p[field].(string); ok && !slices.Contains(values, interface{}(v)) {
+				values = append(values, v)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok && s != "" {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// ParamPriority returns the optional numeric "priority" field of a fetch param, or 0.
+func ParamPriority(param map[string]interface{}) int {
+	switch v := param["priority"].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// CheckFence fails if fence is set and a newer lease than it was granted,
+// in which case the record must not be written: another instance took the
+// batch over.
+//
+// The check is a separate read made before the write, not part of it, so it
+// narrows rather than closes the window: a lease taken over between the two
+// still lets the stale holder write that one record. The records live in
+// another database than the locks, and a transaction spanning both would
+// need a replica set, which the aggregator does not require.
+func CheckFence(ctx context.Context, fence models.Fence) error {
+	if fence == nil {
+		return nil
+	}
+	if err := fence.Check(ctx); err != nil {
+		return fmt.Errorf("write fenced off: %w", err)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/config"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/db"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/db/migrations"
 	"github.com/docker/go-connections/nat"
 	tc "github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -98,6 +99,32 @@ func TestMongoDBFunctions(t *testing.T) {
 	if len(results) != 1 || results[0]["key"] != "value" {
 		t.Fatalf("Unexpected fetch results: %v", results)
 	}
+
+	// Filtered fetch and distinct values
+	_, err = coll.InsertMany(ctx, []interface{}{
+		bson.M{"city": "Kabul", "tz": "Asia/Kabul"},
+		bson.M{"city": "Tirana", "tz": "Europe/Tirane"},
+	})
+	if err != nil {
+		t.Fatalf("InsertMany failed: %v", err)
+	}
+
+	filtered := db.WithParamFilter(ctx, bson.M{"tz": "Asia/Kabul"})
+	results, err = db.GetFetchParams(filtered, client, cfg.DBWeather, collName)
+	if err != nil {
+		t.Fatalf("GetFetchParams with filter failed: %v", err)
+	}
+	if len(results) != 1 || results[0]["city"] != "Kabul" {
+		t.Fatalf("Unexpected filtered results: %v", results)
+	}
+
+	zones, err := db.DistinctParamValues(ctx, client, cfg.DBWeather, collName, "tz")
+	if err != nil {
+		t.Fatalf("DistinctParamValues failed: %v", err)
+	}
+	if len(zones) != 2 {
+		t.Fatalf("Expected 2 time zones, got %v", zones)
+	}
 }
 
 // Optional: Test RunMigrations (with mocked migration funcs)
@@ -132,3 +159,71 @@ func TestRunMigrations(t *testing.T) {
 		log.Printf("RunMigrations returned error (expected if migration functions not implemented): %v", err)
 	}
 }
+
+// Databases seeded before the zone was renamed are fixed by a migration
+func TestFixWeatherZones(t *testing.T) {
+	ctx := context.Background()
+
+	container, mongoURI, err := setupMongoContainer(ctx)
+	if err != nil {
+		t.Fatalf("Failed to start MongoDB container: %v", err)
+	}
+	defer container.Terminate(ctx)
+
+	cfg := &config.Config{
+		MongoURI:    mongoURI,
+		MongoAuthDB: "admin",
+		DBWeather:   "weather_test_zones",
+	}
+
+	client, err := db.ConnectMongoDB(ctx, cfg)
+	if err != nil {
+		t.Fatalf("ConnectMongoDB failed: %v", err)
+	}
+	defer db.DisconnectMongoDB(ctx, client)
+
+	coll := client.Database(cfg.DBWeather).Collection("fetch_params")
+	_, err = coll.InsertMany(ctx, []interface{}{
+		bson.M{"city": "Kabul", "tz": "Asia/Kabul"},
+		bson.M{"city": "Tirana", "tz": "Europe/Tirana"},
+	})
+	if err != nil {
+		t.Fatalf("InsertMany failed: %v", err)
+	}
+
+	if err := migrations.FixWeatherZones(cfg)(ctx, client); err != nil {
+		t.Fatalf("FixWeatherZones failed: %v", err)
+	}
+
+	for city, want := range map[string]string{"Kabul": "Asia/Kabul", "Tirana": "Europe/Tirane"} {
+		var param struct{ TZ string }
+		if err := coll.FindOne(ctx, bson.M{"city": city}).Decode(&param); err != nil {
+			t.Fatalf("FindOne %s failed: %v", city, err)
+		}
+		if param.TZ != want {
+			t.Errorf("expected %s in %s, got %s", city, want, param.TZ)
+		}
+	}
+}
+
+func TestParamPriority(t *testing.T) {
+	tests := []struct {
+		name  string
+		param map[string]interface{}
+		want  int
+	}{
+		{"missing", map[string]interface{}{"city": "Kabul"}, 0},
+		{"int32", map[string]interface{}{"priority": int32(3)}, 3},
+		{"int64", map[string]interface{}{"priority": int64(2)}, 2},
+		{"float64", map[string]interface{}{"priority": float64(5)}, 5},
+		{"wrong type", map[string]interface{}{"priority": "high"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := db.ParamPriority(tt.param); got != tt.want {
+				t.Errorf("ParamPriority() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
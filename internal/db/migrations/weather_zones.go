@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// renamedZones maps time zones in the seeded weather params that the zone
+// database does not know to the names it uses.
+var renamedZones = map[string]string{
+	"Europe/Tirana": "Europe/Tirane",
+}
+
+// FixWeatherZones renames the time zones of weather params seeded by
+// MigrateWeatherData, so they are scheduled at local time.
+func FixWeatherZones(cfg *config.Config) func(ctx context.Context, client *mongo.Client) error {
+	return func(ctx context.Context, client *mongo.Client) error {
+		coll := client.Database(cfg.DBWeather).Collection(fetchParamCollection)
+		for from, to := range renamedZones {
+			if _, err := coll.UpdateMany(ctx, bson.M{"tz": from}, bson.M{"$set": bson.M{"tz": to}}); err != nil {
+				return fmt.Errorf("failed to rename time zone %s to %s: %w", from, to, err)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,85 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	StatusCompleted   = "completed"
+	StatusInterrupted = "interrupted"
+	// A skipped or deferred run was held back by a blackout or maintenance
+	StatusSkipped  = "skipped"
+	StatusDeferred = "deferred"
+
+	// ResultFailed is the Result of a run that failed its thresholds.
+	ResultFailed = "failed"
+)
+
+// Run is the record of one service's batch.
+type Run struct {
+	Service  string `bson:"service"`
+	RunID    string `bson:"run_id"`
+	Instance string `bson:"instance"`
+	Status   string `bson:"status"`
+	// Result grades a completed run: success, partial or failed
+	Result string `bson:"result,omitempty"`
+	Error  string `bson:"error,omitempty"`
+	// Reason says why a skipped or deferred run did not go ahead
+	Reason     string    `bson:"reason,omitempty"`
+	StartedAt  time.Time `bson:"started_at"`
+	FinishedAt time.Time `bson:"finished_at"`
+	Done       int       `bson:"done"`
+	Failed     int       `bson:"failed"`
+	Retried    int       `bson:"retried"`
+	Recovered  int       `bson:"recovered"`
+}
+
+// MongoHistory stores run records so a restarted instance knows what already ran.
+type MongoHistory struct {
+	Coll     *mongo.Collection
+	Instance string
+}
+
+func New(client *mongo.Client, dbName, collectionName, instance string) *MongoHistory {
+	return &MongoHistory{
+		Coll:     client.Database(dbName).Collection(collectionName),
+		Instance: instance,
+	}
+}
+
+// EnsureIndexes creates the index LastSuccess relies on.
+func (h *MongoHistory) EnsureIndexes(ctx context.Context) error {
+	_, err := h.Coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "service", Value: 1}, {Key: "status", Value: 1}, {Key: "started_at", Value: -1}},
+	})
+	return err
+}
+
+// Record stores run, stamped with this instance.
+func (h *MongoHistory) Record(ctx context.Context, run Run) error {
+	run.Instance = h.Instance
+	_, err := h.Coll.InsertOne(ctx, run)
+	return err
+}
+
+// LastSuccess returns when the last completed batch of service that did not
+// fail started, on any instance, or the zero time if there is none. The start
+// time is what counts since that is the scheduled run the batch served.
+func (h *MongoHistory) LastSuccess(ctx context.Context, service string) (time.Time, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "started_at", Value: -1}})
+	var run Run
+	err := h.Coll.FindOne(ctx, bson.M{"service": service, "status": StatusCompleted, "result": bson.M{"$ne": ResultFailed}}, opts).Decode(&run)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return run.StartedAt, nil
+}
@@ -0,0 +1,33 @@
+package history_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/history"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/testutil/mongotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMongoHistory(t *testing.T) {
+	ctx := context.Background()
+	h := history.New(mongotest.Client(t, ctx), "history_test", "run_history", "instance-a")
+	require.NoError(t, h.EnsureIndexes(ctx))
+
+	last, err := h.LastSuccess(ctx, "weather_db")
+	require.NoError(t, err)
+	assert.True(t, last.IsZero())
+
+	yesterday := time.Now().Add(-24 * time.Hour).Truncate(time.Millisecond)
+	today := time.Now().Truncate(time.Millisecond)
+	require.NoError(t, h.Record(ctx, history.Run{Service: "weather_db", RunID: "1", Status: history.StatusCompleted, StartedAt: yesterday, FinishedAt: yesterday}))
+	require.NoError(t, h.Record(ctx, history.Run{Service: "weather_db", RunID: "2", Status: history.StatusInterrupted, StartedAt: today, FinishedAt: today}))
+	require.NoError(t, h.Record(ctx, history.Run{Service: "openaq_db", RunID: "3", Status: history.StatusCompleted, StartedAt: today, FinishedAt: today}))
+
+	// Interrupted runs and other services don't count.
+	last, err = h.LastSuccess(ctx, "weather_db")
+	require.NoError(t, err)
+	assert.True(t, yesterday.Equal(last), "expected %v, got %v", yesterday, last)
+}
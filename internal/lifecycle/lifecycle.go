@@ -0,0 +1,98 @@
+// Package lifecycle shuts the aggregator down as an ordered list of stages,
+// each bounded by its own timeout.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+)
+
+// Stage is one step of the shutdown.
+type Stage struct {
+	Name string
+	// Timeout bounds the stage; zero leaves it to the caller's context.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// Manager runs the registered stages in order when Shutdown is called.
+type Manager struct {
+	mu     sync.Mutex
+	stages []Stage
+	once   sync.Once
+	err    error
+}
+
+func New() *Manager {
+	return &Manager{}
+}
+
+// Add registers a stage to run after the ones already added.
+func (m *Manager) Add(name string, timeout time.Duration, run func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stages = append(m.stages, Stage{Name: name, Timeout: timeout, Run: run})
+}
+
+// Shutdown runs the stages in the order they were added. A stage that fails
+// or runs out of time is logged and the next one still runs, so the last
+// stage (closing the database) always gets its turn. It returns the errors
+// of all stages. Only the first call runs them; later calls return the same
+// result once it is known.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.once.Do(func() {
+		m.mu.Lock()
+		stages := append([]Stage(nil), m.stages...)
+		m.mu.Unlock()
+
+		var errs []error
+		for _, st := range stages {
+			if err := runStage(ctx, st); err != nil {
+				logger.Error("Shutdown: %s failed: %v", st.Name, err)
+				errs = append(errs, fmt.Errorf("%s: %w", st.Name, err))
+			}
+		}
+		m.err = errors.Join(errs...)
+	})
+	return m.err
+}
+
+func runStage(ctx context.Context, st Stage) error {
+	if st.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, st.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	logger.Info("Shutdown: %s...", st.Name)
+	// A stage that ignores its context must not hold up the ones after it
+	errCh := make(chan error, 1)
+	go func() { errCh <- st.Run(ctx) }()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return err
+		}
+		logger.Info("Shutdown: %s done in %v.", st.Name, time.Since(start).Round(time.Millisecond))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Notify relays SIGINT and SIGTERM (sent by Docker and Kubernetes on stop)
+// to the returned channel.
+func Notify() <-chan os.Signal {
+	quit := make(chan os.Signal, 2)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	return quit
+}
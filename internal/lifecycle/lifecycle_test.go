@@ -0,0 +1,80 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_RunsStagesInOrder(t *testing.T) {
+	m := New()
+	var order []string
+	for _, name := range []string{"scheduler", "pools", "mongo"} {
+		m.Add(name, time.Second, func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		})
+	}
+
+	assert.NoError(t, m.Shutdown(context.Background()))
+	assert.Equal(t, []string{"scheduler", "pools", "mongo"}, order)
+}
+
+func TestManager_ContinuesAfterFailure(t *testing.T) {
+	m := New()
+	boom := errors.New("boom")
+	var ran []string
+	m.Add("fails", time.Second, func(ctx context.Context) error {
+		ran = append(ran, "fails")
+		return boom
+	})
+	m.Add("mongo", time.Second, func(ctx context.Context) error {
+		ran = append(ran, "mongo")
+		return nil
+	})
+
+	err := m.Shutdown(context.Background())
+	assert.ErrorIs(t, err, boom)
+	assert.Contains(t, err.Error(), "fails")
+	assert.Equal(t, []string{"fails", "mongo"}, ran)
+}
+
+func TestManager_StageTimeout(t *testing.T) {
+	m := New()
+	m.Add("drain", 20*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	// Ignores its context entirely
+	m.Add("stuck", 20*time.Millisecond, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	mongoRan := false
+	m.Add("mongo", time.Second, func(ctx context.Context) error {
+		mongoRan = true
+		return nil
+	})
+
+	start := time.Now()
+	err := m.Shutdown(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, mongoRan)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestManager_ShutdownOnce(t *testing.T) {
+	m := New()
+	calls := 0
+	m.Add("scheduler", time.Second, func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+
+	assert.NoError(t, m.Shutdown(context.Background()))
+	assert.NoError(t, m.Shutdown(context.Background()))
+	assert.Equal(t, 1, calls)
+}
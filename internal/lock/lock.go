@@ -0,0 +1,131 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+)
+
+var (
+	// ErrHeld is returned by Acquire while another holder's lease is live.
+	ErrHeld = errors.New("lock: held by another owner")
+	// ErrLost is returned by Refresh once the lease has expired and been taken over.
+	ErrLost = errors.New("lock: lease lost")
+)
+
+// releaseTimeout bounds how long releasing a lease may take.
+const releaseTimeout = 10 * time.Second
+
+// Store persists leases. Every successful Acquire of a name returns a larger
+// fencing token than the one before, so writes made under a stale lease can
+// be told apart from those of the current holder.
+type Store interface {
+	Acquire(ctx context.Context, name, owner string, ttl time.Duration) (int64, error)
+	Refresh(ctx context.Context, name, owner string, token int64, ttl time.Duration) error
+	Release(ctx context.Context, name, owner string, token int64) error
+	// Check returns ErrLost if a token newer than token was granted for name.
+	Check(ctx context.Context, name string, token int64) error
+}
+
+// Locker hands out leases that expire after TTL unless they are refreshed.
+type Locker struct {
+	Store Store
+	// Owner identifies this instance in the leases it holds.
+	Owner string
+	TTL   time.Duration
+}
+
+// Lease is an exclusive claim on a name.
+type Lease struct {
+	Name  string
+	Token int64
+
+	locker *Locker
+	lost   chan struct{}
+}
+
+// Acquire claims name, returning ErrHeld if another owner holds it.
+func (l *Locker) Acquire(ctx context.Context, name string) (*Lease, error) {
+	token, err := l.Store.Acquire(ctx, name, l.Owner, l.TTL)
+	if err != nil {
+		return nil, err
+	}
+	return &Lease{Name: name, Token: token, locker: l, lost: make(chan struct{})}, nil
+}
+
+// Lost is closed once the lease was found lost while it was held. It
+// implements models.Fence.
+func (ls *Lease) Lost() <-chan struct{} {
+	return ls.lost
+}
+
+// Check fails with ErrLost if another holder acquired the name since, so a
+// write made under the lease must not go ahead. It implements models.Fence.
+func (ls *Lease) Check(ctx context.Context) error {
+	return ls.locker.Store.Check(ctx, ls.Name, ls.Token)
+}
+
+// Hold keeps the lease alive until the returned release func is called, which
+// also gives it up. The returned context is cancelled if the lease is lost,
+// so work done under it stops once another owner may have taken over.
+func (ls *Lease) Hold(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ls.keepAlive(ctx, cancel, done)
+	}()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			cancel()
+
+			rctx, rcancel := context.WithTimeout(context.Background(), releaseTimeout)
+			defer rcancel()
+			if err := ls.locker.Store.Release(rctx, ls.Name, ls.locker.Owner, ls.Token); err != nil {
+				logger.Error("Failed to release lock %s: %v", ls.Name, err)
+			}
+		})
+	}
+	return ctx, release
+}
+
+func (ls *Lease) keepAlive(ctx context.Context, cancel context.CancelFunc, done <-chan struct{}) {
+	interval := ls.locker.TTL / 3
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := ls.locker.Store.Refresh(ctx, ls.Name, ls.locker.Owner, ls.Token, ls.locker.TTL)
+			if errors.Is(err, ErrLost) {
+				logger.Error("Lost lock %s (token %d), stopping its work", ls.Name, ls.Token)
+				close(ls.lost)
+				cancel()
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				// A transient error is retried on the next tick; the lease only
+				// lapses if refreshing keeps failing for a whole TTL.
+				logger.Error("Failed to refresh lock %s: %v", ls.Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package lock_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/lock"
+)
+
+// memStore is an in-memory lock.Store.
+type memStore struct {
+	mu      sync.Mutex
+	owner   map[string]string
+	token   map[string]int64
+	expires map[string]time.Time
+	// lose makes every Refresh report the lease as lost.
+	lose bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{owner: map[string]string{}, token: map[string]int64{}, expires: map[string]time.Time{}}
+}
+
+func (m *memStore) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Now().Before(m.expires[name]) {
+		return 0, lock.ErrHeld
+	}
+	m.token[name]++
+	m.owner[name] = owner
+	m.expires[name] = time.Now().Add(ttl)
+	return m.token[name], nil
+}
+
+func (m *memStore) Refresh(ctx context.Context, name, owner string, token int64, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lose || m.owner[name] != owner || m.token[name] != token {
+		return lock.ErrLost
+	}
+	m.expires[name] = time.Now().Add(ttl)
+	return nil
+}
+
+func (m *memStore) Release(ctx context.Context, name, owner string, token int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.owner[name] == owner && m.token[name] == token {
+		m.expires[name] = time.Time{}
+	}
+	return nil
+}
+
+func (m *memStore) Check(ctx context.Context, name string, token int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token[name] > token {
+		return lock.ErrLost
+	}
+	return nil
+}
+
+func TestLocker_AcquireRelease(t *testing.T) {
+	store := newMemStore()
+	a := &lock.Locker{Store: store, Owner: "a", TTL: time.Minute}
+	b := &lock.Locker{Store: store, Owner: "b", TTL: time.Minute}
+
+	lease, err := a.Acquire(context.Background(), "weather")
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := b.Acquire(context.Background(), "weather"); !errors.Is(err, lock.ErrHeld) {
+		t.Fatalf("expected ErrHeld, got %v", err)
+	}
+
+	ctx, release := lease.Hold(context.Background())
+	release()
+	release()
+	if ctx.Err() == nil {
+		t.Error("expected lease context to be cancelled after release")
+	}
+
+	next, err := b.Acquire(context.Background(), "weather")
+	if err != nil {
+		t.Fatalf("Acquire after release failed: %v", err)
+	}
+	if next.Token <= lease.Token {
+		t.Errorf("expected fencing token to increase, got %d after %d", next.Token, lease.Token)
+	}
+
+	// Writes under the old lease are fenced off, those under the new one are not
+	if err := lease.Check(context.Background()); !errors.Is(err, lock.ErrLost) {
+		t.Errorf("expected the old lease to fail the check, got %v", err)
+	}
+	if err := next.Check(context.Background()); err != nil {
+		t.Errorf("expected the new lease to pass the check, got %v", err)
+	}
+}
+
+func TestLease_HoldCancelsOnLoss(t *testing.T) {
+	store := newMemStore()
+	l := &lock.Locker{Store: store, Owner: "a", TTL: 30 * time.Millisecond}
+
+	lease, err := l.Acquire(context.Background(), "weather")
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	ctx, release := lease.Hold(context.Background())
+	defer release()
+
+	store.mu.Lock()
+	store.lose = true
+	store.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected lease context to be cancelled once the lease is lost")
+	}
+	select {
+	case <-lease.Lost():
+	default:
+		t.Error("expected Lost to be closed once the lease is lost")
+	}
+}
+
+func TestLease_HoldRefreshes(t *testing.T) {
+	store := newMemStore()
+	a := &lock.Locker{Store: store, Owner: "a", TTL: 30 * time.Millisecond}
+	b := &lock.Locker{Store: store, Owner: "b", TTL: 30 * time.Millisecond}
+
+	lease, err := a.Acquire(context.Background(), "weather")
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	ctx, release := lease.Hold(context.Background())
+
+	time.Sleep(100 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("lease context cancelled while the lease was held")
+	}
+	if _, err := b.Acquire(context.Background(), "weather"); !errors.Is(err, lock.ErrHeld) {
+		t.Errorf("expected held lease to outlive its TTL, got %v", err)
+	}
+
+	// Giving the lease up is not losing it
+	release()
+	select {
+	case <-lease.Lost():
+		t.Error("expected Lost to stay open after a release")
+	default:
+	}
+}
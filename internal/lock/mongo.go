@@ -0,0 +1,88 @@
+package lock
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore keeps one document per lock name. Released and expired leases
+// stay in the collection so the fencing token keeps increasing.
+type MongoStore struct {
+	Coll *mongo.Collection
+}
+
+func NewMongoStore(client *mongo.Client, dbName, collectionName string) *MongoStore {
+	return &MongoStore{Coll: client.Database(dbName).Collection(collectionName)}
+}
+
+type lockDoc struct {
+	Name       string    `bson:"_id"`
+	Owner      string    `bson:"owner"`
+	Token      int64     `bson:"token"`
+	ExpiresAt  time.Time `bson:"expires_at"`
+	AcquiredAt time.Time `bson:"acquired_at"`
+}
+
+// Acquire takes over the lock if it is free or its lease has expired. When
+// it is held, the upsert collides with the existing document and ErrHeld is
+// returned.
+func (s *MongoStore) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (int64, error) {
+	now := time.Now()
+	filter := bson.M{"_id": name, "expires_at": bson.M{"$lt": now}}
+	update := bson.M{
+		"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl), "acquired_at": now},
+		"$inc": bson.M{"token": int64(1)},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var doc lockDoc
+	err := s.Coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return 0, ErrHeld
+	}
+	if err != nil {
+		return 0, err
+	}
+	return doc.Token, nil
+}
+
+// Refresh extends a lease, returning ErrLost if it is no longer ours.
+func (s *MongoStore) Refresh(ctx context.Context, name, owner string, token int64, ttl time.Duration) error {
+	res, err := s.Coll.UpdateOne(ctx,
+		bson.M{"_id": name, "owner": owner, "token": token, "expires_at": bson.M{"$gte": time.Now()}},
+		bson.M{"$set": bson.M{"expires_at": time.Now().Add(ttl)}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLost
+	}
+	return nil
+}
+
+// Check returns ErrLost once a newer token than token was granted for name.
+func (s *MongoStore) Check(ctx context.Context, name string, token int64) error {
+	n, err := s.Coll.CountDocuments(ctx, bson.M{"_id": name, "token": bson.M{"$gt": token}})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrLost
+	}
+	return nil
+}
+
+// Release expires a lease straight away. Releasing a lease that was already
+// taken over is a no-op.
+func (s *MongoStore) Release(ctx context.Context, name, owner string, token int64) error {
+	_, err := s.Coll.UpdateOne(ctx,
+		bson.M{"_id": name, "owner": owner, "token": token},
+		bson.M{"$set": bson.M{"expires_at": time.Time{}}},
+	)
+	return err
+}
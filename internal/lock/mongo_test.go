@@ -0,0 +1,50 @@
+package lock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/lock"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/testutil/mongotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMongoStore(t *testing.T) {
+	ctx := context.Background()
+	store := lock.NewMongoStore(mongotest.Client(t, ctx), "lock_test", "locks")
+	ttl := 200 * time.Millisecond
+
+	first, err := store.Acquire(ctx, "weather", "a", ttl)
+	require.NoError(t, err)
+
+	_, err = store.Acquire(ctx, "weather", "b", ttl)
+	assert.ErrorIs(t, err, lock.ErrHeld)
+
+	// Other names are independent.
+	_, err = store.Acquire(ctx, "openaq", "b", ttl)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Refresh(ctx, "weather", "a", first, ttl))
+
+	// Once the lease expires another owner takes over with a larger token,
+	// and the previous holder can no longer refresh it.
+	time.Sleep(ttl + 50*time.Millisecond)
+	second, err := store.Acquire(ctx, "weather", "b", ttl)
+	require.NoError(t, err)
+	assert.Greater(t, second, first)
+	assert.ErrorIs(t, store.Refresh(ctx, "weather", "a", first, ttl), lock.ErrLost)
+	assert.ErrorIs(t, store.Check(ctx, "weather", first), lock.ErrLost)
+	assert.NoError(t, store.Check(ctx, "weather", second))
+
+	// A stale release leaves the new holder's lease alone.
+	require.NoError(t, store.Release(ctx, "weather", "a", first))
+	_, err = store.Acquire(ctx, "weather", "a", ttl)
+	assert.ErrorIs(t, err, lock.ErrHeld)
+
+	require.NoError(t, store.Release(ctx, "weather", "b", second))
+	third, err := store.Acquire(ctx, "weather", "a", ttl)
+	require.NoError(t, err)
+	assert.Greater(t, third, second)
+}
@@ -1,39 +1,250 @@
+// Package logger writes leveled, structured logs through log/slog, as text
+// or JSON. Messages are printf-style; fields attached to a context with
+// WithFields, or to a Logger with With, are added to every record logged
+// with it.
 package logger
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Standard field names.
+const (
+	Service    = "service"
+	WorkerID   = "worker_id"
+	RequestID  = "request_id"
+	RunID      = "run_id"
+	FetchParam = "fetch_param"
+)
+
+// Output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configure the output.
+type Options struct {
+	// Format is text or json
+	Format string
+	// Level applies to packages without one in Levels
+	Level slog.Level
+	// Levels sets the level of single packages, by the last element of
+	// their import path, such as workpool or main
+	Levels map[string]slog.Level
+	// Output defaults to stdout
+	Output io.Writer
+}
+
 var (
-	once   sync.Once
-	logger *log.Logger
+	once    sync.Once
+	current atomic.Pointer[handler]
 )
 
+// Init logs text at info level to stdout until Configure is called.
 func Init() {
 	once.Do(func() {
-		logger = log.New(os.Stdout, "APP_LOG: ", log.LstdFlags|log.Lshortfile)
+		if current.Load() == nil {
+			Configure(Options{})
+		}
 	})
 }
 
-func Info(message string, v ...interface{}) {
-	if logger == nil {
-		Init()
+// Configure replaces the output and levels, also for log/slog's default
+// logger.
+func Configure(opts Options) {
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	hopts := &slog.HandlerOptions{
+		AddSource: true,
+		// The handler filters by package; the base one lets everything through
+		Level:       slog.LevelDebug - 4,
+		ReplaceAttr: shortSource,
+	}
+	var base slog.Handler = slog.NewTextHandler(out, hopts)
+	if opts.Format == FormatJSON {
+		base = slog.NewJSONHandler(out, hopts)
+	}
+	h := &handler{base: base, levels: &atomic.Pointer[levels]{}}
+	h.levels.Store(newLevels(opts.Level, opts.Levels))
+	current.Store(h)
+	slog.SetDefault(slog.New(h))
+	// The log package is only used for fatal errors
+	slog.SetLogLoggerLevel(slog.LevelError)
+}
+
+// SetLevels changes the levels while the app runs, keeping the output.
+func SetLevels(level slog.Level, byPackage map[string]slog.Level) {
+	Init()
+	current.Load().levels.Store(newLevels(level, byPackage))
+}
+
+// shortSource trims the source file to its package directory and name, and
+// drops it when unknown.
+func shortSource(groups []string, a slog.Attr) slog.Attr {
+	if src, ok := a.Value.Any().(*slog.Source); ok && a.Key == slog.SourceKey && len(groups) == 0 {
+		if src.File == "" {
+			return slog.Attr{}
+		}
+		dir := filepath.Base(filepath.Dir(src.File))
+		return slog.String(slog.SourceKey, fmt.Sprintf("%s/%s:%d", dir, filepath.Base(src.File), src.Line))
+	}
+	return a
+}
+
+type levels struct {
+	def       slog.Level
+	min       slog.Level
+	byPackage map[string]slog.Level
+}
+
+func newLevels(def slog.Level, byPackage map[string]slog.Level) *levels {
+	l := &levels{def: def, min: def, byPackage: byPackage}
+	for _, level := range byPackage {
+		l.min = min(l.min, level)
+	}
+	return l
+}
+
+func (l *levels) of(pc uintptr) slog.Level {
+	if level, ok := l.byPackage[packageOf(pc)]; ok {
+		return level
+	}
+	return l.def
+}
+
+// packages caches the package name of each call site
+var packages sync.Map
+
+// packageOf returns the last element of the import path of the function at
+// pc, such as workpool for .../internal/workpool.(*WorkerPool).process.
+func packageOf(pc uintptr) string {
+	if name, ok := packages.Load(pc); ok {
+		return name.(string)
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	name := frame.Function[strings.LastIndex(frame.Function, "/")+1:]
+	name, _, _ = strings.Cut(name, ".")
+	packages.Store(pc, name)
+	return name
+}
+
+// handler filters records by the level of the package logging them and
+// adds the context's fields.
+type handler struct {
+	base   slog.Handler
+	levels *atomic.Pointer[levels]
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.levels.Load().min
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < h.levels.Load().of(r.PC) {
+		return nil
+	}
+	if fields := fieldsFrom(ctx); len(fields) > 0 {
+		r = r.Clone()
+		r.Add(fields...)
+	}
+	return h.base.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{base: h.base.WithAttrs(attrs), levels: h.levels}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{base: h.base.WithGroup(name), levels: h.levels}
+}
+
+type fieldsKey struct{}
+
+// WithFields returns a context carrying the given key-value pairs, in
+// addition to those ctx already carries, for every record logged with it.
+func WithFields(ctx context.Context, args ...any) context.Context {
+	prev := fieldsFrom(ctx)
+	// Never share prev's spare capacity with another context
+	fields := append(prev[:len(prev):len(prev)], args...)
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+func fieldsFrom(ctx context.Context) []any {
+	if ctx == nil {
+		return nil
 	}
-	logger.Printf("INFO: "+message, v...)
+	fields, _ := ctx.Value(fieldsKey{}).([]any)
+	return fields
+}
+
+// Logger logs printf-style messages with the fields of its context.
+type Logger struct {
+	ctx context.Context
+}
+
+// From returns a Logger with the fields ctx carries.
+func From(ctx context.Context) Logger {
+	return Logger{ctx: ctx}
+}
+
+// With returns a Logger that also adds the given key-value pairs.
+func (l Logger) With(args ...any) Logger {
+	return Logger{ctx: WithFields(l.context(), args...)}
 }
 
-func Error(message string, v ...interface{}) {
-	if logger == nil {
-		Init()
+// Context returns a context carrying the Logger's fields, to hand them on.
+func (l Logger) Context() context.Context {
+	return l.context()
+}
+
+func (l Logger) context() context.Context {
+	if l.ctx == nil {
+		return context.Background()
 	}
-	logger.Printf("ERROR: "+message, v...)
+	return l.ctx
 }
 
-func Debug(message string, v ...interface{}) {
-	if logger == nil {
-		Init()
+// Debug, Info, Warn and Error log a message at their level.
+func (l Logger) Debug(message string, v ...interface{}) {
+	logf(l.context(), slog.LevelDebug, message, v)
+}
+func (l Logger) Info(message string, v ...interface{}) { logf(l.context(), slog.LevelInfo, message, v) }
+func (l Logger) Warn(message string, v ...interface{}) { logf(l.context(), slog.LevelWarn, message, v) }
+func (l Logger) Error(message string, v ...interface{}) {
+	logf(l.context(), slog.LevelError, message, v)
+}
+
+// Debug, Info, Warn and Error log a message at their level, with no fields.
+func Debug(message string, v ...interface{}) { logf(context.Background(), slog.LevelDebug, message, v) }
+func Info(message string, v ...interface{})  { logf(context.Background(), slog.LevelInfo, message, v) }
+func Warn(message string, v ...interface{})  { logf(context.Background(), slog.LevelWarn, message, v) }
+func Error(message string, v ...interface{}) { logf(context.Background(), slog.LevelError, message, v) }
+
+// logf must be called straight from the exported functions, so the record
+// points at their caller.
+func logf(ctx context.Context, level slog.Level, message string, v []interface{}) {
+	Init()
+	h := current.Load()
+	if !h.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	if len(v) > 0 {
+		message = fmt.Sprintf(message, v...)
 	}
-	logger.Printf("DEBUG: "+message, v...)
+	h.Handle(ctx, slog.NewRecord(time.Now(), level, message, pcs[0]))
 }
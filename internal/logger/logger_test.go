@@ -2,30 +2,95 @@ package logger
 
 import (
 	"bytes"
-	"log"
+	"context"
+	"encoding/json"
+	"log/slog"
 	"strings"
-	"sync"
 	"testing"
 )
 
 func TestLoggerFunctionsCalled(t *testing.T) {
 	var buf bytes.Buffer
-
-	// Reset singleton
-	logger = nil
-	once = sync.Once{}
-
-	// Redirect package logger to buffer
-	logger = log.New(&buf, "", log.LstdFlags|log.Lshortfile)
+	Configure(Options{Output: &buf, Level: slog.LevelDebug})
+	defer Configure(Options{})
 
 	Info("info message")
+	Warn("warn %s", "message")
 	Error("error message")
 	Debug("debug message")
 
 	output := buf.String()
-	if !strings.Contains(output, "info message") ||
-		!strings.Contains(output, "error message") ||
-		!strings.Contains(output, "debug message") {
-		t.Errorf("logger functions not called, output: %s", output)
+	for _, want := range []string{
+		`level=INFO source=logger/logger_test.go:`, `msg="info message"`,
+		`level=WARN`, `msg="warn message"`,
+		`level=ERROR`, `msg="error message"`,
+		`level=DEBUG`, `msg="debug message"`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("missing %q in output:\n%s", want, output)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	Configure(Options{Output: &buf})
+	defer Configure(Options{})
+
+	Debug("hidden")
+	if buf.Len() > 0 {
+		t.Fatalf("debug logged at info level: %s", buf.String())
+	}
+
+	// A level for this package overrides the default
+	SetLevels(slog.LevelInfo, map[string]slog.Level{"logger": slog.LevelDebug})
+	Debug("shown")
+	if !strings.Contains(buf.String(), "shown") {
+		t.Errorf("debug not logged with logger=debug: %s", buf.String())
+	}
+
+	buf.Reset()
+	SetLevels(slog.LevelDebug, map[string]slog.Level{"logger": slog.LevelError})
+	Info("hidden")
+	slog.Warn("hidden too")
+	if buf.Len() > 0 {
+		t.Errorf("logged below the package level: %s", buf.String())
+	}
+}
+
+func TestFields(t *testing.T) {
+	var buf bytes.Buffer
+	Configure(Options{Output: &buf, Format: FormatJSON})
+	defer Configure(Options{})
+
+	ctx := WithFields(context.Background(), Service, "weather_db", RunID, "run-1")
+	log := From(ctx).With(WorkerID, 3, FetchParam, "Kabul")
+	log.Info("processing %s", "Kabul")
+	// Fields of one Logger do not leak into another made from the same context
+	From(ctx).With(WorkerID, 4).Error("failed")
+	slog.InfoContext(log.Context(), "plain slog")
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid JSON %q: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d:\n%s", len(records), buf.String())
+	}
+
+	first := records[0]
+	if first["msg"] != "processing Kabul" || first["level"] != "INFO" || first["service"] != "weather_db" ||
+		first["run_id"] != "run-1" || first["worker_id"] != float64(3) || first["fetch_param"] != "Kabul" {
+		t.Errorf("unexpected record: %v", first)
+	}
+	if second := records[1]; second["worker_id"] != float64(4) || second["fetch_param"] != nil {
+		t.Errorf("unexpected record: %v", second)
+	}
+	if third := records[2]; third["fetch_param"] != "Kabul" || third["msg"] != "plain slog" {
+		t.Errorf("unexpected record: %v", third)
 	}
 }
@@ -0,0 +1,122 @@
+// Package metrics holds the Prometheus metrics of the pipeline and serves
+// them for scraping.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "aggregator"
+
+// Registry holds every metric below, plus the Go runtime and process ones.
+var Registry = prometheus.NewRegistry()
+
+// factory registers each metric with Registry as it is made.
+var factory = promauto.With(Registry)
+
+// API client
+var (
+	// APIRequests counts HTTP attempts by provider, status code (or "error"
+	// when no response came back) and attempt number.
+	APIRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_total",
+		Help:      "Upstream API requests by provider, HTTP status (error when none was received) and attempt.",
+	}, []string{"provider", "status", "attempt"})
+	APIRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Duration of upstream API requests by provider.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider"})
+	RateLimitWait = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_rate_limit_wait_seconds",
+		Help:      "Time requests waited for a rate-limit token, by provider.",
+		Buckets:   []float64{.001, .01, .1, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"provider"})
+)
+
+// Worker pools
+var (
+	// StageDuration times fetch, parse and store by service and result (ok
+	// or error); the store stage is the MongoDB write.
+	StageDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pipeline_stage_duration_seconds",
+		Help:      "Duration of the fetch, parse and store stages by service and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "stage", "result"})
+)
+
+// Runs
+var (
+	// Runs counts batches by service, history status (completed,
+	// interrupted, skipped or deferred) and result (success, partial,
+	// failed or skipped).
+	Runs = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Batch runs by service, status and result.",
+	}, []string{"service", "status", "result"})
+	RunDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of the batch runs that ran, by service.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+	}, []string{"service"})
+)
+
+// Spool
+var (
+	Spooled = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spool_records_written_total",
+		Help:      "Records written to the spool while MongoDB was unreachable.",
+	})
+	Replayed = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spool_records_replayed_total",
+		Help:      "Spooled records replayed into MongoDB.",
+	})
+)
+
+// Staleness
+var (
+	// StaleParams and ParamStaleness cover only the params that are stale.
+	StaleParams = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stale_params",
+		Help:      "Fetch params without a success for longer than STALENESS_THRESHOLD, by service.",
+	}, []string{"service"})
+	ParamStaleness = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "param_staleness_seconds",
+		Help:      "Time since the last success of each stale fetch param.",
+	}, []string{"service", "param"})
+)
+
+func init() {
+	Registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Gauge registers a gauge read from fn at every scrape, such as a queue
+// depth, with the given constant labels.
+func Gauge(name, help string, labels map[string]string, fn func() float64) {
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        name,
+		Help:        help,
+		ConstLabels: labels,
+	}, fn)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	depth := 3
+	Gauge("test_queued", "Test queue depth.", map[string]string{"service": "weather_db"}, func() float64 {
+		return float64(depth)
+	})
+	APIRequests.WithLabelValues("weather_db", "429", "1").Inc()
+	StageDuration.WithLabelValues("weather_db", "store", "ok").Observe(0.02)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `aggregator_test_queued{service="weather_db"} 3`)
+	assert.Contains(t, body, `aggregator_api_requests_total{attempt="1",provider="weather_db",status="429"} 1`)
+	assert.Contains(t, body, `aggregator_pipeline_stage_duration_seconds_count{result="ok",service="weather_db",stage="store"} 1`)
+	assert.Contains(t, body, "go_goroutines")
+}
@@ -0,0 +1,227 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	StatusLeased = "leased"
+	StatusFailed = "failed"
+
+	// failedRetention is how long failed items are kept for inspection.
+	failedRetention = 7 * 24 * time.Hour
+)
+
+// Item is a queued request as stored in Mongo.
+type Item struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	Service    string             `bson:"service"`
+	RequestID  string             `bson:"request_id"`
+	RunID      string             `bson:"run_id"`
+	Priority   int                `bson:"priority"`
+	Status     string             `bson:"status"`
+	Owner      string             `bson:"owner"`
+	LeaseUntil time.Time          `bson:"lease_until"`
+	Attempts   int                `bson:"attempts"`
+	LastError  string             `bson:"last_error,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	ExpireAt   *time.Time         `bson:"expire_at,omitempty"`
+}
+
+// MongoQueue is a crash-safe record of submitted requests. Every item is
+// leased by the process that holds it in memory; that process keeps its
+// leases alive with Heartbeat, and once it stops doing so its items become
+// visible to Lease again and can be resumed by any instance, including its
+// own successor after a restart.
+type MongoQueue struct {
+	Coll *mongo.Collection
+	// Owner identifies this instance in leases.
+	Owner string
+	// Visibility is how long a lease lasts without a heartbeat.
+	Visibility time.Duration
+
+	// held are the items this process appended or leased and has not acked.
+	mu   sync.Mutex
+	held map[primitive.ObjectID]bool
+}
+
+func New(client *mongo.Client, dbName, collectionName, owner string, visibility time.Duration) *MongoQueue {
+	return &MongoQueue{
+		Coll:       client.Database(dbName).Collection(collectionName),
+		Owner:      owner,
+		Visibility: visibility,
+	}
+}
+
+// EnsureIndexes creates the indexes Lease and the cleanup of failed items rely on.
+func (q *MongoQueue) EnsureIndexes(ctx context.Context) error {
+	_, err := q.Coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_until", Value: 1}}},
+		{Keys: bson.D{{Key: "owner", Value: 1}}},
+		{Keys: bson.D{{Key: "expire_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// Append implements channels.Journal. The item is leased to this instance
+// straight away since the caller is about to queue it in memory.
+func (q *MongoQueue) Append(ctx context.Context, req models.DataRequest) (string, error) {
+	now := time.Now()
+	item := Item{
+		Service:    req.Service,
+		RequestID:  req.ID,
+		RunID:      req.RunID,
+		Priority:   req.Priority,
+		Status:     StatusLeased,
+		Owner:      q.Owner,
+		LeaseUntil: now.Add(q.Visibility),
+		Attempts:   1,
+		CreatedAt:  now,
+	}
+
+	res, err := q.Coll.InsertOne(ctx, item)
+	if err != nil {
+		return "", err
+	}
+	id, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", fmt.Errorf("unexpected inserted ID type %T", res.InsertedID)
+	}
+	q.hold(id, true)
+	return id.Hex(), nil
+}
+
+func (q *MongoQueue) hold(id primitive.ObjectID, held bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !held {
+		delete(q.held, id)
+		return
+	}
+	if q.held == nil {
+		q.held = make(map[primitive.ObjectID]bool)
+	}
+	q.held[id] = true
+}
+
+// Ack implements channels.Journal: a successful item is deleted, a failed one
+// is kept for a while with its error.
+func (q *MongoQueue) Ack(ctx context.Context, key string, reqErr error) error {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return fmt.Errorf("invalid queue key %q: %w", key, err)
+	}
+	q.hold(id, false)
+
+	if reqErr == nil {
+		_, err = q.Coll.DeleteOne(ctx, bson.M{"_id": id})
+		return err
+	}
+
+	expireAt := time.Now().Add(failedRetention)
+	_, err = q.Coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"status":     StatusFailed,
+		"last_error": reqErr.Error(),
+		"expire_at":  expireAt,
+	}})
+	return err
+}
+
+// Lease claims the highest priority item whose lease has expired, or returns
+// nil when there is none.
+func (q *MongoQueue) Lease(ctx context.Context) (*Item, error) {
+	now := time.Now()
+	filter := bson.M{"status": StatusLeased, "lease_until": bson.M{"$lt": now}}
+	update := bson.M{
+		"$set": bson.M{"owner": q.Owner, "lease_until": now.Add(q.Visibility)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var item Item
+	err := q.Coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&item)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	q.hold(item.ID, true)
+	return &item, nil
+}
+
+// Release gives up this instance's lease on an item without finishing it,
+// so it can be leased again straight away. The attempt Lease counted is
+// taken back.
+func (q *MongoQueue) Release(ctx context.Context, key string) error {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return fmt.Errorf("invalid queue key %q: %w", key, err)
+	}
+	q.hold(id, false)
+
+	_, err = q.Coll.UpdateOne(ctx,
+		bson.M{"_id": id, "owner": q.Owner, "status": StatusLeased},
+		bson.M{
+			"$set": bson.M{"lease_until": time.Now()},
+			"$inc": bson.M{"attempts": -1},
+		},
+	)
+	return err
+}
+
+// Heartbeat extends the leases of the items this process holds. Items left
+// by an earlier process with the same Owner, e.g. before a restart, are not
+// renewed, so they expire and are resumed.
+func (q *MongoQueue) Heartbeat(ctx context.Context) error {
+	q.mu.Lock()
+	ids := make([]primitive.ObjectID, 0, len(q.held))
+	for id := range q.held {
+		ids = append(ids, id)
+	}
+	q.mu.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := q.Coll.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "owner": q.Owner, "status": StatusLeased},
+		bson.M{"$set": bson.M{"lease_until": time.Now().Add(q.Visibility)}},
+	)
+	return err
+}
+
+// Pending counts items that have not finished yet, across all owners.
+func (q *MongoQueue) Pending(ctx context.Context) (int64, error) {
+	return q.Coll.CountDocuments(ctx, bson.M{"status": StatusLeased})
+}
+
+// KeepAlive heartbeats every Visibility/3 until ctx is cancelled.
+func (q *MongoQueue) KeepAlive(ctx context.Context) {
+	ticker := time.NewTicker(q.Visibility / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.Heartbeat(ctx); err != nil && ctx.Err() == nil {
+				logger.Error("Queue heartbeat failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/queue"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/testutil/mongotest"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMongoQueue(t *testing.T) {
+	ctx := context.Background()
+	client := mongotest.Client(t, ctx)
+
+	visibility := 200 * time.Millisecond
+	crashed := queue.New(client, "queue_test", "job_queue", "instance-a", visibility)
+	survivor := queue.New(client, "queue_test", "job_queue", "instance-b", visibility)
+	require.NoError(t, crashed.EnsureIndexes(ctx))
+
+	key, err := crashed.Append(ctx, models.DataRequest{ID: "Kabul", Service: "weather", RunID: "run-1", Priority: 1})
+	require.NoError(t, err)
+	_, err = crashed.Append(ctx, models.DataRequest{ID: "Tirana", Service: "weather", RunID: "run-1", Priority: 5})
+	require.NoError(t, err)
+
+	pending, err := survivor.Pending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pending)
+
+	// Leases held by a live owner are invisible to others.
+	item, err := survivor.Lease(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, item)
+
+	// Heartbeats keep them that way past the visibility timeout.
+	time.Sleep(visibility / 2)
+	require.NoError(t, crashed.Heartbeat(ctx))
+	time.Sleep(visibility / 2)
+	item, err = survivor.Lease(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, item)
+
+	// A restarted owner with the same instance ID does not renew them.
+	restarted := queue.New(client, "queue_test", "job_queue", "instance-a", visibility)
+	time.Sleep(visibility / 2)
+	require.NoError(t, restarted.Heartbeat(ctx))
+
+	// Once the owner stops heartbeating, the items can be taken over, highest priority first.
+	time.Sleep(visibility + 50*time.Millisecond)
+	item, err = survivor.Lease(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, item)
+	assert.Equal(t, "Tirana", item.RequestID)
+	assert.Equal(t, "instance-b", item.Owner)
+	assert.Equal(t, 2, item.Attempts)
+
+	require.NoError(t, survivor.Ack(ctx, item.ID.Hex(), nil))
+	count, err := survivor.Coll.CountDocuments(ctx, bson.M{"_id": item.ID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	// A failed request is kept with its error instead of being retried.
+	require.NoError(t, crashed.Ack(ctx, key, errors.New("API returned 404")))
+	id, err := primitive.ObjectIDFromHex(key)
+	require.NoError(t, err)
+	var failed queue.Item
+	require.NoError(t, survivor.Coll.FindOne(ctx, bson.M{"_id": id}).Decode(&failed))
+	assert.Equal(t, queue.StatusFailed, failed.Status)
+	assert.Equal(t, "API returned 404", failed.LastError)
+	assert.NotNil(t, failed.ExpireAt)
+
+	pending, err = survivor.Pending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pending)
+}
@@ -0,0 +1,171 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Leaser hands out queued items whose owner stopped heartbeating.
+type Leaser interface {
+	Lease(ctx context.Context) (*Item, error)
+	Ack(ctx context.Context, key string, err error) error
+	Release(ctx context.Context, key string) error
+}
+
+// Gate admits resumed requests of a service the way its batches are admitted.
+type Gate interface {
+	// Admit returns the context to submit service's requests with and a func
+	// to call once they have finished, or an error if they may not run now.
+	Admit(ctx context.Context, service string) (context.Context, func(), error)
+}
+
+// Target tells a Resumer how to rebuild a service's requests and where to queue them.
+type Target struct {
+	Channels *channels.Channels
+	Build    func(id string) models.DataRequest
+}
+
+// Resumer requeues items left behind by a crashed or stopped instance,
+// including this one before a restart.
+type Resumer struct {
+	Queue   Leaser
+	Targets map[string]Target
+	// Gate, when set, holds back the items of services that may not run now.
+	// Items of keys another instance is responsible for are left to it.
+	Gate     Gate
+	Interval time.Duration
+}
+
+// resumption is what one ResumeOnce pass resumes of a service. Its requests
+// run under a run ID of their own, since the run they were submitted in is
+// gone.
+type resumption struct {
+	ctx     context.Context
+	runID   string
+	release func()
+	// err is why the service's items are not resumed in this pass
+	err error
+}
+
+// Run resumes abandoned items immediately and then every Interval until ctx
+// is cancelled.
+func (r *Resumer) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.ResumeOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("Failed to resume queued requests: %v", err)
+		}
+		if n > 0 {
+			logger.Info("Resumed %d queued requests from a previous run.", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ResumeOnce leases every abandoned item and submits it to its service's
+// channels, returning how many were resumed. Items that may not run now are
+// handed back to the queue once the pass is over.
+func (r *Resumer) ResumeOnce(ctx context.Context) (int, error) {
+	runs := make(map[string]*resumption)
+	// Keeping the lease until the end stops this pass from leasing them again
+	var skipped []string
+	defer func() {
+		for service, run := range runs {
+			r.finish(ctx, r.Targets[service].Channels, run)
+		}
+		for _, key := range skipped {
+			if err := r.Queue.Release(ctx, key); err != nil && ctx.Err() == nil {
+				logger.Error("Failed to release queued request %s: %v", key, err)
+			}
+		}
+	}()
+
+	resumed := 0
+	for {
+		item, err := r.Queue.Lease(ctx)
+		if err != nil {
+			return resumed, err
+		}
+		if item == nil {
+			return resumed, nil
+		}
+
+		key := item.ID.Hex()
+		target, ok := r.Targets[item.Service]
+		if !ok {
+			unknown := fmt.Errorf("no target for service %q", item.Service)
+			if err := r.Queue.Ack(ctx, key, unknown); err != nil {
+				return resumed, err
+			}
+			continue
+		}
+
+		run, ok := runs[item.Service]
+		if !ok {
+			run = r.admit(ctx, item.Service)
+			runs[item.Service] = run
+		}
+		if run.err != nil || !cluster.Owns(run.ctx, item.Service, item.RequestID) {
+			skipped = append(skipped, key)
+			continue
+		}
+
+		req := target.Build(item.RequestID)
+		req.RunID = run.runID
+		req.Priority = item.Priority
+		req.QueueKey = key
+		if _, err := target.Channels.Submit(run.ctx, req); err != nil {
+			skipped = append(skipped, key)
+			return resumed, err
+		}
+		resumed++
+	}
+}
+
+// admit starts resuming service's items, if Gate lets them run.
+func (r *Resumer) admit(ctx context.Context, service string) *resumption {
+	run := &resumption{ctx: ctx, runID: primitive.NewObjectID().Hex(), release: func() {}}
+	if r.Gate != nil {
+		gated, release, err := r.Gate.Admit(ctx, service)
+		if err != nil {
+			logger.Info("[%s] Not resuming queued requests for now: %v", service, err)
+			run.err = err
+			return run
+		}
+		run.ctx, run.release = gated, release
+	}
+	return run
+}
+
+// finish drops the bookkeeping of run and releases what Gate admitted it
+// under once its requests are done. Nobody else waits for them.
+func (r *Resumer) finish(ctx context.Context, ch *channels.Channels, run *resumption) {
+	if run.err != nil {
+		return
+	}
+	go func() {
+		// The requests outlive the producers' ctx while the pools drain
+		ch.Wait(context.WithoutCancel(ctx), run.runID)
+		ch.Forget(run.runID)
+		run.release()
+	}()
+}
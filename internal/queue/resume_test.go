@@ -0,0 +1,208 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/queue"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeLeaser hands out its items once each and records acks and releases.
+type fakeLeaser struct {
+	items    []*queue.Item
+	acked    map[string]error
+	released []string
+	err      error
+}
+
+func (f *fakeLeaser) Lease(ctx context.Context) (*queue.Item, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(f.items) == 0 {
+		return nil, nil
+	}
+	item := f.items[0]
+	f.items = f.items[1:]
+	return item, nil
+}
+
+func (f *fakeLeaser) Ack(ctx context.Context, key string, err error) error {
+	f.acked[key] = err
+	return nil
+}
+
+func (f *fakeLeaser) Release(ctx context.Context, key string) error {
+	f.released = append(f.released, key)
+	return nil
+}
+
+// fakeGate admits the services it has a partition for, and records releases.
+type fakeGate struct {
+	partitions map[string]*cluster.Partition
+	released   chan string
+}
+
+func (g *fakeGate) Admit(ctx context.Context, service string) (context.Context, func(), error) {
+	p, ok := g.partitions[service]
+	if !ok {
+		return nil, nil, errors.New("blacked out")
+	}
+	return cluster.WithPartition(ctx, p), func() { g.released <- service }, nil
+}
+
+func TestResumer_ResumeOnce(t *testing.T) {
+	weatherItem := &queue.Item{ID: primitive.NewObjectID(), Service: "weather", RequestID: "Kabul", RunID: "run-1", Priority: 2}
+	unknownItem := &queue.Item{ID: primitive.NewObjectID(), Service: "gone", RequestID: "x"}
+	leaser := &fakeLeaser{
+		items: []*queue.Item{weatherItem, unknownItem},
+		acked: make(map[string]error),
+	}
+
+	ch := channels.New()
+	r := &queue.Resumer{
+		Queue: leaser,
+		Targets: map[string]queue.Target{
+			"weather": {
+				Channels: ch,
+				Build: func(id string) models.DataRequest {
+					return models.DataRequest{ID: id, Service: "weather"}
+				},
+			},
+		},
+	}
+
+	n, err := r.ResumeOnce(context.Background())
+	if err != nil {
+		t.Fatalf("ResumeOnce failed: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 resumed request, got %d", n)
+	}
+
+	req := <-ch.DataRequest
+	if req.ID != "Kabul" || req.Priority != 2 {
+		t.Errorf("unexpected resumed request: %+v", req)
+	}
+	if req.RunID == "" || req.RunID == "run-1" {
+		t.Errorf("expected resumed request to run under a new run ID, got %q", req.RunID)
+	}
+	if req.QueueKey != weatherItem.ID.Hex() {
+		t.Errorf("expected queue key %s, got %s", weatherItem.ID.Hex(), req.QueueKey)
+	}
+	if got := ch.Stats(req.RunID).Queued; got != 1 {
+		t.Errorf("expected resumed request to be tracked, got %d queued", got)
+	}
+
+	// Once done, nothing is kept of the run
+	ch.Finish(ch.Start(req), nil)
+	deadline := time.Now().Add(time.Second)
+	for ch.Stats(req.RunID).Done != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the resumed run to be forgotten once done")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err, ok := leaser.acked[unknownItem.ID.Hex()]; !ok || err == nil {
+		t.Errorf("expected item for unknown service to be acked as failed, got %v", leaser.acked)
+	}
+}
+
+func TestResumer_LeaseError(t *testing.T) {
+	r := &queue.Resumer{Queue: &fakeLeaser{err: errors.New("mongo down"), acked: map[string]error{}}}
+
+	if _, err := r.ResumeOnce(context.Background()); err == nil {
+		t.Fatal("expected lease error to be returned")
+	}
+}
+
+func TestResumer_Gate(t *testing.T) {
+	// Of the two instances, self is responsible for Kabul and peer for Oslo
+	part := cluster.NewPartition("self", []string{"self", "peer"})
+	var mine, theirs string
+	for _, city := range []string{"Kabul", "Oslo", "Lahore", "Tirana", "Quito", "Lima", "Accra"} {
+		if part.Owns("weather/" + city) {
+			mine = city
+		} else {
+			theirs = city
+		}
+	}
+	if mine == "" || theirs == "" {
+		t.Fatal("expected the cities to be split between the instances")
+	}
+
+	ownItem := &queue.Item{ID: primitive.NewObjectID(), Service: "weather", RequestID: mine}
+	peerItem := &queue.Item{ID: primitive.NewObjectID(), Service: "weather", RequestID: theirs}
+	heldItem := &queue.Item{ID: primitive.NewObjectID(), Service: "aqi", RequestID: "2178"}
+	leaser := &fakeLeaser{items: []*queue.Item{ownItem, peerItem, heldItem}, acked: make(map[string]error)}
+
+	weather, aqi := channels.New(), channels.New()
+	build := func(service string) func(id string) models.DataRequest {
+		return func(id string) models.DataRequest { return models.DataRequest{ID: id, Service: service} }
+	}
+	gate := &fakeGate{partitions: map[string]*cluster.Partition{"weather": part}, released: make(chan string, 1)}
+	r := &queue.Resumer{
+		Queue: leaser,
+		Gate:  gate,
+		Targets: map[string]queue.Target{
+			"weather": {Channels: weather, Build: build("weather")},
+			"aqi":     {Channels: aqi, Build: build("aqi")},
+		},
+	}
+
+	n, err := r.ResumeOnce(context.Background())
+	if err != nil {
+		t.Fatalf("ResumeOnce failed: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 resumed request, got %d", n)
+	}
+	if req := <-weather.DataRequest; req.ID != mine {
+		t.Errorf("expected %s to be resumed, got %s", mine, req.ID)
+	} else {
+		weather.Finish(weather.Start(req), nil)
+	}
+	if got := aqi.Stats("").Queued; got != 0 {
+		t.Errorf("expected nothing to be resumed for a held back service, got %d queued", got)
+	}
+
+	want := []string{peerItem.ID.Hex(), heldItem.ID.Hex()}
+	if len(leaser.released) != 2 || leaser.released[0] != want[0] || leaser.released[1] != want[1] {
+		t.Errorf("expected %v to be released, got %v", want, leaser.released)
+	}
+	select {
+	case service := <-gate.released:
+		if service != "weather" {
+			t.Errorf("expected the weather admission to be released, got %s", service)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the admission to be released once the resumed requests finished")
+	}
+}
+
+func TestResumer_SubmitFailureReleases(t *testing.T) {
+	item := &queue.Item{ID: primitive.NewObjectID(), Service: "weather", RequestID: "Kabul"}
+	leaser := &fakeLeaser{items: []*queue.Item{item}, acked: make(map[string]error)}
+	ch := channels.New()
+	ch.Close()
+	r := &queue.Resumer{
+		Queue: leaser,
+		Targets: map[string]queue.Target{
+			"weather": {Channels: ch, Build: func(id string) models.DataRequest { return models.DataRequest{ID: id} }},
+		},
+	}
+
+	if _, err := r.ResumeOnce(context.Background()); !errors.Is(err, channels.ErrClosed) {
+		t.Fatalf("expected the submit error, got %v", err)
+	}
+	if len(leaser.released) != 1 || leaser.released[0] != item.ID.Hex() {
+		t.Errorf("expected the item to be released, got %v", leaser.released)
+	}
+}
@@ -0,0 +1,162 @@
+// Package reload re-reads the configuration while the app runs and hands
+// the settings that can change live to the parts that use them.
+package reload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/config"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+)
+
+// ErrInvalid is returned when the configuration does not load; the one in
+// effect is kept.
+var ErrInvalid = errors.New("invalid configuration")
+
+// Func applies a new configuration to one part of the app.
+type Func func(cfg *config.Config) error
+
+// Result says what a reload changed, by Config field name.
+type Result struct {
+	// Applied lists the settings that changed and now take effect.
+	Applied []string `json:"applied"`
+	// Restart lists the settings that differ from those the app started
+	// with but only take effect after a restart.
+	Restart []string `json:"restart,omitempty"`
+}
+
+// Reloader loads the configuration again on request and applies it. A
+// configuration that does not load is rejected as a whole, keeping the one
+// in effect.
+type Reloader struct {
+	load func() (*config.Config, error)
+
+	// mu serialises reloads
+	mu       sync.Mutex
+	started  *config.Config
+	current  *config.Config
+	appliers []applier
+}
+
+type applier struct {
+	fields []string
+	apply  Func
+}
+
+// New returns a Reloader for the app started with cfg. load reads the
+// configuration the same way it was read at startup.
+func New(cfg *config.Config, load func() (*config.Config, error)) *Reloader {
+	return &Reloader{load: load, started: cfg, current: cfg}
+}
+
+// Live registers fn to apply changes to the named Config fields. fn is
+// called once per reload that changes any of them.
+func (r *Reloader) Live(fn Func, fields ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appliers = append(r.appliers, applier{fields: fields, apply: fn})
+}
+
+// Reload loads the configuration and applies what changed, logging the
+// outcome. Settings no applier handles are reported in Result.Restart.
+func (r *Reloader) Reload() (Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res, err := r.reload()
+	report(res, err)
+	return res, err
+}
+
+func (r *Reloader) reload() (Result, error) {
+	next, err := r.load()
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	var res Result
+	changed := config.Changed(r.current, next)
+	var errs []error
+	for _, a := range r.appliers {
+		if !slices.ContainsFunc(a.fields, func(f string) bool { return slices.Contains(changed, f) }) {
+			continue
+		}
+		if err := a.apply(next); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, f := range changed {
+		if r.live(f) {
+			res.Applied = append(res.Applied, f)
+		}
+	}
+	for _, f := range config.Changed(r.started, next) {
+		if !r.live(f) {
+			res.Restart = append(res.Restart, f)
+		}
+	}
+	r.current = next
+	return res, errors.Join(errs...)
+}
+
+// live must be called with mu held.
+func (r *Reloader) live(field string) bool {
+	for _, a := range r.appliers {
+		if slices.Contains(a.fields, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Config returns the configuration in effect.
+func (r *Reloader) Config() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Run reloads on every signal from sig until ctx is done, logging the
+// outcome.
+func (r *Reloader) Run(ctx context.Context, sig <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-sig:
+			logger.Info("Received %s, reloading configuration.", s)
+			r.Reload()
+		}
+	}
+}
+
+func report(res Result, err error) {
+	switch {
+	case errors.Is(err, ErrInvalid):
+		logger.Error("Configuration not reloaded, keeping the current one: %v", err)
+		return
+	case err != nil:
+		logger.Error("Configuration reloaded with errors: %v", err)
+	case len(res.Applied) == 0:
+		logger.Info("Configuration reloaded, nothing to apply.")
+	default:
+		logger.Info("Configuration reloaded, applied %v.", res.Applied)
+	}
+	if len(res.Restart) > 0 {
+		logger.Info("Changed settings that need a restart to take effect: %v.", res.Restart)
+	}
+}
+
+// Notify relays SIGHUP to the returned channel.
+func Notify() <-chan os.Signal {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	return hup
+}
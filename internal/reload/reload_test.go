@@ -0,0 +1,81 @@
+package reload
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloader(t *testing.T) {
+	started := &config.Config{WorkerCount: 5, ScheduleAt: "07:30", AdminAddr: ":8081"}
+	next := *started
+	var loadErr error
+	r := New(started, func() (*config.Config, error) {
+		cfg := next
+		return &cfg, loadErr
+	})
+
+	var workers []int
+	var schedules []string
+	r.Live(func(cfg *config.Config) error {
+		workers = append(workers, cfg.WorkerCount)
+		return nil
+	}, "WorkerCount")
+	r.Live(func(cfg *config.Config) error {
+		schedules = append(schedules, cfg.ScheduleAt)
+		return nil
+	}, "ScheduleAt", "RetryPasses")
+
+	// Only the appliers of changed settings run
+	next.WorkerCount = 8
+	next.AdminAddr = ":9090"
+	res, err := r.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"WorkerCount"}, res.Applied)
+	assert.Equal(t, []string{"AdminAddr"}, res.Restart)
+	assert.Equal(t, []int{8}, workers)
+	assert.Empty(t, schedules)
+
+	// Restart keeps listing what differs from startup until it is undone
+	next.ScheduleAt = "09:00"
+	res, err = r.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ScheduleAt"}, res.Applied)
+	assert.Equal(t, []string{"AdminAddr"}, res.Restart)
+	assert.Equal(t, []int{8}, workers)
+	assert.Equal(t, []string{"09:00"}, schedules)
+
+	next.AdminAddr = ":8081"
+	res, err = r.Reload()
+	require.NoError(t, err)
+	assert.Empty(t, res.Applied)
+	assert.Empty(t, res.Restart)
+
+	// A configuration that does not load changes nothing
+	loadErr = errors.New("WORKER_COUNT=0 must be at least 1")
+	next.WorkerCount = 0
+	_, err = r.Reload()
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.Equal(t, 8, r.Config().WorkerCount)
+	assert.Equal(t, []int{8}, workers)
+}
+
+func TestReloader_ApplyError(t *testing.T) {
+	started := &config.Config{ScheduleAt: "07:30"}
+	next := *started
+	r := New(started, func() (*config.Config, error) {
+		cfg := next
+		return &cfg, nil
+	})
+	boom := errors.New("boom")
+	r.Live(func(cfg *config.Config) error { return boom }, "ScheduleAt")
+
+	next.ScheduleAt = "08:00"
+	res, err := r.Reload()
+	assert.ErrorIs(t, err, boom)
+	assert.NotErrorIs(t, err, ErrInvalid)
+	assert.Equal(t, []string{"ScheduleAt"}, res.Applied)
+}
@@ -0,0 +1,127 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/blackout"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/history"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maintenanceRecheck is how long a deferred run waits when maintenance was
+// switched on without an end time.
+const maintenanceRecheck = 5 * time.Minute
+
+// Maintenance reports the global maintenance mode.
+type Maintenance interface {
+	Current(ctx context.Context) (blackout.Mode, error)
+}
+
+// BlackoutPolicy decides what happens to a service's run that falls in a
+// blackout window or in maintenance.
+type BlackoutPolicy string
+
+const (
+	// BlackoutDefer runs the service once the window closes.
+	BlackoutDefer BlackoutPolicy = "defer"
+	// BlackoutSkip drops the run and waits for the next scheduled one.
+	BlackoutSkip BlackoutPolicy = "skip"
+)
+
+func ParseBlackoutPolicy(v string) (BlackoutPolicy, error) {
+	switch p := BlackoutPolicy(v); p {
+	case BlackoutDefer, BlackoutSkip:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown blackout policy %q (want %s or %s)", v, BlackoutDefer, BlackoutSkip)
+}
+
+// maintenance returns the current maintenance mode. If it cannot be read the
+// run goes ahead.
+func (s *Scheduler) maintenance(ctx context.Context) blackout.Mode {
+	if s.Maintenance == nil {
+		return blackout.Mode{}
+	}
+	mode, err := s.Maintenance.Current(ctx)
+	if err != nil {
+		logger.Error("Failed to read maintenance mode, running anyway: %v", err)
+		return blackout.Mode{}
+	}
+	return mode
+}
+
+// blackedOut reports why the service known by names may not run at now and
+// until when. A zero until means the end is not known yet.
+func (s *Scheduler) blackedOut(mode blackout.Mode, now time.Time, names ...string) (string, time.Time, bool) {
+	if mode.Active(now) {
+		reason := "maintenance"
+		if mode.Reason != "" {
+			reason += ": " + mode.Reason
+		}
+		return reason, mode.Until, true
+	}
+	if w, until, ok := s.Settings().Blackouts.Active(now, names...); ok {
+		return "blackout " + w.String(), until, true
+	}
+	return "", time.Time{}, false
+}
+
+// holdBack skips or defers a blacked-out service's run, as Policy says, and
+// records why. A deferred run is started with ctx once the window closes;
+// a service has at most one deferred run pending.
+func (s *Scheduler) holdBack(ctx context.Context, runID string, client *mongo.Client, ch *channels.Channels, service SchedulableService, reason string, until time.Time) RunSummary {
+	name := service.Name()
+	now := time.Now()
+	summary := RunSummary{Service: name, RunID: runID, Status: StatusSkipped, StartedAt: now, FinishedAt: now}
+	status := history.StatusSkipped
+
+	if s.Settings().BlackoutPolicy != BlackoutSkip {
+		delay := maintenanceRecheck
+		if !until.IsZero() {
+			delay = until.Sub(now)
+		}
+		if s.deferRun(ctx, client, ch, service, delay) {
+			summary.Reason = fmt.Sprintf("%s, deferred to %s", reason, now.Add(delay).UTC().Format(time.RFC3339))
+		} else {
+			summary.Reason = reason + ", a deferred run is already pending"
+		}
+		status = history.StatusDeferred
+	} else {
+		summary.Reason = reason + ", skipped"
+	}
+	logger.Info("[%s] Not running (run %s): %s.", name, runID, summary.Reason)
+
+	s.mu.Lock()
+	last := summary
+	s.statusFor(name).last = &last
+	s.mu.Unlock()
+	s.record(name, summary, status)
+	return summary
+}
+
+// deferRun runs service after delay unless a deferred run of it is already
+// pending. It reports whether it scheduled one.
+func (s *Scheduler) deferRun(ctx context.Context, client *mongo.Client, ch *channels.Channels, service SchedulableService, delay time.Duration) bool {
+	name := service.Name()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.deferred == nil {
+		s.deferred = make(map[string]*time.Timer)
+	}
+	if _, pending := s.deferred[name]; pending {
+		return false
+	}
+	s.deferred[name] = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		delete(s.deferred, name)
+		s.mu.Unlock()
+		logger.Info("[%s] Running deferred batch.", name)
+		s.runAllJobs(ctx, client, []*channels.Channels{ch}, []SchedulableService{service})
+	})
+	return true
+}
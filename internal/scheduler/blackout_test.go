@@ -0,0 +1,150 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/blackout"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/history"
+	"github.com/go-co-op/gocron"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMaintenance struct {
+	mode blackout.Mode
+	err  error
+}
+
+func (f fakeMaintenance) Current(ctx context.Context) (blackout.Mode, error) {
+	return f.mode, f.err
+}
+
+// window returns a one-off window for service that is open from now until end.
+func window(service string, end time.Time) blackout.Window {
+	return blackout.Window{Service: service, Spec: "test", From: time.Now().Add(-time.Hour), To: end}
+}
+
+func (f *fakeService) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.callCount
+}
+
+func (f *fakeHistory) statuses() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, run := range f.runs {
+		out = append(out, run.Status)
+	}
+	return out
+}
+
+func TestParseBlackoutPolicy(t *testing.T) {
+	p, err := ParseBlackoutPolicy("skip")
+	require.NoError(t, err)
+	assert.Equal(t, BlackoutSkip, p)
+	_, err = ParseBlackoutPolicy("later")
+	assert.Error(t, err)
+}
+
+func TestRun_BlackoutSkip(t *testing.T) {
+	aqi := &fakeService{name: "aqi_db", mu: &sync.Mutex{}}
+	weather := &fakeService{name: "weather_db", mu: &sync.Mutex{}}
+	hist := &fakeHistory{}
+	s := &Scheduler{
+		Cron:           gocron.NewScheduler(time.UTC),
+		WG:             &sync.WaitGroup{},
+		History:        hist,
+		Blackouts:      blackout.Windows{window("aqi_db", time.Now().Add(time.Hour))},
+		BlackoutPolicy: BlackoutSkip,
+	}
+
+	result := s.RunImmediateJob(context.Background(), nil,
+		[]*channels.Channels{channels.New(), channels.New()},
+		[]SchedulableService{aqi, weather})
+
+	assert.Equal(t, 0, aqi.calls())
+	assert.Equal(t, 1, weather.calls())
+	assert.Equal(t, StatusSuccess, result.Status)
+	require.Len(t, result.Services, 2)
+	assert.Equal(t, StatusSkipped, result.Services[0].Status)
+	assert.Equal(t, "blackout aqi_db=test, skipped", result.Services[0].Reason)
+	assert.ElementsMatch(t, []string{history.StatusSkipped, history.StatusCompleted}, hist.statuses())
+
+	// The skip is visible as the service's last run
+	s.mu.Lock()
+	last := s.status["aqi_db"].last
+	s.mu.Unlock()
+	require.NotNil(t, last)
+	assert.Equal(t, StatusSkipped, last.Status)
+}
+
+func TestRun_BlackoutDefer(t *testing.T) {
+	aqi := &fakeService{name: "aqi_db", mu: &sync.Mutex{}}
+	hist := &fakeHistory{}
+	s := &Scheduler{
+		Cron:      gocron.NewScheduler(time.UTC),
+		WG:        &sync.WaitGroup{},
+		History:   hist,
+		Blackouts: blackout.Windows{window("*", time.Now().Add(100*time.Millisecond))},
+	}
+	chanList := []*channels.Channels{channels.New()}
+
+	result := s.RunImmediateJob(context.Background(), nil, chanList, []SchedulableService{aqi})
+	require.Len(t, result.Services, 1)
+	assert.Contains(t, result.Services[0].Reason, "deferred to")
+	assert.Equal(t, 0, aqi.calls())
+
+	// A second run during the window does not pile up another deferred run
+	result = s.RunImmediateJob(context.Background(), nil, chanList, []SchedulableService{aqi})
+	assert.Contains(t, result.Services[0].Reason, "already pending")
+
+	require.Eventually(t, func() bool { return aqi.calls() == 1 }, 2*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return len(hist.statuses()) == 3 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{history.StatusDeferred, history.StatusDeferred, history.StatusCompleted}, hist.statuses())
+}
+
+func TestRun_Maintenance(t *testing.T) {
+	weather := &fakeService{name: "weather_db", mu: &sync.Mutex{}}
+	s := &Scheduler{
+		Cron:           gocron.NewScheduler(time.UTC),
+		WG:             &sync.WaitGroup{},
+		BlackoutPolicy: BlackoutSkip,
+		Maintenance:    fakeMaintenance{mode: blackout.Mode{Enabled: true, Reason: "reindexing"}},
+	}
+	chanList := []*channels.Channels{channels.New()}
+
+	result := s.RunImmediateJob(context.Background(), nil, chanList, []SchedulableService{weather})
+	assert.Equal(t, 0, weather.calls())
+	assert.Equal(t, "maintenance: reindexing, skipped", result.Services[0].Reason)
+
+	// Expired maintenance no longer holds runs back
+	s.Maintenance = fakeMaintenance{mode: blackout.Mode{Enabled: true, Until: time.Now().Add(-time.Minute)}}
+	s.RunImmediateJob(context.Background(), nil, chanList, []SchedulableService{weather})
+	assert.Equal(t, 1, weather.calls())
+
+	// Nor does a mode that cannot be read
+	s.Maintenance = fakeMaintenance{err: fmt.Errorf("mongo down")}
+	s.RunImmediateJob(context.Background(), nil, chanList, []SchedulableService{weather})
+	assert.Equal(t, 2, weather.calls())
+}
+
+func TestStop_CancelsDeferredRuns(t *testing.T) {
+	aqi := &fakeService{name: "aqi_db", mu: &sync.Mutex{}}
+	s := &Scheduler{
+		Cron:      gocron.NewScheduler(time.UTC),
+		WG:        &sync.WaitGroup{},
+		Blackouts: blackout.Windows{window("aqi_db", time.Now().Add(50*time.Millisecond))},
+	}
+
+	s.RunImmediateJob(context.Background(), nil, []*channels.Channels{channels.New()}, []SchedulableService{aqi})
+	s.Stop()
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, 0, aqi.calls())
+}
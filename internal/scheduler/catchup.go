@@ -0,0 +1,187 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/history"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/metrics"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// historyTimeout bounds writes to the run history.
+const historyTimeout = 10 * time.Second
+
+// History stores finished batches and reports the last successful one.
+type History interface {
+	Record(ctx context.Context, run history.Run) error
+	LastSuccess(ctx context.Context, service string) (time.Time, error)
+}
+
+// CatchUpPolicy decides what happens on startup to scheduled runs that were
+// missed while the process was down.
+type CatchUpPolicy string
+
+const (
+	// CatchUpSkip waits for the next scheduled run.
+	CatchUpSkip CatchUpPolicy = "skip"
+	// CatchUpOnce runs a service once if it missed any scheduled runs.
+	CatchUpOnce CatchUpPolicy = "run-once"
+	// CatchUpAll runs a service once per missed scheduled run, up to MaxCatchUp.
+	CatchUpAll CatchUpPolicy = "run-all-missed"
+)
+
+func ParseCatchUpPolicy(v string) (CatchUpPolicy, error) {
+	switch p := CatchUpPolicy(v); p {
+	case CatchUpSkip, CatchUpOnce, CatchUpAll:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown catch-up policy %q (want %s, %s or %s)", v, CatchUpSkip, CatchUpOnce, CatchUpAll)
+}
+
+// CatchUp runs the services whose last successful batch predates the most
+// recent scheduled run, as allowed by Policy. Without History every service
+// counts as having missed one run. Missed runs are made one round after the
+// other. Once StartJob has been called, time-zone buckets are caught up on
+// their own.
+func (s *Scheduler) CatchUp(ctx context.Context, client *mongo.Client, chanList []*channels.Channels, services []SchedulableService) {
+	s.mu.Lock()
+	entries := s.entries
+	s.mu.Unlock()
+	if len(entries) == 0 {
+		for i, service := range services {
+			entries = append(entries, entry{service: service, ch: chanList[i], loc: s.Cron.Location()})
+		}
+	}
+
+	now := time.Now()
+	counts := make([]int, len(entries))
+	rounds := 0
+	for i, e := range entries {
+		counts[i] = s.catchUpRuns(ctx, e.service.Name(), e.loc, now)
+		if counts[i] > rounds {
+			rounds = counts[i]
+		}
+	}
+
+	if rounds == 0 {
+		logger.Info("All services are up to date, waiting for the next scheduled run.")
+		return
+	}
+
+	for round := 0; round < rounds; round++ {
+		var chans []*channels.Channels
+		var due []SchedulableService
+		for i, e := range entries {
+			if counts[i] > round {
+				chans = append(chans, e.ch)
+				due = append(due, e.service)
+			}
+		}
+		logger.Info("--- Catch-up run %d of %d (%d jobs) ---", round+1, rounds, len(due))
+		s.runAllJobs(ctx, client, chans, due)
+		if ctx.Err() != nil || s.stopping() {
+			return
+		}
+	}
+}
+
+// catchUpRuns returns how many runs service needs to catch up on.
+func (s *Scheduler) catchUpRuns(ctx context.Context, service string, loc *time.Location, now time.Time) int {
+	conf := s.Settings()
+	if conf.Policy == CatchUpSkip {
+		return 0
+	}
+
+	missed := 1
+	if s.History != nil {
+		last, err := s.History.LastSuccess(ctx, service)
+		if err != nil {
+			logger.Error("[%s] Failed to read run history, catching up anyway: %v", service, err)
+		} else {
+			s.seenSuccess(service, last)
+			missed = s.missedRuns(last, now, loc)
+			if !last.IsZero() {
+				logger.Info("[%s] Last successful run started %s, %d scheduled runs missed.", service, last.Format(time.RFC3339), missed)
+			}
+		}
+	}
+
+	switch {
+	case missed == 0:
+		return 0
+	case conf.Policy == CatchUpAll:
+		max := conf.MaxCatchUp
+		if max < 1 {
+			max = 1
+		}
+		if missed > max {
+			logger.Info("[%s] Catching up on the last %d of %d missed runs.", service, max, missed)
+			return max
+		}
+		return missed
+	default:
+		return 1
+	}
+}
+
+// missedRuns counts the runs scheduled at At in loc during (last, now]. A
+// service that never ran has missed one.
+func (s *Scheduler) missedRuns(last, now time.Time, loc *time.Location) int {
+	if last.IsZero() {
+		return 1
+	}
+
+	at, err := time.Parse("15:04", s.at())
+	if err != nil {
+		logger.Error("Invalid schedule time %q: %v", s.at(), err)
+		return 1
+	}
+
+	last = last.In(loc)
+	next := time.Date(last.Year(), last.Month(), last.Day(), at.Hour(), at.Minute(), 0, 0, loc)
+	if !next.After(last) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	missed := 0
+	for ; !next.After(now); next = next.AddDate(0, 0, 1) {
+		missed++
+	}
+	return missed
+}
+
+// record counts a batch in the metrics and stores it in the history, if
+// there is one, with status completed, interrupted, skipped or deferred.
+func (s *Scheduler) record(service string, summary RunSummary, status string) {
+	metrics.Runs.WithLabelValues(service, status, string(summary.Status)).Inc()
+	if status == history.StatusCompleted || status == history.StatusInterrupted {
+		metrics.RunDuration.WithLabelValues(service).Observe(summary.FinishedAt.Sub(summary.StartedAt).Seconds())
+	}
+	if s.History == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), historyTimeout)
+	defer cancel()
+	err := s.History.Record(ctx, history.Run{
+		Service:    service,
+		RunID:      summary.RunID,
+		Status:     status,
+		Result:     string(summary.Status),
+		Error:      summary.Error,
+		Reason:     summary.Reason,
+		StartedAt:  summary.StartedAt,
+		FinishedAt: summary.FinishedAt,
+		Done:       summary.Done,
+		Failed:     summary.Failed,
+		Retried:    summary.Retried,
+		Recovered:  summary.Recovered,
+	})
+	if err != nil {
+		logger.Error("[%s] Failed to record run %s: %v", service, summary.RunID, err)
+	}
+}
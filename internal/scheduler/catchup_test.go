@@ -0,0 +1,130 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/history"
+	"github.com/go-co-op/gocron"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHistory serves fixed last-success times and records runs.
+type fakeHistory struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	err  error
+	runs []history.Run
+}
+
+func (f *fakeHistory) Record(ctx context.Context, run history.Run) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.runs = append(f.runs, run)
+	return nil
+}
+
+func (f *fakeHistory) LastSuccess(ctx context.Context, service string) (time.Time, error) {
+	return f.last[service], f.err
+}
+
+func TestMissedRuns(t *testing.T) {
+	day := func(d, h, m int) time.Time { return time.Date(2025, 3, d, h, m, 0, 0, time.UTC) }
+	now := day(10, 9, 0)
+
+	tests := []struct {
+		name string
+		last time.Time
+		want int
+	}{
+		{"never ran", time.Time{}, 1},
+		{"ran at today's slot", day(10, 7, 30), 0},
+		{"ran after today's slot", day(10, 8, 0), 0},
+		{"ran yesterday", day(9, 7, 30), 1},
+		{"ran before yesterday's slot", day(9, 6, 0), 2},
+		{"down for a week", day(3, 7, 30), 7},
+	}
+
+	s := &Scheduler{Cron: gocron.NewScheduler(time.UTC)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, s.missedRuns(tt.last, now, time.UTC))
+		})
+	}
+}
+
+func TestCatchUp_Policies(t *testing.T) {
+	recent := time.Now()
+	old := time.Now().AddDate(0, 0, -5)
+
+	tests := []struct {
+		name      string
+		policy    CatchUpPolicy
+		max       int
+		histErr   error
+		wantCalls map[string]int
+	}{
+		{"skip", CatchUpSkip, 0, nil, map[string]int{"fresh": 0, "stale": 0, "new": 0}},
+		{"run once", CatchUpOnce, 0, nil, map[string]int{"fresh": 0, "stale": 1, "new": 1}},
+		{"run all missed", CatchUpAll, 10, nil, map[string]int{"fresh": 0, "stale": 5, "new": 1}},
+		{"run all missed capped", CatchUpAll, 3, nil, map[string]int{"fresh": 0, "stale": 3, "new": 1}},
+		{"history unavailable", CatchUpOnce, 0, errors.New("mongo down"), map[string]int{"fresh": 1, "stale": 1, "new": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu := &sync.Mutex{}
+			fakes := []*fakeService{{name: "fresh", mu: mu}, {name: "stale", mu: mu}, {name: "new", mu: mu}}
+			services := make([]SchedulableService, len(fakes))
+			chanList := make([]*channels.Channels, len(fakes))
+			for i, f := range fakes {
+				services[i] = f
+				chanList[i] = channels.New()
+			}
+
+			hist := &fakeHistory{last: map[string]time.Time{"fresh": recent, "stale": old}, err: tt.histErr}
+			s := &Scheduler{
+				Cron:       gocron.NewScheduler(time.UTC),
+				WG:         &sync.WaitGroup{},
+				At:         recent.UTC().Add(-time.Minute).Format("15:04"),
+				History:    hist,
+				Policy:     tt.policy,
+				MaxCatchUp: tt.max,
+			}
+			s.CatchUp(context.Background(), nil, chanList, services)
+
+			for _, f := range fakes {
+				assert.Equal(t, tt.wantCalls[f.name], f.callCount, f.name)
+			}
+		})
+	}
+}
+
+func TestRun_RecordsHistory(t *testing.T) {
+	hist := &fakeHistory{}
+	s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}, History: hist}
+	svc := &fakeService{name: "weather", mu: &sync.Mutex{}}
+
+	s.RunImmediateJob(context.Background(), nil, []*channels.Channels{channels.New()}, []SchedulableService{svc})
+
+	require.Len(t, hist.runs, 1)
+	run := hist.runs[0]
+	assert.Equal(t, "weather", run.Service)
+	assert.Equal(t, history.StatusCompleted, run.Status)
+	assert.Equal(t, 1, run.Done)
+	assert.False(t, run.StartedAt.IsZero())
+}
+
+func TestParseCatchUpPolicy(t *testing.T) {
+	p, err := ParseCatchUpPolicy("run-all-missed")
+	require.NoError(t, err)
+	assert.Equal(t, CatchUpAll, p)
+
+	_, err = ParseCatchUpPolicy("always")
+	assert.Error(t, err)
+}
@@ -0,0 +1,376 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/history"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrNotStarted     = errors.New("scheduler: not started")
+	ErrUnknownService = errors.New("scheduler: unknown service")
+	ErrPaused         = errors.New("scheduler: service is paused")
+	ErrRunning        = errors.New("scheduler: batch already running")
+	ErrNoSingleID     = errors.New("scheduler: service cannot run a single ID")
+	ErrStopped        = errors.New("scheduler: shutting down")
+	ErrBlackedOut     = errors.New("scheduler: service is in a blackout window or maintenance")
+)
+
+// RequestBuilder is implemented by services that can build the request for a
+// single fetch param, which lets a single ID be triggered on its own.
+type RequestBuilder interface {
+	NewRequest(client interface{}, id string) models.DataRequest
+}
+
+// JobInfo describes a service's scheduled batch.
+type JobInfo struct {
+	Service string      `json:"service"`
+	Paused  bool        `json:"paused"`
+	Running bool        `json:"running"`
+	NextRun time.Time   `json:"next_run"`
+	LastRun *RunSummary `json:"last_run,omitempty"`
+	// LastSuccess is when the last completed batch that did not fail
+	// started, as the run history counts it
+	LastSuccess time.Time    `json:"last_success,omitzero"`
+	Current     *RunProgress `json:"current,omitempty"`
+}
+
+// RunSummary is the outcome of a finished batch.
+type RunSummary struct {
+	Service string    `json:"service"`
+	RunID   string    `json:"run_id"`
+	Status  RunStatus `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	// Reason says why a skipped run did not go ahead.
+	Reason     string    `json:"reason,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Done       int       `json:"done"`
+	Failed     int       `json:"failed"`
+	// Retried counts resubmissions of failed requests, Recovered those of
+	// them that succeeded in the end. Done and Failed count each request once.
+	Retried   int `json:"retried"`
+	Recovered int `json:"recovered"`
+}
+
+// RunProgress is a snapshot of a batch in progress.
+type RunProgress struct {
+	Service   string    `json:"service"`
+	RunID     string    `json:"run_id"`
+	StartedAt time.Time `json:"started_at"`
+	Queued    int       `json:"queued"`
+	InFlight  int       `json:"in_flight"`
+	Done      int       `json:"done"`
+	Failed    int       `json:"failed"`
+}
+
+type serviceStatus struct {
+	paused      bool
+	last        *RunSummary
+	lastSuccess time.Time
+	runID       string
+	started     time.Time
+	ch          *channels.Channels
+}
+
+// Jobs lists every scheduled job with its last and next run, sorted by name.
+// A service scheduled at local time is listed once per time zone, as
+// "<service>@<zone>", plus once for its params without a known zone.
+func (s *Scheduler) Jobs() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]JobInfo, 0, len(s.entries))
+	for _, e := range s.entries {
+		name := e.service.Name()
+		info := JobInfo{Service: name}
+		if e.job != nil {
+			info.NextRun = e.job.NextRun()
+		}
+		if st := s.status[name]; st != nil {
+			info.Paused = st.paused
+			info.Running = st.ch != nil
+			info.LastSuccess = st.lastSuccess
+			if st.last != nil {
+				last := *st.last
+				info.LastRun = &last
+			}
+			if st.ch != nil {
+				p := progress(name, st)
+				info.Current = &p
+			}
+		}
+		jobs = append(jobs, info)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Service < jobs[j].Service })
+	return jobs
+}
+
+// Active reports whether StartJob has scheduled the jobs and Shutdown has
+// not been called since.
+func (s *Scheduler) Active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ctx != nil && !s.stopped
+}
+
+// seenSuccess records a successful batch of service found in the run
+// history, unless a later one is already known.
+func (s *Scheduler) seenSuccess(service string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st := s.statusFor(service); at.After(st.lastSuccess) {
+		st.lastSuccess = at
+	}
+}
+
+// Progress returns the batches currently running in this process.
+func (s *Scheduler) Progress() []RunProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []RunProgress
+	for name, st := range s.status {
+		if st.ch != nil {
+			out = append(out, progress(name, st))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out
+}
+
+// Trigger starts a batch for service straight away and returns its run ID.
+// The batch runs in the background.
+func (s *Scheduler) Trigger(service string) (string, error) {
+	ctx, svc, ch, err := s.lookup(service)
+	if err != nil {
+		return "", err
+	}
+	if s.stopping() {
+		return "", ErrStopped
+	}
+	if s.paused(service) || s.paused(baseName(svc)) {
+		return "", ErrPaused
+	}
+	if _, busy := s.running.Load(service); busy {
+		return "", ErrRunning
+	}
+
+	runID := primitive.NewObjectID().Hex()
+	logger.Info("[%s] Batch triggered manually (run %s).", service, runID)
+	go s.run(ctx, runID, s.mongoClient(), []*channels.Channels{ch}, []SchedulableService{svc})
+	return runID, nil
+}
+
+// TriggerID fetches and stores a single ID of service straight away and
+// returns the run ID it was submitted under. Unlike a batch, it is refused
+// rather than deferred while the service is blacked out.
+func (s *Scheduler) TriggerID(service, id string) (string, error) {
+	ctx, svc, ch, err := s.lookup(service)
+	if err != nil {
+		return "", err
+	}
+	if s.stopping() {
+		return "", ErrStopped
+	}
+	if s.paused(service) || s.paused(baseName(svc)) {
+		return "", ErrPaused
+	}
+	if reason, _, held := s.blackedOut(s.maintenance(ctx), time.Now(), service, baseName(svc)); held {
+		return "", fmt.Errorf("%w: %s", ErrBlackedOut, reason)
+	}
+	builder, ok := svc.(RequestBuilder)
+	if !ok {
+		return "", ErrNoSingleID
+	}
+
+	req := builder.NewRequest(s.mongoClient(), id)
+	req.RunID = primitive.NewObjectID().Hex()
+	job, err := ch.Submit(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to submit %s: %w", id, err)
+	}
+	logger.Info("[%s] Request for %s triggered manually (run %s).", service, id, req.RunID)
+	go func() {
+		// Nobody waits for the run, so drop its bookkeeping once it is done
+		select {
+		case <-job.Done():
+			ch.Forget(req.RunID)
+		case <-ctx.Done():
+		}
+	}()
+	return req.RunID, nil
+}
+
+// Admit lets requests of service resumed from the durable queue run under
+// the rules of its batches: not while it is paused, blacked out or already
+// running, and only for the params of this instance's share. The returned
+// context carries the share and the batch lease; release must be called
+// once the requests have finished.
+func (s *Scheduler) Admit(ctx context.Context, service string) (context.Context, func(), error) {
+	if s.stopping() {
+		return nil, nil, ErrStopped
+	}
+	if s.paused(service) {
+		return nil, nil, ErrPaused
+	}
+	if reason, _, held := s.blackedOut(s.maintenance(ctx), time.Now(), service); held {
+		return nil, nil, fmt.Errorf("%w: %s", ErrBlackedOut, reason)
+	}
+	if s.Cluster != nil {
+		p, err := s.Cluster.Partition(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to determine this instance's partition: %w", err)
+		}
+		ctx = cluster.WithPartition(ctx, p)
+	}
+	ctx, release, ok := s.claim(ctx, service)
+	if !ok {
+		return nil, nil, ErrRunning
+	}
+	return ctx, release, nil
+}
+
+// Pause makes scheduled runs skip service, and refuses manual triggers, until
+// Resume. A batch already in progress is left to finish.
+func (s *Scheduler) Pause(service string) error {
+	return s.setPaused(service, true)
+}
+
+// Resume undoes Pause.
+func (s *Scheduler) Resume(service string) error {
+	return s.setPaused(service, false)
+}
+
+func (s *Scheduler) setPaused(service string, paused bool) error {
+	if _, _, _, err := s.lookup(service); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.statusFor(service).paused = paused
+	s.mu.Unlock()
+
+	if paused {
+		logger.Info("[%s] Paused.", service)
+	} else {
+		logger.Info("[%s] Resumed.", service)
+	}
+	return nil
+}
+
+// lookup finds a registered service for a manual action.
+func (s *Scheduler) lookup(service string) (context.Context, SchedulableService, *channels.Channels, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx == nil {
+		return nil, nil, nil, ErrNotStarted
+	}
+	for i, svc := range s.services {
+		if svc.Name() == service {
+			return s.ctx, svc, s.chanList[i], nil
+		}
+	}
+	// Time-zone buckets can be driven on their own too
+	for _, e := range s.entries {
+		if e.service.Name() == service {
+			return s.ctx, e.service, e.ch, nil
+		}
+	}
+	return nil, nil, nil, ErrUnknownService
+}
+
+func (s *Scheduler) mongoClient() *mongo.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client
+}
+
+func (s *Scheduler) paused(service string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status[service]
+	return st != nil && st.paused
+}
+
+func (s *Scheduler) started(service, runID string, ch *channels.Channels) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.statusFor(service)
+	st.runID, st.started, st.ch = runID, time.Now(), ch
+}
+
+func (s *Scheduler) finished(b *batch, runID string, completed bool) RunSummary {
+	summary := b.summary(runID)
+	summary.Service = b.name
+	summary.FinishedAt = time.Now()
+	summary.Status = s.Settings().Thresholds.Status(summary.Done, summary.Failed, completed && b.err == nil)
+	shutdown := !completed && s.stopping()
+	switch {
+	case b.err != nil:
+		summary.Error = b.err.Error()
+	case shutdown:
+		summary.Error = "interrupted by shutdown"
+	case !completed:
+		summary.Error = "interrupted"
+	}
+
+	s.mu.Lock()
+	st := s.statusFor(b.name)
+	summary.StartedAt = st.started
+	last := summary
+	st.last = &last
+	if completed && summary.Status != StatusFailed {
+		st.lastSuccess = summary.StartedAt
+	}
+	st.runID, st.ch = "", nil
+	s.mu.Unlock()
+
+	status := history.StatusCompleted
+	if !completed {
+		status = history.StatusInterrupted
+	}
+	s.record(b.name, summary, status)
+	// A deploy or restart is not worth an alert; catch-up reruns the batch
+	if !shutdown {
+		s.alert(summary)
+	}
+	return summary
+}
+
+// statusFor must be called with s.mu held.
+func (s *Scheduler) statusFor(service string) *serviceStatus {
+	if s.status == nil {
+		s.status = make(map[string]*serviceStatus)
+	}
+	st, ok := s.status[service]
+	if !ok {
+		st = &serviceStatus{}
+		s.status[service] = st
+	}
+	return st
+}
+
+// progress must be called with s.mu held.
+func progress(service string, st *serviceStatus) RunProgress {
+	stats := st.ch.Stats(st.runID)
+	return RunProgress{
+		Service:   service,
+		RunID:     st.runID,
+		StartedAt: st.started,
+		Queued:    stats.Queued,
+		InFlight:  stats.InFlight,
+		Done:      stats.Done,
+		Failed:    stats.Failed,
+	}
+}
@@ -0,0 +1,226 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/blackout"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"github.com/go-co-op/gocron"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// builderService can also build requests for single IDs.
+type builderService struct {
+	fakeService
+}
+
+func (b *builderService) NewRequest(client interface{}, id string) models.DataRequest {
+	return models.DataRequest{ID: id, Service: b.name}
+}
+
+func startedScheduler(t *testing.T, services []SchedulableService) (*Scheduler, []*channels.Channels) {
+	t.Helper()
+	chanList := make([]*channels.Channels, len(services))
+	for i := range chanList {
+		chanList[i] = channels.New()
+	}
+	s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}}
+	require.NoError(t, s.StartJob(context.Background(), nil, chanList, services))
+	t.Cleanup(s.Cron.Stop)
+	return s, chanList
+}
+
+func TestScheduler_NotStarted(t *testing.T) {
+	s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}}
+
+	_, err := s.Trigger("weather")
+	assert.ErrorIs(t, err, ErrNotStarted)
+}
+
+func TestScheduler_TriggerAndJobs(t *testing.T) {
+	mu := &sync.Mutex{}
+	weather := &fakeService{name: "weather", mu: mu}
+	s, _ := startedScheduler(t, []SchedulableService{weather})
+
+	_, err := s.Trigger("unknown")
+	assert.ErrorIs(t, err, ErrUnknownService)
+
+	runID, err := s.Trigger("weather")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		jobs := s.Jobs()
+		return len(jobs) == 1 && jobs[0].LastRun != nil
+	}, time.Second, 5*time.Millisecond)
+
+	job := s.Jobs()[0]
+	assert.Equal(t, "weather", job.Service)
+	assert.False(t, job.NextRun.IsZero())
+	assert.Equal(t, runID, job.LastRun.RunID)
+	assert.Equal(t, 1, job.LastRun.Done)
+	assert.Equal(t, job.LastRun.StartedAt, job.LastSuccess)
+	assert.Empty(t, s.Progress())
+	assert.True(t, s.Active())
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.False(t, s.Active())
+}
+
+func TestScheduler_PauseResume(t *testing.T) {
+	mu := &sync.Mutex{}
+	weather := &fakeService{name: "weather", mu: mu}
+	s, chanList := startedScheduler(t, []SchedulableService{weather})
+
+	require.NoError(t, s.Pause("weather"))
+	assert.True(t, s.Jobs()[0].Paused)
+
+	_, err := s.Trigger("weather")
+	assert.ErrorIs(t, err, ErrPaused)
+
+	// Scheduled runs skip the paused service.
+	s.RunImmediateJob(context.Background(), nil, chanList, []SchedulableService{weather})
+	assert.Equal(t, 0, weather.callCount)
+
+	require.NoError(t, s.Resume("weather"))
+	s.RunImmediateJob(context.Background(), nil, chanList, []SchedulableService{weather})
+	assert.Equal(t, 1, weather.callCount)
+
+	assert.ErrorIs(t, s.Pause("unknown"), ErrUnknownService)
+}
+
+func TestScheduler_Progress(t *testing.T) {
+	svc := &blockingService{name: "slow", started: make(chan struct{}), release: make(chan struct{})}
+	s, _ := startedScheduler(t, []SchedulableService{svc})
+
+	runID, err := s.Trigger("slow")
+	require.NoError(t, err)
+	<-svc.started
+
+	progress := s.Progress()
+	require.Len(t, progress, 1)
+	assert.Equal(t, runID, progress[0].RunID)
+	assert.True(t, s.Jobs()[0].Running)
+
+	_, err = s.Trigger("slow")
+	assert.ErrorIs(t, err, ErrRunning)
+
+	close(svc.release)
+	require.Eventually(t, func() bool { return len(s.Progress()) == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestScheduler_TriggerID(t *testing.T) {
+	weather := &builderService{fakeService{name: "weather"}}
+	plain := &fakeService{name: "plain"}
+	s, chanList := startedScheduler(t, []SchedulableService{weather, plain})
+
+	runID, err := s.TriggerID("weather", "Kabul")
+	require.NoError(t, err)
+
+	req := <-chanList[0].DataRequest
+	assert.Equal(t, "Kabul", req.ID)
+	assert.Equal(t, runID, req.RunID)
+
+	// The run is forgotten once its request is done
+	req = chanList[0].Start(req)
+	assert.Equal(t, 1, chanList[0].Stats(runID).InFlight)
+	chanList[0].Finish(req, nil)
+	require.Eventually(t, func() bool { return chanList[0].Stats(runID) == channels.Stats{} }, time.Second, 5*time.Millisecond)
+
+	_, err = s.TriggerID("plain", "Kabul")
+	assert.ErrorIs(t, err, ErrNoSingleID)
+
+	s.Maintenance = fakeMaintenance{mode: blackout.Mode{Enabled: true, Reason: "reindexing"}}
+	_, err = s.TriggerID("weather", "Kabul")
+	assert.ErrorIs(t, err, ErrBlackedOut)
+	assert.ErrorContains(t, err, "reindexing")
+}
+
+func TestScheduler_Admit(t *testing.T) {
+	s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}}
+	s.Cluster = fixedPartitioner{p: cluster.NewPartition("self", []string{"self"})}
+
+	ctx, release, err := s.Admit(context.Background(), "weather")
+	require.NoError(t, err)
+	assert.True(t, cluster.Owns(ctx, "weather", "Kabul"))
+
+	// Resumed requests count as the service's batch until released
+	_, _, err = s.Admit(context.Background(), "weather")
+	assert.ErrorIs(t, err, ErrRunning)
+	release()
+	_, release, err = s.Admit(context.Background(), "weather")
+	require.NoError(t, err)
+	release()
+
+	s.Cluster = fixedPartitioner{p: cluster.NewPartition("self", []string{"other"})}
+	ctx, release, err = s.Admit(context.Background(), "weather")
+	require.NoError(t, err)
+	assert.False(t, cluster.Owns(ctx, "weather", "Kabul"))
+	release()
+
+	s.Maintenance = fakeMaintenance{mode: blackout.Mode{Enabled: true, Reason: "reindexing"}}
+	_, _, err = s.Admit(context.Background(), "weather")
+	assert.ErrorIs(t, err, ErrBlackedOut)
+	s.Maintenance = nil
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	_, _, err = s.Admit(context.Background(), "weather")
+	assert.ErrorIs(t, err, ErrStopped)
+}
+
+// queuedService submits one request that no worker ever picks up.
+type queuedService struct {
+	submitted chan struct{}
+}
+
+func (q *queuedService) Name() string { return "queued" }
+
+func (q *queuedService) RunBatchJob(ctx context.Context, client interface{}, chans *channels.Channels) error {
+	_, err := chans.Submit(ctx, models.DataRequest{ID: "Kabul", Service: "queued"})
+	close(q.submitted)
+	return err
+}
+
+func TestScheduler_Shutdown(t *testing.T) {
+	svc := &queuedService{submitted: make(chan struct{})}
+	s, chanList := startedScheduler(t, []SchedulableService{svc})
+	alerter := &recordingAlerter{}
+	s.Alerter = alerter
+
+	results := make(chan RunResult, 1)
+	go func() {
+		results <- s.RunImmediateJob(context.Background(), nil, chanList, []SchedulableService{svc})
+	}()
+	<-svc.submitted
+
+	// The run is waiting on its request; Shutdown stops it rather than the drain
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+
+	result := <-results
+	assert.Equal(t, StatusFailed, result.Status)
+	require.Len(t, result.Services, 1)
+	assert.Equal(t, "interrupted by shutdown", result.Services[0].Error)
+	assert.Empty(t, alerter.alerts)
+	// The request itself is still queued for the pools to drain
+	assert.Equal(t, 1, chanList[0].Stats("").Queued)
+
+	_, err := s.Trigger("queued")
+	assert.ErrorIs(t, err, ErrStopped)
+	_, err = s.TriggerID("queued", "Kabul")
+	assert.ErrorIs(t, err, ErrStopped)
+
+	// Later runs do not start at all
+	result = s.RunImmediateJob(context.Background(), nil, chanList, []SchedulableService{svc})
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Empty(t, result.Services)
+
+	// Shutting down again is harmless
+	assert.NoError(t, s.Shutdown(ctx))
+}
@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// rebalance runs the params this instance took over from instances that
+// dropped out of the cluster since p was taken, as they may have died
+// before submitting them. It checks again after each pass and once more
+// after Settle, since an instance that died late in the run is only seen
+// gone once its heartbeat is overdue. Params of instances that drop out
+// later move from the next run on.
+func (s *Scheduler) rebalance(ctx context.Context, runID string, client *mongo.Client, p *cluster.Partition, batches []*batch) error {
+	settled := s.Settle <= 0
+	for {
+		next, err := s.Cluster.Partition(ctx)
+		if err != nil {
+			logger.Error("Failed to check run %s for instances that left the cluster: %v", runID, err)
+			return nil
+		}
+		gone := next.Departed(p)
+		if len(gone) == 0 {
+			if settled {
+				return nil
+			}
+			settled = true
+			timer := time.NewTimer(s.Settle)
+			select {
+			case <-ctx.Done():
+				// Shutting down, with this instance's own share done
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+			continue
+		}
+
+		logger.Info("Run %s: %s left the cluster, running the params %s took over.", runID, strings.Join(gone, ", "), next.Self)
+		gained := next.Since(p)
+		for _, b := range batches {
+			if err := b.service.RunBatchJob(cluster.WithPartition(b.ctx, gained), client, b.ch); err != nil {
+				logger.From(b.ctx).Error("Error running taken over params for service: %v", err)
+				if b.err == nil {
+					b.err = err
+				}
+			}
+		}
+		for _, b := range batches {
+			if err := b.ch.Wait(ctx, runID); err != nil {
+				return err
+			}
+		}
+		if err := s.retryFailed(ctx, runID, batches); err != nil {
+			return err
+		}
+		p = next
+	}
+}
@@ -0,0 +1,98 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
+	"github.com/go-co-op/gocron"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keysService records the keys each of its runs owns.
+type keysService struct {
+	keys  []string
+	owned [][]string
+}
+
+func (k *keysService) Name() string { return "keys" }
+
+func (k *keysService) RunBatchJob(ctx context.Context, client interface{}, chans *channels.Channels) error {
+	var owned []string
+	for _, key := range k.keys {
+		if cluster.Owns(ctx, "keys", key) {
+			owned = append(owned, key)
+		}
+	}
+	k.owned = append(k.owned, owned)
+	return nil
+}
+
+// seqPartitioner returns its partitions in turn, then the last one for good.
+type seqPartitioner struct {
+	mu    sync.Mutex
+	parts []*cluster.Partition
+	calls int
+}
+
+func (s *seqPartitioner) Partition(ctx context.Context) (*cluster.Partition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if len(s.parts) > 1 {
+		p := s.parts[0]
+		s.parts = s.parts[1:]
+		return p, nil
+	}
+	return s.parts[0], nil
+}
+
+func TestRun_Rebalance(t *testing.T) {
+	var keys []string
+	for i := 0; i < 50; i++ {
+		keys = append(keys, fmt.Sprintf("city-%d", i))
+	}
+	both := cluster.NewPartition("a", []string{"a", "b"})
+	alone := cluster.NewPartition("a", []string{"a"})
+	var fromB []string
+	for _, key := range keys {
+		if !both.Owns("keys/" + key) {
+			fromB = append(fromB, key)
+		}
+	}
+	require.NotEmpty(t, fromB)
+
+	tests := []struct {
+		name   string
+		parts  []*cluster.Partition
+		settle time.Duration
+		runs   int
+	}{
+		{"stable", []*cluster.Partition{both}, 0, 1},
+		{"b left during the run", []*cluster.Partition{both, alone}, 0, 2},
+		// Only seen gone once the run has settled
+		{"b left at the end", []*cluster.Partition{both, both, alone}, 10 * time.Millisecond, 2},
+		{"b left too late", []*cluster.Partition{both, both, alone}, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &keysService{keys: keys}
+			s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}}
+			s.Cluster = &seqPartitioner{parts: tt.parts}
+			s.Settle = tt.settle
+
+			result := s.runAllJobs(context.Background(), nil, []*channels.Channels{channels.New()}, []SchedulableService{svc})
+			assert.Equal(t, StatusSuccess, result.Status)
+			require.Len(t, svc.owned, tt.runs)
+			assert.Len(t, svc.owned[0], len(keys)-len(fromB))
+			if tt.runs > 1 {
+				assert.Equal(t, fromB, svc.owned[1], "only the params b held should run again")
+			}
+		})
+	}
+}
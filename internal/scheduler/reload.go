@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/blackout"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/go-co-op/gocron"
+)
+
+// Settings are the scheduler fields that can be changed while it runs,
+// through Update. Before StartJob they may be set directly.
+type Settings struct {
+	At             string
+	Policy         CatchUpPolicy
+	MaxCatchUp     int
+	RetryPasses    int
+	RetryCooldown  time.Duration
+	Thresholds     Thresholds
+	AlertOn        RunStatus
+	Blackouts      blackout.Windows
+	BlackoutPolicy BlackoutPolicy
+}
+
+// Settings returns the settings in effect.
+func (s *Scheduler) Settings() Settings {
+	s.confMu.RLock()
+	defer s.confMu.RUnlock()
+	return Settings{
+		At:             s.At,
+		Policy:         s.Policy,
+		MaxCatchUp:     s.MaxCatchUp,
+		RetryPasses:    s.RetryPasses,
+		RetryCooldown:  s.RetryCooldown,
+		Thresholds:     s.Thresholds,
+		AlertOn:        s.AlertOn,
+		Blackouts:      s.Blackouts,
+		BlackoutPolicy: s.BlackoutPolicy,
+	}
+}
+
+// Update replaces the settings. Runs in progress are left alone and pick up
+// the new values at their next step; a new At moves every scheduled job,
+// time-zone buckets included, to that time of day.
+func (s *Scheduler) Update(next Settings) error {
+	if next.At == "" {
+		next.At = defaultAt
+	}
+	if _, err := time.Parse("15:04", next.At); err != nil {
+		return fmt.Errorf("invalid schedule time %q: %w", next.At, err)
+	}
+
+	s.confMu.Lock()
+	moved := next.At != s.at0()
+	s.At = next.At
+	s.Policy = next.Policy
+	s.MaxCatchUp = next.MaxCatchUp
+	s.RetryPasses = next.RetryPasses
+	s.RetryCooldown = next.RetryCooldown
+	s.Thresholds = next.Thresholds
+	s.AlertOn = next.AlertOn
+	s.Blackouts = next.Blackouts
+	s.BlackoutPolicy = next.BlackoutPolicy
+	s.confMu.Unlock()
+
+	if moved {
+		return s.reschedule()
+	}
+	return nil
+}
+
+// reschedule replaces every scheduled job with one at the current At. A run
+// in progress finishes undisturbed.
+func (s *Scheduler) reschedule() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := s.at()
+	moved := make(map[*gocron.Job]*gocron.Job)
+	for i, e := range s.entries {
+		if e.job == nil {
+			continue
+		}
+		job, ok := moved[e.job]
+		if !ok {
+			var err error
+			if job, err = daily(e.cron, at, e.task); err != nil {
+				return err
+			}
+			e.cron.RemoveByReference(e.job)
+			moved[e.job] = job
+		}
+		s.entries[i].job = job
+	}
+	if len(moved) > 0 {
+		logger.Info("Rescheduled %d jobs at %s.", len(moved), at)
+	}
+	return nil
+}
+
+// daily schedules task on cron once a day at the given time, never
+// overlapping itself.
+func daily(cron *gocron.Scheduler, at string, task func()) (*gocron.Job, error) {
+	return cron.Every(1).Day().At(at).SingletonMode().Do(task)
+}
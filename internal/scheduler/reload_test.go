@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/go-co-op/gocron"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler_Update(t *testing.T) {
+	mu := &sync.Mutex{}
+	weather := &localizedService{fakeService: fakeService{name: "weather", mu: mu}, zones: []string{"Asia/Kabul"}}
+	plain := &fakeService{name: "plain", mu: mu}
+	services := []SchedulableService{weather, plain}
+	chanList := []*channels.Channels{channels.New(), channels.New()}
+
+	s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}, LocalTime: true}
+	require.NoError(t, s.StartJob(context.Background(), nil, chanList, services))
+	defer s.Stop()
+
+	next := s.Settings()
+	next.At = "09:15"
+	next.RetryPasses = 3
+	next.Policy = CatchUpAll
+	require.NoError(t, s.Update(next))
+	assert.Equal(t, next, s.Settings())
+
+	// Every job, time-zone buckets included, moves to the new time
+	kabul, err := time.LoadLocation("Asia/Kabul")
+	require.NoError(t, err)
+	jobs := s.Jobs()
+	require.Equal(t, []string{"plain", "weather", "weather@Asia/Kabul"}, jobNames(jobs))
+	for _, j := range jobs {
+		at := j.NextRun.UTC()
+		if j.Service == "weather@Asia/Kabul" {
+			at = j.NextRun.In(kabul)
+		}
+		assert.Equal(t, 9, at.Hour(), j.Service)
+		assert.Equal(t, 15, at.Minute(), j.Service)
+	}
+	assert.Len(t, s.Cron.Jobs(), 1, "the old main job is removed")
+
+	// The moved jobs can still be triggered
+	_, err = s.Trigger("weather@Asia/Kabul")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return weather.callCount == 1
+	}, time.Second, 5*time.Millisecond)
+
+	bad := next
+	bad.At = "25:00"
+	assert.Error(t, s.Update(bad))
+	assert.Equal(t, "09:15", s.Settings().At)
+}
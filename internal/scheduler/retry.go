@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// batch is one service's part of a run.
+type batch struct {
+	name    string
+	service SchedulableService
+	ch      *channels.Channels
+	ctx     context.Context
+	span    trace.Span
+	release func()
+	// err is what RunBatchJob returned
+	err error
+
+	// firstFailed is how many requests failed on the first pass, retried
+	// how many were submitted again by retry passes
+	firstFailed int
+	retried     int
+	// seenFailed is the failed count as of the end of the last retries, so
+	// failures of requests submitted since count as first failures too
+	seenFailed int
+}
+
+// retryFailed runs up to RetryPasses extra passes over the requests that
+// failed, waiting RetryCooldown before each so transient upstream problems
+// have time to clear.
+func (s *Scheduler) retryFailed(ctx context.Context, runID string, batches []*batch) (err error) {
+	for _, b := range batches {
+		b.firstFailed += b.ch.Stats(runID).Failed - b.seenFailed
+	}
+	defer func() {
+		for _, b := range batches {
+			b.seenFailed = b.ch.Stats(runID).Failed
+		}
+	}()
+
+	conf := s.Settings()
+	for pass := 1; pass <= conf.RetryPasses; pass++ {
+		failed := make([][]models.DataRequest, len(batches))
+		total := 0
+		for i, b := range batches {
+			failed[i] = b.ch.TakeFailed(runID)
+			total += len(failed[i])
+		}
+		if total == 0 {
+			return nil
+		}
+
+		logger.Info("Run %s: retrying %d failed requests in %v (pass %d of %d).", runID, total, conf.RetryCooldown, pass, conf.RetryPasses)
+		timer := time.NewTimer(conf.RetryCooldown)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		for i, b := range batches {
+			for _, req := range failed[i] {
+				// The journal marked the failed attempt as finished, so journal
+				// the retry afresh to keep it resumable after a crash
+				req.JobID, req.QueueKey = 0, ""
+				if _, err := b.ch.Submit(b.ctx, req); err != nil {
+					logger.Error("[%s] Failed to resubmit %s: %v", b.name, req.ID, err)
+					break
+				}
+				b.retried++
+			}
+		}
+		for _, b := range batches {
+			if err := b.ch.Wait(ctx, runID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// summary counts every request of b once, by its final outcome.
+func (b *batch) summary(runID string) RunSummary {
+	stats := b.ch.Stats(runID)
+	failed := stats.Failed - b.retried
+	recovered := 0
+	if b.retried > 0 {
+		recovered = b.firstFailed - failed
+	}
+	return RunSummary{
+		RunID:     runID,
+		Done:      stats.Done - b.retried,
+		Failed:    failed,
+		Retried:   b.retried,
+		Recovered: recovered,
+	}
+}
@@ -0,0 +1,240 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/history"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"github.com/go-co-op/gocron"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyService submits its IDs and runs a worker that fails each ID a set
+// number of times before it succeeds.
+type flakyService struct {
+	failures map[string]int
+	// batchErr makes RunBatchJob report an error after submitting
+	batchErr bool
+
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+func (f *flakyService) Name() string { return "flaky" }
+
+func (f *flakyService) RunBatchJob(ctx context.Context, client interface{}, chans *channels.Channels) error {
+	for id := range f.failures {
+		if _, err := chans.Submit(ctx, models.DataRequest{ID: id, Service: "flaky"}); err != nil {
+			return err
+		}
+	}
+	if f.batchErr {
+		return errors.New("failed to read fetch params")
+	}
+	return nil
+}
+
+func (f *flakyService) work(ctx context.Context, chans *channels.Channels) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-chans.DataRequest:
+			req = chans.Start(req)
+			f.mu.Lock()
+			f.attempts[req.ID]++
+			var err error
+			if f.attempts[req.ID] <= f.failures[req.ID] {
+				err = errors.New("API returned 503")
+			}
+			f.mu.Unlock()
+			chans.Finish(req, err)
+		}
+	}
+}
+
+func TestRun_RetriesFailedRequests(t *testing.T) {
+	tests := []struct {
+		name          string
+		passes        int
+		wantDone      int
+		wantFailed    int
+		wantRetried   int
+		wantRecovered int
+		wantAttempts  map[string]int
+	}{
+		{
+			name:         "retries disabled",
+			passes:       0,
+			wantDone:     3,
+			wantFailed:   2,
+			wantAttempts: map[string]int{"ok": 1, "once": 1, "twice": 1},
+		},
+		{
+			name:          "one pass",
+			passes:        1,
+			wantDone:      3,
+			wantFailed:    1,
+			wantRetried:   2,
+			wantRecovered: 1,
+			wantAttempts:  map[string]int{"ok": 1, "once": 2, "twice": 2},
+		},
+		{
+			name:          "passes until nothing fails",
+			passes:        5,
+			wantDone:      3,
+			wantFailed:    0,
+			wantRetried:   3,
+			wantRecovered: 2,
+			wantAttempts:  map[string]int{"ok": 1, "once": 2, "twice": 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			svc := &flakyService{
+				failures: map[string]int{"ok": 0, "once": 1, "twice": 2},
+				attempts: make(map[string]int),
+			}
+			ch := channels.New()
+			go svc.work(ctx, ch)
+
+			hist := &fakeHistory{}
+			s := &Scheduler{
+				Cron:          gocron.NewScheduler(time.UTC),
+				WG:            &sync.WaitGroup{},
+				History:       hist,
+				RetryPasses:   tt.passes,
+				RetryCooldown: time.Millisecond,
+			}
+			s.RunImmediateJob(ctx, nil, []*channels.Channels{ch}, []SchedulableService{svc})
+
+			require.Len(t, hist.runs, 1)
+			run := hist.runs[0]
+			assert.Equal(t, tt.wantDone, run.Done)
+			assert.Equal(t, tt.wantFailed, run.Failed)
+			assert.Equal(t, tt.wantRetried, run.Retried)
+			assert.Equal(t, tt.wantRecovered, run.Recovered)
+			svc.mu.Lock()
+			assert.Equal(t, tt.wantAttempts, svc.attempts)
+			svc.mu.Unlock()
+		})
+	}
+}
+
+func TestRun_RetryCooldownCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &flakyService{failures: map[string]int{"once": 1}, attempts: make(map[string]int)}
+	ch := channels.New()
+	go svc.work(ctx, ch)
+
+	hist := &fakeHistory{}
+	s := &Scheduler{
+		Cron:          gocron.NewScheduler(time.UTC),
+		WG:            &sync.WaitGroup{},
+		History:       hist,
+		RetryPasses:   1,
+		RetryCooldown: time.Hour,
+	}
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	s.RunImmediateJob(ctx, nil, []*channels.Channels{ch}, []SchedulableService{svc})
+
+	require.Len(t, hist.runs, 1)
+	assert.Equal(t, history.StatusInterrupted, hist.runs[0].Status)
+	assert.Equal(t, 1, hist.runs[0].Failed)
+}
+
+// journal keeps the keys of unfinished requests like the Mongo queue does.
+type journal struct {
+	mu      sync.Mutex
+	n       int
+	pending map[string]string
+	failed  map[string]bool
+}
+
+func (j *journal) Append(ctx context.Context, req models.DataRequest) (string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.n++
+	key := fmt.Sprintf("%s-%d", req.ID, j.n)
+	j.pending[key] = req.ID
+	return key, nil
+}
+
+func (j *journal) Ack(ctx context.Context, key string, err error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.pending[key]; !ok {
+		return fmt.Errorf("ack of unknown or finished key %s", key)
+	}
+	delete(j.pending, key)
+	if err != nil {
+		j.failed[key] = true
+	}
+	return nil
+}
+
+func TestRun_RetriesAreJournaled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := &flakyService{failures: map[string]int{"ok": 0, "once": 1}, attempts: make(map[string]int)}
+	j := &journal{pending: make(map[string]string), failed: make(map[string]bool)}
+	ch := channels.New()
+	ch.Journal = j
+	// The retry is left in flight, as if the process crashed during it
+	retried := make(chan models.DataRequest, 1)
+	var firstKey string
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case req := <-ch.DataRequest:
+				req = ch.Start(req)
+				svc.mu.Lock()
+				svc.attempts[req.ID]++
+				attempt := svc.attempts[req.ID]
+				svc.mu.Unlock()
+				switch {
+				case attempt > 1:
+					retried <- req
+				case req.ID == "once":
+					firstKey = req.QueueKey
+					ch.Finish(req, errors.New("API returned 503"))
+				default:
+					ch.Finish(req, nil)
+				}
+			}
+		}
+	}()
+
+	s := &Scheduler{
+		Cron:          gocron.NewScheduler(time.UTC),
+		WG:            &sync.WaitGroup{},
+		History:       &fakeHistory{},
+		RetryPasses:   1,
+		RetryCooldown: time.Millisecond,
+	}
+	go s.RunImmediateJob(ctx, nil, []*channels.Channels{ch}, []SchedulableService{svc})
+
+	req := <-retried
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	assert.Equal(t, map[string]bool{firstKey: true}, j.failed)
+	assert.Equal(t, map[string]string{req.QueueKey: "once"}, j.pending, "the retry should have an unfinished journal entry of its own")
+	assert.NotEqual(t, firstKey, req.QueueKey)
+}
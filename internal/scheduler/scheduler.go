@@ -2,40 +2,193 @@ package scheduler
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/blackout"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/lock"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/tracing"
 	"github.com/go-co-op/gocron"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SchedulableService interface {
+	Name() string
 	RunBatchJob(ctx context.Context, client interface{}, chans *channels.Channels) error
 }
 
+// Partitioner decides which fetch params this instance handles in a run.
+type Partitioner interface {
+	Partition(ctx context.Context) (*cluster.Partition, error)
+}
+
 type Scheduler struct {
 	Cron *gocron.Scheduler
 	WG   *sync.WaitGroup
+	// Locker, when set, makes sure only one instance runs a service's batch
+	// at a time. Without it batches are only kept from overlapping in-process.
+	Locker *lock.Locker
+	// Cluster, when set, splits every run's fetch params between the live
+	// instances so each one only submits its own share. Settle is how long
+	// a run keeps watching, once its share is done, for instances dropping
+	// out whose share it takes over; see rebalance.
+	Cluster Partitioner
+	Settle  time.Duration
+	// The fields from At to BlackoutPolicy, less LocalTime, are changed
+	// with Update once the scheduler runs; see Settings.
+	//
+	// At is the daily time of the scheduled run, "07:30" if empty. It is in
+	// UTC, or in each param's own time zone for Localized services when
+	// LocalTime is set.
+	At        string
+	LocalTime bool
+	// History, when set, records every batch and lets CatchUp skip services
+	// that already ran.
+	History History
+	// Policy decides how CatchUp handles scheduled runs missed while down.
+	Policy CatchUpPolicy
+	// MaxCatchUp caps the runs made by CatchUpAll, 1 if unset.
+	MaxCatchUp int
+	// RetryPasses is how many times a run resubmits its failed requests,
+	// each time after RetryCooldown. Zero disables retries.
+	RetryPasses   int
+	RetryCooldown time.Duration
+	// Thresholds grade each service's run, see DefaultThresholds.
+	Thresholds Thresholds
+	// Alerter, when set, is told about runs whose status is AlertOn or worse
+	// (failed if unset). Such runs are logged as errors either way.
+	Alerter Alerter
+	AlertOn RunStatus
+	// Blackouts hold back the runs of the services they cover while open, and
+	// so does Maintenance, when set, for every service. BlackoutPolicy says
+	// whether such runs are deferred until the end (the default) or skipped.
+	Blackouts      blackout.Windows
+	Maintenance    Maintenance
+	BlackoutPolicy BlackoutPolicy
+
+	// confMu guards the fields in Settings once the scheduler is running
+	confMu sync.RWMutex
+
+	// running holds the names of services with a batch in progress here.
+	running sync.Map
+
+	// Set by StartJob so runs can be triggered later from the admin API
+	mu       sync.Mutex
+	ctx      context.Context
+	client   *mongo.Client
+	chanList []*channels.Channels
+	services []SchedulableService
+	entries  []entry
+	// zoneCrons run the time-zone buckets, one scheduler per location
+	zoneCrons map[string]*gocron.Scheduler
+	status    map[string]*serviceStatus
+	// deferred holds the pending runs of blacked-out services
+	deferred map[string]*time.Timer
+
+	// Set by Shutdown: no new runs start, and halt cancels those in progress
+	stopped  bool
+	haltCtx  context.Context
+	halt     context.CancelFunc
+	inFlight sync.WaitGroup
 }
 
+// entry is one scheduled job: a whole service, or one time-zone bucket of a
+// Localized service.
+type entry struct {
+	service SchedulableService
+	ch      *channels.Channels
+	loc     *time.Location
+	job     *gocron.Job
+	// cron runs job, which calls task; both are kept to reschedule it
+	cron *gocron.Scheduler
+	task func()
+}
+
+// defaultAt is the daily run time used when At is empty.
+const defaultAt = "07:30"
+
 func New() (*Scheduler, error) {
 	s := gocron.NewScheduler(time.UTC)
 	return &Scheduler{
-		Cron: s,
-		WG:   &sync.WaitGroup{},
+		Cron:           s,
+		WG:             &sync.WaitGroup{},
+		At:             defaultAt,
+		Policy:         CatchUpOnce,
+		Thresholds:     DefaultThresholds,
+		BlackoutPolicy: BlackoutDefer,
 	}, nil
 }
 
 func (s *Scheduler) StartJob(ctx context.Context, client *mongo.Client, chanList []*channels.Channels, services []SchedulableService) error {
+	var entries []entry
+	var mainChans []*channels.Channels
+	var mainServices []SchedulableService
+	zoneCrons := make(map[string]*gocron.Scheduler)
 
-	_, err := s.Cron.Every(1).Day().At("07:30").Do(func() {
-		s.runAllJobs(ctx, client, chanList, services)
-	})
+	for i, service := range services {
+		ch := chanList[i]
+		locs := s.zonesFor(ctx, client, service)
+		if len(locs) == 0 {
+			mainChans = append(mainChans, ch)
+			mainServices = append(mainServices, service)
+			entries = append(entries, entry{service: service, ch: ch, loc: s.Cron.Location()})
+			continue
+		}
+
+		// Params in a known zone run at local time, the rest with the main job
+		zones := make([]string, 0, len(locs))
+		for _, loc := range locs {
+			zones = append(zones, loc.String())
+		}
+		rest := &zoneService{SchedulableService: service, exclude: zones}
+		mainChans = append(mainChans, ch)
+		mainServices = append(mainServices, rest)
+		entries = append(entries, entry{service: rest, ch: ch, loc: s.Cron.Location()})
+
+		for _, loc := range locs {
+			cron, ok := zoneCrons[loc.String()]
+			if !ok {
+				cron = gocron.NewScheduler(loc)
+				zoneCrons[loc.String()] = cron
+			}
+			bucket := &zoneService{SchedulableService: service, zone: loc.String()}
+			task := func() {
+				s.runAllJobs(ctx, client, []*channels.Channels{ch}, []SchedulableService{bucket})
+			}
+			job, err := daily(cron, s.at(), task)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{service: bucket, ch: ch, loc: loc, job: job, cron: cron, task: task})
+		}
+		logger.Info("[%s] Scheduled at %s local time in %d time zones.", service.Name(), s.at(), len(locs))
+	}
+
+	task := func() {
+		s.runAllJobs(ctx, client, mainChans, mainServices)
+	}
+	job, err := daily(s.Cron, s.at(), task)
 	if err != nil {
 		return err
 	}
+	for i := range entries {
+		if entries[i].job == nil {
+			entries[i].job, entries[i].cron, entries[i].task = job, s.Cron, task
+		}
+	}
+
+	s.mu.Lock()
+	s.ctx, s.client, s.chanList, s.services = ctx, client, chanList, services
+	s.entries, s.zoneCrons = entries, zoneCrons
+	s.mu.Unlock()
 	// _, err := s.Cron.Every(1).Minute().Do(func() {
 	// 	s.runAllJobs(ctx, client, chans, services)
 	// })
@@ -44,35 +197,276 @@ func (s *Scheduler) StartJob(ctx context.Context, client *mongo.Client, chanList
 	// }
 
 	s.Cron.StartAsync()
+	for _, cron := range zoneCrons {
+		cron.StartAsync()
+	}
 	return nil
 }
 
-func (s *Scheduler) runAllJobs(ctx context.Context, client *mongo.Client, chanList []*channels.Channels, services []SchedulableService) {
+// Stop stops the main scheduler and every time-zone bucket.
+func (s *Scheduler) Stop() {
+	s.Cron.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cron := range s.zoneCrons {
+		cron.Stop()
+	}
+	for name, timer := range s.deferred {
+		timer.Stop()
+		delete(s.deferred, name)
+	}
+}
+
+// Shutdown stops the schedule, refuses new runs and triggers, and cancels
+// the runs in progress so they stop submitting. It then waits for them to
+// return or for ctx to be done. Requests already submitted are left for the
+// worker pools to drain. Calling it again only waits again.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.Stop()
+
+	s.mu.Lock()
+	s.stopped = true
+	if s.halt != nil {
+		s.halt()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for runs in progress: %w", ctx.Err())
+	}
+}
+
+// enter registers a run with Shutdown. The returned context is cancelled on
+// Shutdown, and done must be called once the run returns. It reports false
+// once the scheduler is shutting down.
+func (s *Scheduler) enter(ctx context.Context) (context.Context, func(), bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return nil, nil, false
+	}
+	if s.haltCtx == nil {
+		s.haltCtx, s.halt = context.WithCancel(context.Background())
+	}
+	s.inFlight.Add(1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	unregister := context.AfterFunc(s.haltCtx, cancel)
+	return ctx, func() {
+		unregister()
+		cancel()
+		s.inFlight.Done()
+	}, true
+}
+
+// stopping reports whether Shutdown has been called.
+func (s *Scheduler) stopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// endSpan ends the span of a run or batch with its status, failing it on
+// an error or a failed status.
+func endSpan(span trace.Span, status, errMsg string, attrs ...attribute.KeyValue) {
+	span.SetAttributes(append(attrs, attribute.String("status", status))...)
+	var err error
+	switch {
+	case errMsg != "":
+		err = errors.New(errMsg)
+	case status == string(StatusFailed):
+		err = errors.New("run failed")
+	}
+	tracing.End(span, err)
+}
+
+func (s *Scheduler) runAllJobs(ctx context.Context, client *mongo.Client, chanList []*channels.Channels, services []SchedulableService) RunResult {
+	return s.run(ctx, primitive.NewObjectID().Hex(), client, chanList, services)
+}
+
+// run runs the batches of services and grades each of them. The result's
+// status is the worst among them, and failed if the run was cut short.
+func (s *Scheduler) run(ctx context.Context, runID string, client *mongo.Client, chanList []*channels.Channels, services []SchedulableService) (result RunResult) {
+	result = RunResult{RunID: runID, Status: StatusSuccess}
+	// Deferred runs must outlive this one
+	parent := ctx
+	ctx, leave, ok := s.enter(ctx)
+	if !ok {
+		logger.Info("Shutting down, skipping run %s.", runID)
+		result.Status = StatusFailed
+		return
+	}
+	defer leave()
+	ctx, span := tracing.Start(ctx, "run", tracing.RunID.String(runID))
+	defer func() { endSpan(span, string(result.Status), "") }()
+
 	startTime := time.Now()
-	logger.Info("--- Fetch Job Started ---")
+	logger.Info("--- Fetch Job Started --- (run %s)", runID)
 	defer func() {
 		elapsed := time.Since(startTime)
 		logger.Info("--- Fetch Job Finished --- (Total time: %v)", elapsed)
 	}()
 
+	var batches []*batch
+	finish := func(b *batch, completed bool) RunSummary {
+		summary := s.finished(b, runID, completed)
+		endSpan(b.span, string(summary.Status), summary.Error, attribute.Int("done", summary.Done), attribute.Int("failed", summary.Failed))
+		b.ch.Forget(runID)
+		// Let other runs in as soon as this service's requests are finished
+		b.release()
+		result.Services = append(result.Services, summary)
+		result.Status = result.Status.Worse(summary.Status)
+		return summary
+	}
+	defer func() {
+		for _, b := range batches {
+			finish(b, false)
+		}
+	}()
+
+	runCtx := logger.WithFields(channels.WithRunID(ctx, runID), logger.RunID, runID)
+	var part *cluster.Partition
+	if s.Cluster != nil {
+		p, err := s.Cluster.Partition(ctx)
+		if err != nil {
+			logger.Error("Failed to determine this instance's partition, skipping run %s: %v", runID, err)
+			result.Status = StatusFailed
+			return
+		}
+		logger.Info("Run %s: %s handles its share of params among %d instances.", runID, p.Self, len(p.Members))
+		runCtx = cluster.WithPartition(runCtx, p)
+		part = p
+	}
+	mode := s.maintenance(ctx)
+	now := time.Now()
 	for i, service := range services {
 		currCh := chanList[i]
-		err := service.RunBatchJob(ctx, client, currCh)
+		name := service.Name()
+		if s.paused(name) || s.paused(baseName(service)) {
+			logger.Info("[%s] Paused, skipping.", name)
+			continue
+		}
+		if reason, until, held := s.blackedOut(mode, now, name, baseName(service)); held {
+			summary := s.holdBack(parent, runID, client, currCh, service, reason, until)
+			result.Services = append(result.Services, summary)
+			continue
+		}
+		svcCtx, release, ok := s.claim(logger.WithFields(runCtx, logger.Service, name), name)
+		if !ok {
+			continue
+		}
+		svcCtx, span := tracing.Start(svcCtx, "batch", tracing.Service.String(name), tracing.RunID.String(runID))
+		batches = append(batches, &batch{name: name, service: service, ch: currCh, ctx: svcCtx, span: span, release: release})
+		s.started(name, runID, currCh)
+
+		err := service.RunBatchJob(svcCtx, client, currCh)
 		if err != nil {
-			logger.Error("Error running batch job for service: %v", err)
+			logger.From(svcCtx).Error("Error running batch job for service: %v", err)
+			batches[len(batches)-1].err = err
 		}
 	}
 
 	logger.Info("Waiting for all submitted jobs to complete...")
-	for _, ch := range chanList {
-		ch.WG.Wait()
+	for _, b := range batches {
+		if err := b.ch.Wait(ctx, runID); err != nil {
+			logger.Error("Stopped waiting for run %s: %v", runID, err)
+			result.Status = StatusFailed
+			return
+		}
+	}
+	if err := s.retryFailed(ctx, runID, batches); err != nil {
+		logger.Error("Stopped retrying run %s: %v", runID, err)
+		result.Status = StatusFailed
+		return
+	}
+	if part != nil && len(batches) > 0 {
+		if err := s.rebalance(ctx, runID, client, part, batches); err != nil {
+			logger.Error("Stopped rebalancing run %s: %v", runID, err)
+			result.Status = StatusFailed
+			return
+		}
+	}
+
+	var done, failed, recovered int
+	for len(batches) > 0 {
+		summary := finish(batches[0], true)
+		batches = batches[1:]
+		done += summary.Done
+		failed += summary.Failed
+		recovered += summary.Recovered
+	}
+	logger.Info("All jobs completed (%s): %d finished, %d failed, %d recovered on retry.", result.Status, done, failed, recovered)
+	return result
+}
+
+// claim reserves name for a batch, first in this process and then, if a
+// Locker is set, across instances. It reports false if the batch is already
+// running somewhere. The returned context is cancelled if the distributed
+// lease is lost, and release must be called once the batch has finished;
+// calling it more than once is safe.
+func (s *Scheduler) claim(ctx context.Context, name string) (context.Context, func(), bool) {
+	if _, busy := s.running.LoadOrStore(name, struct{}{}); busy {
+		logger.Info("[%s] Previous batch still running, skipping.", name)
+		return nil, nil, false
+	}
+
+	if s.Locker == nil {
+		var once sync.Once
+		return ctx, func() { once.Do(func() { s.running.Delete(name) }) }, true
+	}
+
+	lease, err := s.Locker.Acquire(ctx, name)
+	if err != nil {
+		s.running.Delete(name)
+		if errors.Is(err, lock.ErrHeld) {
+			logger.Info("[%s] Batch is running on another instance, skipping.", name)
+		} else {
+			logger.Error("[%s] Failed to acquire batch lock, skipping: %v", name, err)
+		}
+		return nil, nil, false
+	}
+	logger.Info("[%s] Acquired batch lock (token %d).", name, lease.Token)
+
+	leaseCtx, releaseLease := lease.Hold(ctx)
+	// Requests carry the lease to their store, which refuses to write once
+	// another instance took the batch over
+	leaseCtx = channels.WithFence(leaseCtx, lease)
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			releaseLease()
+			s.running.Delete(name)
+		})
+	}
+	return leaseCtx, release, true
+}
+
+func (s *Scheduler) at() string {
+	s.confMu.RLock()
+	defer s.confMu.RUnlock()
+	return s.at0()
+}
+
+// at0 is at for callers holding confMu.
+func (s *Scheduler) at0() string {
+	if s.At == "" {
+		return defaultAt
 	}
-	logger.Info("All jobs completed successfully.")
+	return s.At
 }
 
-func (s *Scheduler) RunImmediateJob(ctx context.Context, client *mongo.Client, chanList []*channels.Channels, services []SchedulableService) {
+func (s *Scheduler) RunImmediateJob(ctx context.Context, client *mongo.Client, chanList []*channels.Channels, services []SchedulableService) RunResult {
 	logger.Info("--- Immediate Fetch Job Started ---")
 	defer logger.Info("--- Immediate Fetch Job Finished ---")
 
-	s.runAllJobs(ctx, client, chanList, services)
+	return s.runAllJobs(ctx, client, chanList, services)
 }
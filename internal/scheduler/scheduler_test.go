@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/lock"
 	"github.com/AbdulWasayUl/go-api-parser-mono/models"
 	"github.com/go-co-op/gocron"
 	"github.com/stretchr/testify/assert"
@@ -20,22 +23,31 @@ type fakeService struct {
 	callCount int
 	mu        *sync.Mutex
 	returnErr bool
+	// fenced records whether the batch ran under a lease
+	fenced bool
 }
 
+func (f *fakeService) Name() string { return f.name }
+
 func (f *fakeService) RunBatchJob(ctx context.Context, client interface{}, chans *channels.Channels) error {
 	if f.mu != nil {
 		f.mu.Lock()
 		f.callCount++
+		f.fenced = channels.FenceFromContext(ctx) != nil
 		f.mu.Unlock()
 	}
 
-	// Simulate the service adding and completing work so the scheduler's wait doesn't block.
-	if chans != nil && chans.WG != nil {
-		chans.WG.Add(1)
+	// Simulate the service submitting work and a worker finishing it so the
+	// scheduler's wait for the run doesn't block.
+	if chans != nil {
+		if _, err := chans.Submit(ctx, models.DataRequest{ID: f.name, Service: f.name}); err != nil {
+			return err
+		}
 		go func() {
+			req := chans.Start(<-chans.DataRequest)
 			// simulate small work
 			time.Sleep(5 * time.Millisecond)
-			chans.WG.Done()
+			chans.Finish(req, nil)
 		}()
 	}
 
@@ -102,7 +114,7 @@ func TestRunImmediateJob_TableDriven(t *testing.T) {
 			// prepare channels list matching services
 			chanList := make([]*channels.Channels, len(services))
 			for i := range chanList {
-				chanList[i] = &channels.Channels{DataRequest: make(chan models.DataRequest, 1), WG: &sync.WaitGroup{}}
+				chanList[i] = channels.New()
 			}
 
 			s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}}
@@ -130,3 +142,131 @@ func TestStartJob_Basic(t *testing.T) {
 	// Stop the scheduler to avoid goroutine leaks in test runs.
 	s.Cron.Stop()
 }
+
+// blockingService holds its batch open until release is closed.
+type blockingService struct {
+	name    string
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingService) Name() string { return b.name }
+
+func (b *blockingService) RunBatchJob(ctx context.Context, client interface{}, chans *channels.Channels) error {
+	b.calls.Add(1)
+	close(b.started)
+	<-b.release
+	return nil
+}
+
+func TestRunAllJobs_SkipsOverlappingBatch(t *testing.T) {
+	svc := &blockingService{name: "slow", started: make(chan struct{}), release: make(chan struct{})}
+	services := []SchedulableService{svc}
+	chanList := []*channels.Channels{channels.New()}
+	s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}}
+
+	done := make(chan struct{})
+	go func() {
+		s.RunImmediateJob(context.Background(), nil, chanList, services)
+		close(done)
+	}()
+	<-svc.started
+
+	// The second run finds the batch still in progress and skips it.
+	s.RunImmediateJob(context.Background(), nil, chanList, services)
+	assert.Equal(t, int32(1), svc.calls.Load())
+
+	close(svc.release)
+	<-done
+
+	// Once finished, the service can run again.
+	svc.started = make(chan struct{})
+	s.RunImmediateJob(context.Background(), nil, chanList, services)
+	assert.Equal(t, int32(2), svc.calls.Load())
+}
+
+// heldStore is a lock.Store whose locks are always held by someone else,
+// except for the names listed in free.
+type heldStore struct {
+	free map[string]bool
+}
+
+func (h *heldStore) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (int64, error) {
+	if h.free[name] {
+		return 1, nil
+	}
+	return 0, lock.ErrHeld
+}
+
+func (h *heldStore) Refresh(ctx context.Context, name, owner string, token int64, ttl time.Duration) error {
+	return nil
+}
+
+func (h *heldStore) Release(ctx context.Context, name, owner string, token int64) error {
+	return nil
+}
+
+func (h *heldStore) Check(ctx context.Context, name string, token int64) error {
+	return nil
+}
+
+func TestRunAllJobs_SkipsLockedServices(t *testing.T) {
+	mu := &sync.Mutex{}
+	free := &fakeService{name: "free", mu: mu}
+	held := &fakeService{name: "held", mu: mu}
+	services := []SchedulableService{free, held}
+	chanList := []*channels.Channels{channels.New(), channels.New()}
+
+	s := &Scheduler{
+		Cron:   gocron.NewScheduler(time.UTC),
+		WG:     &sync.WaitGroup{},
+		Locker: &lock.Locker{Store: &heldStore{free: map[string]bool{"free": true}}, Owner: "test", TTL: time.Minute},
+	}
+	s.RunImmediateJob(context.Background(), nil, chanList, services)
+
+	assert.Equal(t, 1, free.callCount)
+	assert.Equal(t, 0, held.callCount)
+	assert.True(t, free.fenced, "requests of a locked batch should carry its lease")
+}
+
+// ownerService records whether each run's context gives it ownership of its key.
+type ownerService struct {
+	owned []bool
+}
+
+func (o *ownerService) Name() string { return "owner" }
+
+func (o *ownerService) RunBatchJob(ctx context.Context, client interface{}, chans *channels.Channels) error {
+	o.owned = append(o.owned, cluster.Owns(ctx, "owner", "Kabul"))
+	return nil
+}
+
+type fixedPartitioner struct {
+	p   *cluster.Partition
+	err error
+}
+
+func (f fixedPartitioner) Partition(ctx context.Context) (*cluster.Partition, error) {
+	return f.p, f.err
+}
+
+func TestRunAllJobs_Partition(t *testing.T) {
+	svc := &ownerService{}
+	services := []SchedulableService{svc}
+	chanList := []*channels.Channels{channels.New()}
+	s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}}
+
+	// This instance is not a member of the ring, so it owns nothing.
+	s.Cluster = fixedPartitioner{p: cluster.NewPartition("self", []string{"other"})}
+	s.RunImmediateJob(context.Background(), nil, chanList, services)
+
+	// Without membership the run is skipped rather than duplicating work.
+	s.Cluster = fixedPartitioner{err: fmt.Errorf("mongo down")}
+	s.RunImmediateJob(context.Background(), nil, chanList, services)
+
+	s.Cluster = fixedPartitioner{p: cluster.NewPartition("self", []string{"self"})}
+	s.RunImmediateJob(context.Background(), nil, chanList, services)
+
+	assert.Equal(t, []bool{false, true}, svc.owned)
+}
@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+)
+
+// alertTimeout bounds how long an Alerter may take.
+const alertTimeout = 10 * time.Second
+
+// RunStatus grades the outcome of a run.
+type RunStatus string
+
+const (
+	StatusSuccess RunStatus = "success"
+	StatusPartial RunStatus = "partial"
+	StatusFailed  RunStatus = "failed"
+	// StatusSkipped marks a run held back by a blackout window or maintenance.
+	StatusSkipped RunStatus = "skipped"
+)
+
+// severity orders statuses from best to worst.
+func (st RunStatus) severity() int {
+	switch st {
+	case StatusSuccess, StatusSkipped:
+		return 0
+	case StatusPartial:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func ParseRunStatus(v string) (RunStatus, error) {
+	switch st := RunStatus(v); st {
+	case StatusSuccess, StatusPartial, StatusFailed:
+		return st, nil
+	}
+	return "", fmt.Errorf("unknown run status %q (want %s, %s or %s)", v, StatusSuccess, StatusPartial, StatusFailed)
+}
+
+// Worse returns whichever of st and other is the worse outcome.
+func (st RunStatus) Worse(other RunStatus) RunStatus {
+	if other.severity() > st.severity() {
+		return other
+	}
+	return st
+}
+
+// ExitCode maps a status to the process exit code used in one-shot mode.
+func (st RunStatus) ExitCode() int {
+	switch st {
+	case StatusSuccess, StatusSkipped:
+		return 0
+	case StatusPartial:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// Thresholds grade a service's run by the share of its requests that failed.
+type Thresholds struct {
+	// Partial is the failure ratio above which a run is partial.
+	Partial float64
+	// Failed is the failure ratio above which a run has failed.
+	Failed float64
+}
+
+// DefaultThresholds count any failure as partial and more than 10% as failed.
+var DefaultThresholds = Thresholds{Partial: 0, Failed: 0.10}
+
+// Status grades a run. A run that was interrupted, or whose batch could not
+// submit its requests, has failed whatever its counts.
+func (t Thresholds) Status(done, failed int, complete bool) RunStatus {
+	if !complete {
+		return StatusFailed
+	}
+	if done == 0 {
+		return StatusSuccess
+	}
+	ratio := float64(failed) / float64(done)
+	switch {
+	case ratio > t.Failed:
+		return StatusFailed
+	case ratio > t.Partial:
+		return StatusPartial
+	default:
+		return StatusSuccess
+	}
+}
+
+// RunResult is the outcome of a run across its services.
+type RunResult struct {
+	RunID    string       `json:"run_id"`
+	Status   RunStatus    `json:"status"`
+	Services []RunSummary `json:"services"`
+}
+
+// Alerter is notified of service runs that end at or below AlertOn.
+type Alerter interface {
+	Alert(ctx context.Context, summary RunSummary) error
+}
+
+// alert notifies the Alerter if summary is bad enough.
+func (s *Scheduler) alert(summary RunSummary) {
+	alertOn := s.Settings().AlertOn
+	if alertOn == "" {
+		alertOn = StatusFailed
+	}
+	if summary.Status.severity() < alertOn.severity() {
+		return
+	}
+
+	logger.Error("[%s] Run %s %s: %d of %d requests failed.", summary.Service, summary.RunID, summary.Status, summary.Failed, summary.Done)
+	if s.Alerter == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+	if err := s.Alerter.Alert(ctx, summary); err != nil {
+		logger.Error("[%s] Failed to send alert for run %s: %v", summary.Service, summary.RunID, err)
+	}
+}
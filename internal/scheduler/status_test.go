@@ -0,0 +1,124 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/go-co-op/gocron"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThresholds_Status(t *testing.T) {
+	tests := []struct {
+		name     string
+		done     int
+		failed   int
+		complete bool
+		want     RunStatus
+	}{
+		{"nothing to do", 0, 0, true, StatusSuccess},
+		{"all succeeded", 100, 0, true, StatusSuccess},
+		{"some failed", 100, 5, true, StatusPartial},
+		{"at the failed threshold", 100, 10, true, StatusPartial},
+		{"above the failed threshold", 100, 11, true, StatusFailed},
+		{"all failed", 100, 100, true, StatusFailed},
+		{"interrupted", 100, 0, false, StatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, DefaultThresholds.Status(tt.done, tt.failed, tt.complete))
+		})
+	}
+}
+
+func TestRunStatus(t *testing.T) {
+	assert.Equal(t, StatusPartial, StatusSuccess.Worse(StatusPartial))
+	assert.Equal(t, StatusFailed, StatusFailed.Worse(StatusPartial))
+	assert.Equal(t, 0, StatusSuccess.ExitCode())
+	assert.Equal(t, 2, StatusPartial.ExitCode())
+	assert.Equal(t, 1, StatusFailed.ExitCode())
+
+	st, err := ParseRunStatus("partial")
+	require.NoError(t, err)
+	assert.Equal(t, StatusPartial, st)
+	_, err = ParseRunStatus("bad")
+	assert.Error(t, err)
+}
+
+// recordingAlerter keeps every alert it receives.
+type recordingAlerter struct {
+	mu     sync.Mutex
+	alerts []RunSummary
+}
+
+func (r *recordingAlerter) Alert(ctx context.Context, summary RunSummary) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, summary)
+	return nil
+}
+
+func TestRun_StatusAndAlerts(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   map[string]int
+		batchErr   bool
+		alertOn    RunStatus
+		wantStatus RunStatus
+		wantAlerts int
+	}{
+		{"success", map[string]int{"a": 0, "b": 0}, false, StatusFailed, StatusSuccess, 0},
+		{"partial not alerted by default", map[string]int{"a": 1, "b": 0, "c": 0, "d": 0, "e": 0, "f": 0, "g": 0, "h": 0, "i": 0, "j": 0, "k": 0}, false, "", StatusPartial, 0},
+		{"partial alerted on request", map[string]int{"a": 1, "b": 0, "c": 0, "d": 0, "e": 0, "f": 0, "g": 0, "h": 0, "i": 0, "j": 0, "k": 0}, false, StatusPartial, StatusPartial, 1},
+		{"failed", map[string]int{"a": 1, "b": 0}, false, StatusFailed, StatusFailed, 1},
+		{"batch error", map[string]int{"a": 0}, true, StatusFailed, StatusFailed, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			svc := &flakyService{failures: tt.failures, attempts: make(map[string]int), batchErr: tt.batchErr}
+			ch := channels.New()
+			go svc.work(ctx, ch)
+
+			alerter := &recordingAlerter{}
+			s := &Scheduler{
+				Cron:       gocron.NewScheduler(time.UTC),
+				WG:         &sync.WaitGroup{},
+				Thresholds: DefaultThresholds,
+				Alerter:    alerter,
+				AlertOn:    tt.alertOn,
+			}
+			result := s.RunImmediateJob(ctx, nil, []*channels.Channels{ch}, []SchedulableService{svc})
+
+			assert.Equal(t, tt.wantStatus, result.Status)
+			require.Len(t, result.Services, 1)
+			assert.Equal(t, "flaky", result.Services[0].Service)
+			assert.Equal(t, tt.wantStatus, result.Services[0].Status)
+			assert.Len(t, alerter.alerts, tt.wantAlerts)
+		})
+	}
+}
+
+func TestRun_ResultWorstOfServices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	good := &fakeService{name: "good"}
+	bad := &flakyService{failures: map[string]int{"a": 1}, attempts: make(map[string]int)}
+	chGood, chBad := channels.New(), channels.New()
+	go bad.work(ctx, chBad)
+
+	s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}, Thresholds: DefaultThresholds}
+	result := s.RunImmediateJob(ctx, nil, []*channels.Channels{chGood, chBad}, []SchedulableService{good, bad})
+
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Len(t, result.Services, 2)
+}
@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+	// The runtime image has no zoneinfo, so embed it for the param zones
+	_ "time/tzdata"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/db"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// zoneSep separates a service's name from its time zone in bucket names,
+// e.g. "weather_db@Asia/Kabul".
+const zoneSep = "@"
+
+// Localized is implemented by services whose fetch params carry a "tz" field,
+// which lets them be scheduled at a local time in each zone.
+type Localized interface {
+	TimeZones(ctx context.Context, client interface{}) ([]string, error)
+}
+
+// zoneService runs a service's batch for the params of one time zone, or,
+// with an empty zone, for the params outside every zone in exclude.
+type zoneService struct {
+	SchedulableService
+	zone    string
+	exclude []string
+}
+
+func (z *zoneService) Name() string {
+	if z.zone == "" {
+		return z.SchedulableService.Name()
+	}
+	return z.SchedulableService.Name() + zoneSep + z.zone
+}
+
+func (z *zoneService) RunBatchJob(ctx context.Context, client interface{}, chans *channels.Channels) error {
+	filter := bson.M{"tz": z.zone}
+	if z.zone == "" {
+		filter = bson.M{"tz": bson.M{"$nin": z.exclude}}
+	}
+	return z.SchedulableService.RunBatchJob(db.WithParamFilter(ctx, filter), client, chans)
+}
+
+// baseName returns the name of the service behind a time-zone bucket.
+func baseName(service SchedulableService) string {
+	if z, ok := service.(*zoneService); ok {
+		return z.SchedulableService.Name()
+	}
+	return service.Name()
+}
+
+// zonesFor returns the locations service's params are spread over, or nil if
+// it is scheduled in UTC as a whole.
+func (s *Scheduler) zonesFor(ctx context.Context, client interface{}, service SchedulableService) []*time.Location {
+	localized, ok := service.(Localized)
+	if !s.LocalTime || !ok {
+		return nil
+	}
+
+	zones, err := localized.TimeZones(ctx, client)
+	if err != nil {
+		logger.Error("[%s] Failed to read time zones, scheduling in UTC: %v", service.Name(), err)
+		return nil
+	}
+
+	locs := make([]*time.Location, 0, len(zones))
+	for _, zone := range zones {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			// Params with an unknown zone stay with the main job
+			logger.Error("[%s] Unknown time zone %q: %v", service.Name(), zone, err)
+			continue
+		}
+		locs = append(locs, loc)
+	}
+	return locs
+}
@@ -0,0 +1,101 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/go-co-op/gocron"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// localizedService reports a fixed set of time zones.
+type localizedService struct {
+	fakeService
+	zones []string
+	err   error
+}
+
+func (l *localizedService) TimeZones(ctx context.Context, client interface{}) ([]string, error) {
+	return l.zones, l.err
+}
+
+func jobNames(jobs []JobInfo) []string {
+	names := make([]string, len(jobs))
+	for i, j := range jobs {
+		names[i] = j.Service
+	}
+	return names
+}
+
+func TestStartJob_LocalTime(t *testing.T) {
+	mu := &sync.Mutex{}
+	weather := &localizedService{fakeService: fakeService{name: "weather", mu: mu}, zones: []string{"Asia/Kabul", "Europe/Tirane", "Not/AZone"}}
+	plain := &fakeService{name: "plain", mu: mu}
+	services := []SchedulableService{weather, plain}
+	chanList := []*channels.Channels{channels.New(), channels.New()}
+
+	s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}, LocalTime: true}
+	require.NoError(t, s.StartJob(context.Background(), nil, chanList, services))
+	defer s.Stop()
+
+	jobs := s.Jobs()
+	assert.Equal(t, []string{"plain", "weather", "weather@Asia/Kabul", "weather@Europe/Tirane"}, jobNames(jobs))
+
+	// Each bucket fires at 07:30 in its own zone.
+	kabul, err := time.LoadLocation("Asia/Kabul")
+	require.NoError(t, err)
+	next := jobs[2].NextRun.In(kabul)
+	assert.Equal(t, 7, next.Hour())
+	assert.Equal(t, 30, next.Minute())
+
+	// Buckets can be triggered on their own, and pausing the service pauses them.
+	_, err = s.Trigger("weather@Asia/Kabul")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return weather.callCount == 1
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, s.Pause("weather"))
+	_, err = s.Trigger("weather@Europe/Tirane")
+	assert.ErrorIs(t, err, ErrPaused)
+}
+
+func TestStartJob_LocalTimeFallsBackToUTC(t *testing.T) {
+	tests := []struct {
+		name      string
+		localTime bool
+		err       error
+	}{
+		{"disabled", false, nil},
+		{"zones unavailable", true, errors.New("mongo down")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weather := &localizedService{fakeService: fakeService{name: "weather"}, zones: []string{"Asia/Kabul"}, err: tt.err}
+			s := &Scheduler{Cron: gocron.NewScheduler(time.UTC), WG: &sync.WaitGroup{}, LocalTime: tt.localTime}
+			require.NoError(t, s.StartJob(context.Background(), nil, []*channels.Channels{channels.New()}, []SchedulableService{weather}))
+			defer s.Stop()
+
+			assert.Equal(t, []string{"weather"}, jobNames(s.Jobs()))
+		})
+	}
+}
+
+func TestMissedRuns_Location(t *testing.T) {
+	kabul, err := time.LoadLocation("Asia/Kabul")
+	require.NoError(t, err)
+	s := &Scheduler{Cron: gocron.NewScheduler(time.UTC)}
+
+	// 07:30 in Kabul is 03:00 UTC.
+	last := time.Date(2025, 3, 9, 3, 0, 0, 0, time.UTC)
+	assert.Equal(t, 0, s.missedRuns(last, time.Date(2025, 3, 10, 2, 59, 0, 0, time.UTC), kabul))
+	assert.Equal(t, 1, s.missedRuns(last, time.Date(2025, 3, 10, 3, 0, 0, 0, time.UTC), kabul))
+}
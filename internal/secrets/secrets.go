@@ -0,0 +1,94 @@
+// Package secrets seals settings files with AES-256-GCM, so API keys and
+// passwords can ship next to the app encrypted and be opened at startup with
+// a key kept on the host.
+//
+// A sealed file is the base64 of a random nonce followed by the ciphertext.
+// The plaintext is in .env format, one KEY=VALUE per line.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the length of a key in bytes. Keys are written as base64.
+const KeySize = 32
+
+// additionalData binds sealed files to this format, so other AES-GCM blobs
+// made with the same key do not open as settings.
+var additionalData = []byte("go-api-parser secrets v1")
+
+// ErrDecrypt is returned when a file does not open with the key: the key is
+// wrong, or the file was changed or is not a sealed file.
+var ErrDecrypt = errors.New("secrets: wrong key or corrupted file")
+
+// GenerateKey returns a new random key, base64 encoded.
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ParseKey decodes a base64 key, ignoring surrounding whitespace such as
+// the trailing newline of a key file.
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("secrets: key is not base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secrets: key is %d bytes, want %d", len(key), KeySize)
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext with key.
+func Seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, additionalData)
+	out := base64.StdEncoding.EncodeToString(sealed)
+	return []byte(out + "\n"), nil
+}
+
+// Open decrypts a file made by Seal.
+func Open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secrets: key is %d bytes, want %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
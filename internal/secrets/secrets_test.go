@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	encoded, err := GenerateKey()
+	require.NoError(t, err)
+	key, err := ParseKey(encoded + "\n")
+	require.NoError(t, err)
+
+	plaintext := []byte("WEATHER_API_KEY=abc\nMONGO_PASS=p@ss\n")
+	sealed, err := Seal(key, plaintext)
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "abc")
+
+	opened, err := Open(key, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	// Sealing twice gives different files
+	again, err := Seal(key, plaintext)
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+}
+
+func TestOpen_Rejects(t *testing.T) {
+	encoded, _ := GenerateKey()
+	key, _ := ParseKey(encoded)
+	other, _ := GenerateKey()
+	otherKey, _ := ParseKey(other)
+
+	sealed, err := Seal(key, []byte("A=1"))
+	require.NoError(t, err)
+
+	_, err = Open(otherKey, sealed)
+	assert.ErrorIs(t, err, ErrDecrypt)
+
+	raw, _ := base64.StdEncoding.DecodeString(string(sealed[:len(sealed)-1]))
+	raw[len(raw)-1] ^= 1
+	_, err = Open(key, []byte(base64.StdEncoding.EncodeToString(raw)))
+	assert.ErrorIs(t, err, ErrDecrypt)
+
+	_, err = Open(key, []byte("A=1"))
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
+
+func TestParseKey(t *testing.T) {
+	_, err := ParseKey("not base64!")
+	assert.ErrorContains(t, err, "not base64")
+
+	_, err = ParseKey(base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.ErrorContains(t, err, "key is 5 bytes, want 32")
+}
@@ -0,0 +1,430 @@
+// Package spool keeps the records that could not be written to MongoDB in
+// a write-ahead log on disk, and replays them once it is reachable again.
+//
+// Records are spooled in order and replayed at least once: a crash during a
+// replay writes the records since the last checkpoint again. Each record is
+// given its _id before it is first written, so MongoDB turns those second
+// writes away as duplicates. A spooled record is not yet a success for the
+// fetch param it came from; Outcomes hears of it once it is replayed.
+package spool
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/db"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/metrics"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	logName        = "spool.wal"
+	checkpointName = "spool.offset"
+	rejectedName   = "rejected.bson"
+	paramsDir      = "params"
+
+	// headerSize is the record length and CRC-32 of its payload
+	headerSize = 8
+	// checkpointEvery bounds the records written again after a crash
+	checkpointEvery = 100
+)
+
+// ErrFull is returned when a record does not fit in the spool.
+var ErrFull = errors.New("spool is full")
+
+// ErrSpooled is returned by Insert when it kept the record for later.
+var ErrSpooled = fmt.Errorf("%w: spooled until MongoDB is reachable", models.ErrDeferred)
+
+type record struct {
+	DB         string   `bson:"db"`
+	Collection string   `bson:"collection"`
+	Doc        bson.Raw `bson:"doc"`
+	// Service and Param name the fetch param the record came from, if known
+	Service string `bson:"service,omitempty"`
+	Param   string `bson:"param,omitempty"`
+}
+
+// Outcomes is told how each replayed record ended, e.g. to track when each
+// fetch param was last stored.
+type Outcomes interface {
+	Observe(service, param string, err error)
+}
+
+type paramKey struct{}
+
+type source struct{ service, param string }
+
+// WithParam names the service and fetch param the records inserted with
+// ctx come from, so their replay can be reported to Outcomes.
+func WithParam(ctx context.Context, service, param string) context.Context {
+	return context.WithValue(ctx, paramKey{}, source{service, param})
+}
+
+// Spool is a write-ahead log of MongoDB inserts. It is safe for concurrent
+// use.
+type Spool struct {
+	// Outcomes, if set, is told of every replayed record whose fetch param
+	// is known. Set it before Run.
+	Outcomes Outcomes
+
+	dir      string
+	maxBytes int64
+
+	// replayMu serialises replays
+	replayMu sync.Mutex
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	done    int64
+	pending int
+}
+
+// Open opens the spool in dir, creating it if needed, holding at most
+// maxBytes of records. A record cut short by a crash is dropped.
+func Open(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(filepath.Join(dir, paramsDir), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, maxBytes: maxBytes, f: f}
+	if err := s.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover finds where the log and the last replay ended.
+func (s *Spool) recover() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+
+	data, err := os.ReadFile(filepath.Join(s.dir, checkpointName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 {
+		if s.done, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err != nil || s.done < 0 || s.done > end {
+			return fmt.Errorf("invalid spool checkpoint %q", data)
+		}
+	}
+
+	off := s.done
+	for off < end {
+		_, next, err := s.read(off, end)
+		if err != nil {
+			logger.Error("Dropping %d bytes of damaged spool records at offset %d: %v", end-off, off, err)
+			if err := s.f.Truncate(off); err != nil {
+				return err
+			}
+			break
+		}
+		off = next
+		s.pending++
+	}
+	s.size = off
+	if s.pending == 0 {
+		return s.reset()
+	}
+	logger.Info("Spool %s holds %d records to replay.", s.dir, s.pending)
+	return nil
+}
+
+// reset empties the log once everything in it is replayed. mu must be held
+// or the spool not yet shared.
+func (s *Spool) reset() error {
+	if err := s.f.Truncate(0); err != nil {
+		return err
+	}
+	s.size, s.done, s.pending = 0, 0, 0
+	if err := os.Remove(filepath.Join(s.dir, checkpointName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Insert writes doc to the collection, or to the spool if MongoDB is
+// unreachable or records are still waiting to be replayed, so they land in
+// the order they were fetched. It returns ErrSpooled if it spooled doc.
+func (s *Spool) Insert(ctx context.Context, client *mongo.Client, dbName, collectionName string, doc interface{}) error {
+	// The same _id goes to MongoDB and the spool, so a record that reached
+	// MongoDB after all is not written twice
+	raw, err := withID(doc)
+	if err != nil {
+		return err
+	}
+	if s.Pending() == 0 {
+		_, err := client.Database(dbName).Collection(collectionName).InsertOne(ctx, raw)
+		if !db.Unreachable(err) {
+			return err
+		}
+		logger.Error("MongoDB unreachable, spooling records to %s: %v", s.dir, err)
+	}
+	rec := record{DB: dbName, Collection: collectionName, Doc: raw}
+	if src, ok := ctx.Value(paramKey{}).(source); ok {
+		rec.Service, rec.Param = src.service, src.param
+	}
+	if err := s.append(rec); err != nil {
+		return err
+	}
+	return ErrSpooled
+}
+
+// withID marshals doc, adding an ObjectID _id unless it has one.
+func withID(doc interface{}) (bson.Raw, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bson.Raw(raw).LookupErr("_id"); err == nil {
+		return raw, nil
+	}
+	var d bson.D
+	if err := bson.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+	return bson.Marshal(append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, d...))
+}
+
+func (s *Spool) append(rec record) error {
+	payload, err := bson.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	copy(buf[headerSize:], payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+int64(len(buf)) > s.maxBytes {
+		return fmt.Errorf("%w: %d bytes waiting in %s", ErrFull, s.size-s.done, s.dir)
+	}
+	if _, err := s.f.Write(buf); err != nil {
+		// Cut off whatever part of the record was written
+		s.f.Truncate(s.size)
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.size += int64(len(buf))
+	s.pending++
+	metrics.Spooled.Inc()
+	return nil
+}
+
+// read returns the record at off, in a log ending at end, and the offset of
+// the next one.
+func (s *Spool) read(off, end int64) (record, int64, error) {
+	var rec record
+	header := make([]byte, headerSize)
+	if _, err := s.f.ReadAt(header, off); err != nil {
+		return rec, 0, fmt.Errorf("reading header: %w", err)
+	}
+	// A damaged length must not allocate more than the log holds
+	size := int64(binary.BigEndian.Uint32(header))
+	if size > end-off-headerSize {
+		return rec, 0, fmt.Errorf("record length %d runs past the end of the log", size)
+	}
+	payload := make([]byte, size)
+	if _, err := s.f.ReadAt(payload, off+headerSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return rec, 0, fmt.Errorf("reading record: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return rec, 0, errors.New("checksum mismatch")
+	}
+	if err := bson.Unmarshal(payload, &rec); err != nil {
+		return rec, 0, err
+	}
+	return rec, off + headerSize + int64(len(payload)), nil
+}
+
+// Pending returns the number of records waiting to be replayed.
+func (s *Spool) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Replay writes the spooled records to MongoDB in order and empties the
+// spool, returning how many were written. It stops at the first record
+// MongoDB cannot be reached for; one it rejects, other than as a duplicate,
+// is set aside in rejected.bson in the spool directory.
+func (s *Spool) Replay(ctx context.Context, client *mongo.Client) (int, error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	replayed := 0
+	for {
+		s.mu.Lock()
+		off, end := s.done, s.size
+		if off == end {
+			err := s.reset()
+			s.mu.Unlock()
+			return replayed, err
+		}
+		s.mu.Unlock()
+
+		for n := 1; off < end; n++ {
+			rec, next, err := s.read(off, end)
+			if err != nil {
+				return replayed, err
+			}
+			_, err = client.Database(rec.DB).Collection(rec.Collection).InsertOne(ctx, rec.Doc)
+			if db.Unreachable(err) || (err != nil && ctx.Err() != nil) {
+				return replayed, errors.Join(err, s.checkpoint())
+			}
+			if mongo.IsDuplicateKeyError(err) {
+				// Written by an earlier replay
+				err = nil
+			}
+			if err != nil {
+				logger.Error("MongoDB rejected a spooled record for %s.%s, setting it aside: %v", rec.DB, rec.Collection, err)
+				if err := s.reject(rec); err != nil {
+					return replayed, err
+				}
+				err = fmt.Errorf("MongoDB rejected the spooled record: %w", err)
+			}
+			if s.Outcomes != nil && rec.Service != "" {
+				s.Outcomes.Observe(rec.Service, rec.Param, err)
+			}
+
+			s.mu.Lock()
+			s.done = next
+			s.pending--
+			s.mu.Unlock()
+			off = next
+			replayed++
+			metrics.Replayed.Inc()
+			if n%checkpointEvery == 0 {
+				if err := s.checkpoint(); err != nil {
+					return replayed, err
+				}
+			}
+		}
+	}
+}
+
+func (s *Spool) checkpoint() error {
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	return writeFile(filepath.Join(s.dir, checkpointName), []byte(strconv.FormatInt(done, 10)))
+}
+
+func (s *Spool) reject(rec record) error {
+	data, err := bson.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, rejectedName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Run replays the spool straight away and then every interval while it
+// holds records, until ctx is done.
+func (s *Spool) Run(ctx context.Context, client *mongo.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if pending := s.Pending(); pending > 0 {
+			n, err := s.Replay(ctx, client)
+			switch {
+			case err != nil && ctx.Err() == nil:
+				logger.Error("Replayed %d of %d spooled records, the rest wait for the next attempt: %v", n, pending, err)
+			case err == nil:
+				logger.Info("Replayed %d spooled records into MongoDB.", n)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close saves the replay progress and closes the log.
+func (s *Spool) Close() error {
+	err := s.checkpoint()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == 0 {
+		err = errors.Join(err, s.reset())
+	}
+	return errors.Join(err, s.f.Close())
+}
+
+// SaveParams keeps a copy of a fetch param collection, see db.ParamCache.
+func (s *Spool) SaveParams(dbName, collectionName string, params []map[string]interface{}) error {
+	data, err := bson.Marshal(struct {
+		Params []map[string]interface{} `bson:"params"`
+	}{params})
+	if err != nil {
+		return err
+	}
+	return writeFile(s.paramsPath(dbName, collectionName), data)
+}
+
+// LoadParams returns the copy SaveParams kept.
+func (s *Spool) LoadParams(dbName, collectionName string) ([]map[string]interface{}, error) {
+	data, err := os.ReadFile(s.paramsPath(dbName, collectionName))
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Params []map[string]interface{} `bson:"params"`
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Params, nil
+}
+
+func (s *Spool) paramsPath(dbName, collectionName string) string {
+	return filepath.Join(s.dir, paramsDir, dbName+"."+collectionName+".bson")
+}
+
+// writeFile replaces path with data in one step.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,175 @@
+package spool_test
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/spool"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// unreachable returns a client for a server that is not there.
+func unreachable(t *testing.T) *mongo.Client {
+	t.Helper()
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client
+}
+
+func TestSpool_InsertWhileUnreachable(t *testing.T) {
+	dir := t.TempDir()
+	client := unreachable(t)
+	ctx := context.Background()
+
+	s, err := spool.Open(dir, 1<<20)
+	require.NoError(t, err)
+	for _, city := range []string{"Kabul", "Lahore", "Oslo"} {
+		assert.ErrorIs(t, s.Insert(ctx, client, "weather_db", "daily_data", bson.M{"city": city}), spool.ErrSpooled)
+	}
+	assert.Equal(t, 3, s.Pending())
+
+	// Nothing is lost while MongoDB stays down
+	n, err := s.Replay(ctx, client)
+	assert.Error(t, err)
+	assert.Zero(t, n)
+	assert.Equal(t, 3, s.Pending())
+	require.NoError(t, s.Close())
+
+	// The records survive a restart
+	s, err = spool.Open(dir, 1<<20)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 3, s.Pending())
+}
+
+func TestSpool_DropsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	client := unreachable(t)
+	ctx := context.Background()
+
+	s, err := spool.Open(dir, 1<<20)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.Insert(ctx, client, "weather_db", "daily_data", bson.M{"city": "Kabul"}), spool.ErrSpooled)
+	require.NoError(t, s.Close())
+
+	// A crash in the middle of a write leaves part of a record behind
+	wal := filepath.Join(dir, "spool.wal")
+	f, err := os.OpenFile(wal, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1, 0, 9, 9})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = spool.Open(dir, 1<<20)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 1, s.Pending())
+	assert.ErrorIs(t, s.Insert(ctx, client, "weather_db", "daily_data", bson.M{"city": "Lahore"}), spool.ErrSpooled)
+	assert.Equal(t, 2, s.Pending())
+}
+
+func TestSpool_DropsDamagedLength(t *testing.T) {
+	dir := t.TempDir()
+	client := unreachable(t)
+	ctx := context.Background()
+
+	s, err := spool.Open(dir, 1<<20)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.Insert(ctx, client, "weather_db", "daily_data", bson.M{"city": "Kabul"}), spool.ErrSpooled)
+	require.NoError(t, s.Close())
+
+	// A header claiming close to 4 GiB is dropped, not allocated
+	wal := filepath.Join(dir, "spool.wal")
+	f, err := os.OpenFile(wal, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0xff, 0xff, 0xff, 0xf0, 0, 0, 0, 0, 1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = spool.Open(dir, 1<<20)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 1, s.Pending())
+}
+
+func TestSpool_AssignsID(t *testing.T) {
+	dir := t.TempDir()
+	client := unreachable(t)
+	ctx := context.Background()
+
+	s, err := spool.Open(dir, 1<<20)
+	require.NoError(t, err)
+	own := primitive.NewObjectID()
+	assert.ErrorIs(t, s.Insert(ctx, client, "weather_db", "daily_data", bson.M{"city": "Kabul"}), spool.ErrSpooled)
+	err = s.Insert(spool.WithParam(ctx, "weather_db", "Lahore"), client, "weather_db", "daily_data", bson.M{"_id": own, "city": "Lahore"})
+	assert.ErrorIs(t, err, models.ErrDeferred)
+	require.NoError(t, s.Close())
+
+	// Every record is logged with an _id, so replaying it twice is harmless,
+	// and with the fetch param it came from, if known
+	data, err := os.ReadFile(filepath.Join(dir, "spool.wal"))
+	require.NoError(t, err)
+	var ids []primitive.ObjectID
+	var params []string
+	for len(data) > 0 {
+		size := binary.BigEndian.Uint32(data)
+		var rec struct {
+			Doc     bson.Raw `bson:"doc"`
+			Service string   `bson:"service"`
+			Param   string   `bson:"param"`
+		}
+		require.NoError(t, bson.Unmarshal(data[8:8+size], &rec))
+		id, ok := rec.Doc.Lookup("_id").ObjectIDOK()
+		require.True(t, ok, "record without an ObjectID _id: %v", rec.Doc)
+		ids = append(ids, id)
+		params = append(params, rec.Service+"/"+rec.Param)
+		data = data[8+size:]
+	}
+	require.Len(t, ids, 2)
+	assert.False(t, ids[0].IsZero())
+	assert.Equal(t, own, ids[1])
+	assert.Equal(t, []string{"/", "weather_db/Lahore"}, params)
+}
+
+func TestSpool_Full(t *testing.T) {
+	s, err := spool.Open(t.TempDir(), 100)
+	require.NoError(t, err)
+	defer s.Close()
+
+	client := unreachable(t)
+	doc := bson.M{"city": "Kabul", "note": string(make([]byte, 100))}
+	err = s.Insert(context.Background(), client, "weather_db", "daily_data", doc)
+	assert.ErrorIs(t, err, spool.ErrFull)
+	assert.Zero(t, s.Pending())
+}
+
+func TestSpool_Params(t *testing.T) {
+	s, err := spool.Open(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.LoadParams("weather_db", "fetch_params")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	params := []map[string]interface{}{
+		{"city": "Kabul", "tz": "Asia/Kabul", "priority": int32(2)},
+		{"city": "Oslo"},
+	}
+	require.NoError(t, s.SaveParams("weather_db", "fetch_params", params))
+	got, err := s.LoadParams("weather_db", "fetch_params")
+	require.NoError(t, err)
+	assert.Equal(t, params, got)
+}
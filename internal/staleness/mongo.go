@@ -0,0 +1,103 @@
+package staleness
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore keeps one document per service and param. Instances merge
+// their outcomes into it, so it holds the latest of each.
+type MongoStore struct {
+	Coll *mongo.Collection
+}
+
+func NewMongoStore(client *mongo.Client, dbName, collectionName string) *MongoStore {
+	return &MongoStore{Coll: client.Database(dbName).Collection(collectionName)}
+}
+
+// EnsureIndexes makes service and param unique, and drops params not
+// attempted for retention, if set.
+func (s *MongoStore) EnsureIndexes(ctx context.Context, retention time.Duration) error {
+	models := []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "service", Value: 1}, {Key: "param", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}}
+	if retention > 0 {
+		models = append(models, mongo.IndexModel{
+			Keys:    bson.D{{Key: "last_attempt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(retention / time.Second)),
+		})
+	}
+	_, err := s.Coll.Indexes().CreateMany(ctx, models)
+	return err
+}
+
+// Save merges params into the stored ones: the latest success and attempt
+// win, with the error of the latest attempt.
+func (s *MongoStore) Save(ctx context.Context, params []Param) error {
+	writes := make([]mongo.WriteModel, 0, 2*len(params))
+	for _, p := range params {
+		filter := bson.M{"service": p.Service, "param": p.Param}
+		update := bson.M{
+			"$min": bson.M{"first_seen": p.FirstSeen},
+			"$max": bson.M{"last_attempt": p.LastAttempt},
+		}
+		if !p.LastSuccess.IsZero() {
+			update["$max"].(bson.M)["last_success"] = p.LastSuccess
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+
+		// The error belongs to the latest attempt only
+		latest := bson.M{"service": p.Service, "param": p.Param, "last_attempt": p.LastAttempt}
+		errUpdate := bson.M{"$unset": bson.M{"last_error": ""}}
+		if p.LastError != "" {
+			errUpdate = bson.M{"$set": bson.M{"last_error": p.LastError}}
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(latest).SetUpdate(errUpdate))
+	}
+	_, err := s.Coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(true))
+	return err
+}
+
+// ClaimAlert sets alerted_at to at if the stored param is still stale at
+// cutoff and was not alerted on since its last success. Being a single
+// update, only one instance can claim each time a param goes stale.
+func (s *MongoStore) ClaimAlert(ctx context.Context, p Param, cutoff, at time.Time) (bool, error) {
+	filter := bson.M{
+		"service": p.Service,
+		"param":   p.Param,
+		"$and": bson.A{
+			// Another instance may have stored it since
+			bson.M{"$or": bson.A{
+				bson.M{"last_success": bson.M{"$exists": false}},
+				bson.M{"last_success": bson.M{"$lt": cutoff}},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"alerted_at": bson.M{"$exists": false}},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$alerted_at", "$last_success"}}},
+			}},
+		},
+	}
+	res, err := s.Coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"alerted_at": at}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// Load returns every stored param.
+func (s *MongoStore) Load(ctx context.Context) ([]Param, error) {
+	cur, err := s.Coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var params []Param
+	if err := cur.All(ctx, &params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
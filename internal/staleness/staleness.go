@@ -0,0 +1,311 @@
+// Package staleness tracks when each fetch param of each service was last
+// stored successfully, and reports the params that have gone without a
+// success for longer than a threshold.
+package staleness
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/metrics"
+)
+
+// alertTimeout bounds how long an Alerter may take.
+const alertTimeout = 10 * time.Second
+
+// Param is what is known about one fetch param of a service.
+type Param struct {
+	Service string `bson:"service" json:"service"`
+	Param   string `bson:"param" json:"param"`
+	// LastSuccess is zero if the param was never stored
+	LastSuccess time.Time `bson:"last_success,omitempty" json:"last_success,omitzero"`
+	LastAttempt time.Time `bson:"last_attempt" json:"last_attempt"`
+	// FirstSeen is when the param was first attempted, which counts for a
+	// param that never succeeded
+	FirstSeen time.Time `bson:"first_seen" json:"first_seen"`
+	// LastError is the error of the last attempt, empty if it succeeded
+	LastError string `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	// AlertedAt is when an instance last alerted on the param going stale
+	AlertedAt time.Time `bson:"alerted_at,omitempty" json:"alerted_at,omitzero"`
+}
+
+// Age returns how long p has gone without a success at now.
+func (p Param) Age(now time.Time) time.Duration {
+	if p.LastSuccess.IsZero() {
+		return now.Sub(p.FirstSeen)
+	}
+	return now.Sub(p.LastSuccess)
+}
+
+// alerted reports whether the param was alerted on since its last success.
+func (p Param) alerted() bool {
+	return !p.AlertedAt.IsZero() && !p.AlertedAt.Before(p.LastSuccess)
+}
+
+// merge combines what two instances, or memory and the store, know of the
+// same param.
+func (p Param) merge(o Param) Param {
+	if o.LastSuccess.After(p.LastSuccess) {
+		p.LastSuccess = o.LastSuccess
+	}
+	if o.LastAttempt.After(p.LastAttempt) {
+		p.LastAttempt, p.LastError = o.LastAttempt, o.LastError
+	}
+	if p.FirstSeen.IsZero() || (!o.FirstSeen.IsZero() && o.FirstSeen.Before(p.FirstSeen)) {
+		p.FirstSeen = o.FirstSeen
+	}
+	if o.AlertedAt.After(p.AlertedAt) {
+		p.AlertedAt = o.AlertedAt
+	}
+	return p
+}
+
+// Store keeps the params beyond the life of the process and shares them
+// between instances.
+type Store interface {
+	Save(ctx context.Context, params []Param) error
+	Load(ctx context.Context) ([]Param, error)
+	// ClaimAlert records at as the time p was alerted on, and reports true,
+	// unless p succeeded since cutoff or was already alerted on since its
+	// last success, by any instance
+	ClaimAlert(ctx context.Context, p Param, cutoff, at time.Time) (bool, error)
+}
+
+// Alerter is notified of params that have just gone stale.
+type Alerter interface {
+	AlertStale(ctx context.Context, params []Param) error
+}
+
+type key struct{ service, param string }
+
+// Tracker records the outcome of each request and checks for stale params.
+type Tracker struct {
+	Store   Store
+	Alerter Alerter
+	// Retention is how long a param that is no longer attempted, e.g. one
+	// removed from fetch_params, is remembered
+	Retention time.Duration
+
+	mu        sync.Mutex
+	threshold time.Duration
+	params    map[key]Param
+	dirty     map[key]bool
+}
+
+// New returns a Tracker reporting params without a success for longer than
+// threshold; zero disables the reports.
+func New(store Store, threshold, retention time.Duration) *Tracker {
+	return &Tracker{
+		Store:     store,
+		Retention: retention,
+		threshold: threshold,
+		params:    make(map[key]Param),
+		dirty:     make(map[key]bool),
+	}
+}
+
+// SetThreshold changes the threshold while the app runs.
+func (t *Tracker) SetThreshold(threshold time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.threshold = threshold
+}
+
+// Observe records how a request for param of service ended. It implements
+// workpool.Outcomes and spool.Outcomes: a spooled record is reported as a
+// deferred store, and as a success once replayed.
+func (t *Tracker) Observe(service, param string, err error) {
+	now := time.Now()
+	k := key{service, param}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.params[k]
+	if !ok {
+		p = Param{Service: service, Param: param, FirstSeen: now}
+	}
+	p.LastAttempt, p.LastError = now, ""
+	if err != nil {
+		p.LastError = err.Error()
+	} else {
+		p.LastSuccess = now
+	}
+	t.params[k] = p
+	t.dirty[k] = true
+}
+
+// Load reads what the store knows, e.g. at startup.
+func (t *Tracker) Load(ctx context.Context) error {
+	stored, err := t.Store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range stored {
+		k := key{p.Service, p.Param}
+		if cur, ok := t.params[k]; ok {
+			p = cur.merge(p)
+		}
+		t.params[k] = p
+	}
+	return nil
+}
+
+// flush saves the params changed since the last flush.
+func (t *Tracker) flush(ctx context.Context) error {
+	t.mu.Lock()
+	batch := make([]Param, 0, len(t.dirty))
+	for k := range t.dirty {
+		batch = append(batch, t.params[k])
+	}
+	t.dirty = make(map[key]bool)
+	t.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := t.Store.Save(ctx, batch); err != nil {
+		// Try again at the next check
+		t.mu.Lock()
+		for _, p := range batch {
+			t.dirty[key{p.Service, p.Param}] = true
+		}
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Stale returns the params without a success for longer than the
+// threshold at now, longest first.
+func (t *Tracker) Stale(now time.Time) []Param {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stale(now)
+}
+
+func (t *Tracker) stale(now time.Time) []Param {
+	if t.threshold <= 0 {
+		return nil
+	}
+	var out []Param
+	for _, p := range t.params {
+		if p.Age(now) > t.threshold {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if ai, aj := out[i].Age(now), out[j].Age(now); ai != aj {
+			return ai > aj
+		}
+		if out[i].Service != out[j].Service {
+			return out[i].Service < out[j].Service
+		}
+		return out[i].Param < out[j].Param
+	})
+	return out
+}
+
+// Check saves the recent outcomes, merges in those of other instances,
+// updates the metrics and alerts on params that have just gone stale. Each
+// time a param goes stale, only one instance alerts on it, once.
+func (t *Tracker) Check(ctx context.Context) {
+	if err := t.flush(ctx); err != nil {
+		logger.Warn("Failed to save param freshness, retrying at the next check: %v", err)
+	} else if err := t.Load(ctx); err != nil {
+		logger.Warn("Failed to read param freshness: %v", err)
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	if t.Retention > 0 {
+		for k, p := range t.params {
+			if now.Sub(p.LastAttempt) > t.Retention && !t.dirty[k] {
+				delete(t.params, k)
+			}
+		}
+	}
+	stale := t.stale(now)
+	cutoff := now.Add(-t.threshold)
+	t.mu.Unlock()
+
+	metrics.StaleParams.Reset()
+	metrics.ParamStaleness.Reset()
+	for _, p := range stale {
+		metrics.StaleParams.WithLabelValues(p.Service).Inc()
+		metrics.ParamStaleness.WithLabelValues(p.Service, p.Param).Set(p.Age(now).Seconds())
+	}
+
+	var fresh []Param
+	for _, p := range stale {
+		if p.alerted() {
+			continue
+		}
+		claimed, err := t.Store.ClaimAlert(ctx, p, cutoff, now)
+		if err != nil {
+			// Rather alert twice than not at all
+			logger.Warn("Failed to record the alert for %s of %s, alerting anyway: %v", p.Param, p.Service, err)
+			claimed = true
+		}
+		if claimed {
+			fresh = append(fresh, p)
+		}
+		// Whoever alerted, do not claim again until the param recovers
+		t.mu.Lock()
+		k := key{p.Service, p.Param}
+		if cur, ok := t.params[k]; ok && now.After(cur.AlertedAt) {
+			cur.AlertedAt = now
+			t.params[k] = cur
+		}
+		t.mu.Unlock()
+	}
+
+	if len(fresh) == 0 {
+		return
+	}
+	for _, p := range fresh {
+		logger.Error("[%s] %s has not been stored for %s (last error: %s)", p.Service, p.Param, p.Age(now).Round(time.Minute), orNone(p.LastError))
+	}
+	if t.Alerter == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, alertTimeout)
+	defer cancel()
+	if err := t.Alerter.AlertStale(ctx, fresh); err != nil {
+		logger.Error("Failed to send alert for %d stale params: %v", len(fresh), err)
+	}
+}
+
+// Run checks straight away, so what the store knows is reported from the
+// start, then every interval until ctx is done.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	t.Check(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Keep what was recorded since the last check
+			saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := t.flush(saveCtx); err != nil {
+				logger.Warn("Failed to save param freshness: %v", err)
+			}
+			return
+		case <-ticker.C:
+			t.Check(ctx)
+		}
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,180 @@
+package staleness
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore merges saved params like MongoStore does.
+type memStore struct {
+	mu      sync.Mutex
+	params  map[key]Param
+	failing bool
+}
+
+func newMemStore() *memStore { return &memStore{params: make(map[key]Param)} }
+
+func (s *memStore) Save(ctx context.Context, params []Param) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return errors.New("store down")
+	}
+	for _, p := range params {
+		k := key{p.Service, p.Param}
+		// Only ClaimAlert records alerts
+		p.AlertedAt = time.Time{}
+		if cur, ok := s.params[k]; ok {
+			p = cur.merge(p)
+		}
+		s.params[k] = p
+	}
+	return nil
+}
+
+func (s *memStore) Load(ctx context.Context) ([]Param, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Param, 0, len(s.params))
+	for _, p := range s.params {
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (s *memStore) ClaimAlert(ctx context.Context, p Param, cutoff, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key{p.Service, p.Param}
+	cur, ok := s.params[k]
+	if !ok || !cur.LastSuccess.Before(cutoff) || cur.alerted() {
+		return false, nil
+	}
+	cur.AlertedAt = at
+	s.params[k] = cur
+	return true, nil
+}
+
+type alerts struct {
+	mu   sync.Mutex
+	sent [][]Param
+}
+
+func (a *alerts) AlertStale(ctx context.Context, params []Param) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sent = append(a.sent, params)
+	return nil
+}
+
+func TestTracker_Stale(t *testing.T) {
+	tr := New(newMemStore(), time.Hour, 0)
+	tr.Observe("weather_db", "Lahore", errors.New("status 503"))
+	tr.Observe("weather_db", "Karachi", nil)
+
+	now := time.Now()
+	assert.Empty(t, tr.Stale(now))
+
+	// Lahore never succeeded, so it counts from when it was first seen
+	stale := tr.Stale(now.Add(2 * time.Hour))
+	require.Len(t, stale, 2)
+	assert.Equal(t, "status 503", stale[0].LastError)
+	assert.True(t, stale[0].LastSuccess.IsZero())
+
+	// A failure keeps the last success
+	karachi := tr.params[key{"weather_db", "Karachi"}].LastSuccess
+	tr.Observe("weather_db", "Karachi", errors.New("timeout"))
+	p := tr.params[key{"weather_db", "Karachi"}]
+	assert.Equal(t, karachi, p.LastSuccess)
+	assert.Equal(t, "timeout", p.LastError)
+
+	tr.SetThreshold(0)
+	assert.Empty(t, tr.Stale(now.Add(24*time.Hour)), "a zero threshold disables the reports")
+}
+
+func TestTracker_Check(t *testing.T) {
+	store := newMemStore()
+	now := time.Now()
+	// Known from an earlier start or another instance
+	store.params[key{"weather_db", "Lahore"}] = Param{
+		Service: "weather_db", Param: "Lahore",
+		LastSuccess: now.Add(-72 * time.Hour), LastAttempt: now.Add(-time.Hour), FirstSeen: now.Add(-30 * 24 * time.Hour),
+		LastError: "status 503",
+	}
+	store.params[key{"weather_db", "Karachi"}] = Param{
+		Service: "weather_db", Param: "Karachi",
+		LastSuccess: now.Add(-time.Hour), LastAttempt: now.Add(-time.Hour), FirstSeen: now.Add(-30 * 24 * time.Hour),
+	}
+	// No longer attempted for longer than the retention
+	store.params[key{"weather_db", "Quetta"}] = Param{
+		Service: "weather_db", Param: "Quetta",
+		LastSuccess: now.Add(-60 * 24 * time.Hour), LastAttempt: now.Add(-60 * 24 * time.Hour), FirstSeen: now.Add(-90 * 24 * time.Hour),
+	}
+
+	alerter := &alerts{}
+	tr := New(store, 48*time.Hour, 30*24*time.Hour)
+	tr.Alerter = alerter
+	ctx := context.Background()
+
+	tr.Check(ctx)
+	stale := tr.Stale(time.Now())
+	require.Len(t, stale, 1)
+	assert.Equal(t, "Lahore", stale[0].Param)
+	require.Len(t, alerter.sent, 1)
+	assert.Equal(t, "Lahore", alerter.sent[0][0].Param)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.StaleParams.WithLabelValues("weather_db")))
+	assert.InDelta(t, (72 * time.Hour).Seconds(), testutil.ToFloat64(metrics.ParamStaleness.WithLabelValues("weather_db", "Lahore")), 60)
+
+	// Still stale: no second alert
+	tr.Check(ctx)
+	assert.Len(t, alerter.sent, 1)
+
+	// Nor from another instance, or after a restart
+	other := New(store, 48*time.Hour, 30*24*time.Hour)
+	other.Alerter = alerter
+	other.Check(ctx)
+	assert.Len(t, alerter.sent, 1)
+	require.Len(t, other.Stale(time.Now()), 1)
+
+	// Recovers and is saved
+	tr.Observe("weather_db", "Lahore", nil)
+	tr.Check(ctx)
+	assert.Empty(t, tr.Stale(time.Now()))
+	assert.Equal(t, 0, testutil.CollectAndCount(metrics.StaleParams))
+	assert.False(t, store.params[key{"weather_db", "Lahore"}].LastSuccess.Before(now))
+	assert.Empty(t, store.params[key{"weather_db", "Lahore"}].LastError)
+	assert.Len(t, alerter.sent, 1)
+
+	// Going stale again alerts again, days later
+	lahore := store.params[key{"weather_db", "Lahore"}]
+	lahore.LastSuccess, lahore.AlertedAt = now.Add(-50*time.Hour), now.Add(-60*time.Hour)
+	store.params[key{"weather_db", "Lahore"}] = lahore
+	later := New(store, 48*time.Hour, 30*24*time.Hour)
+	later.Alerter = alerter
+	later.Check(ctx)
+	require.Len(t, alerter.sent, 2)
+	assert.Equal(t, "Lahore", alerter.sent[1][0].Param)
+}
+
+func TestTracker_SaveFailure(t *testing.T) {
+	store := newMemStore()
+	store.failing = true
+	tr := New(store, time.Hour, 0)
+	tr.Observe("openaq_db", "2178", nil)
+
+	tr.Check(context.Background())
+	assert.Empty(t, store.params)
+
+	// Kept for the next check
+	store.failing = false
+	tr.Check(context.Background())
+	assert.Contains(t, store.params, key{"openaq_db", "2178"})
+}
@@ -0,0 +1,42 @@
+// Package mongotest starts a throwaway MongoDB for tests that need a real
+// server.
+package mongotest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tc "github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Client starts MongoDB in a container and returns a client connected to
+// it. Both are cleaned up when t ends.
+func Client(t *testing.T, ctx context.Context) *mongo.Client {
+	t.Helper()
+
+	container, err := tc.GenericContainer(ctx, tc.GenericContainerRequest{
+		ContainerRequest: tc.ContainerRequest{
+			Image:        "mongo:6",
+			ExposedPorts: []string{"27017/tcp"},
+			WaitingFor:   wait.ForLog("Waiting for connections"),
+		},
+		Started: true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { container.Terminate(ctx) })
+
+	host, err := container.Host(ctx)
+	require.NoError(t, err)
+	port, err := container.MappedPort(ctx, "27017")
+	require.NoError(t, err)
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%s", host, port.Port())))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Disconnect(ctx) })
+	return client
+}
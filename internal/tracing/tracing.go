@@ -0,0 +1,120 @@
+// Package tracing sets up OpenTelemetry tracing and starts the spans of the
+// pipeline: a run, the batch of each service in it, and each request with
+// its rate-limit wait, HTTP attempts, parse and store.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// serviceName identifies the app in the exported resource.
+const serviceName = "go-api-parser-mono"
+
+// Attribute keys, named like the log fields.
+const (
+	Service    = attribute.Key(logger.Service)
+	RunID      = attribute.Key(logger.RunID)
+	RequestID  = attribute.Key(logger.RequestID)
+	FetchParam = attribute.Key(logger.FetchParam)
+	WorkerID   = attribute.Key(logger.WorkerID)
+	Provider   = attribute.Key("provider")
+	Attempt    = attribute.Key("attempt")
+)
+
+// Options configure the exporter.
+type Options struct {
+	// Exporter is none, otlp, stdout or file
+	Exporter string
+	// Endpoint is the OTLP/HTTP collector URL; empty leaves it to the
+	// OTEL_EXPORTER_OTLP_* variables, or http://localhost:4318
+	Endpoint string
+	// File receives the spans as JSON lines with the file exporter
+	File string
+	// SampleRatio is the share of traces kept, from 0 to 1
+	SampleRatio float64
+	// InstanceID tells instances apart
+	InstanceID string
+}
+
+// Setup installs the tracer provider for opts. The returned function
+// exports the spans still buffered and stops it; with ExporterNone spans
+// are not recorded and it does nothing.
+func Setup(ctx context.Context, opts Options) (shutdown func(context.Context) error, err error) {
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	switch opts.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var httpOpts []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			httpOpts = append(httpOpts, otlptracehttp.WithEndpointURL(opts.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, httpOpts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		if file, err = os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", opts.Exporter, err)
+	}
+
+	res := resource.NewSchemaless(semconv.ServiceName(serviceName), semconv.ServiceInstanceID(opts.InstanceID))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Requests follow the sampling decision of their run
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		return err
+	}, nil
+}
+
+// Start starts a span named name as a child of the one in ctx, if any.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(serviceName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it failed when err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup_File(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := Setup(context.Background(), Options{Exporter: ExporterFile, File: path, SampleRatio: 1})
+	require.NoError(t, err)
+
+	ctx, run := Start(context.Background(), "run", RunID.String("run-1"))
+	_, req := Start(ctx, "request", Service.String("weather_db"))
+	End(req, errors.New("store failed"))
+	End(run, nil)
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	out := string(data)
+	assert.Contains(t, out, `"Name":"run"`)
+	assert.Contains(t, out, `"Name":"request"`)
+	assert.Contains(t, out, `"Value":"weather_db"`)
+	assert.Contains(t, out, `"Description":"store failed"`)
+}
+
+func TestSetup_None(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Options{Exporter: ExporterNone})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup(context.Background(), Options{Exporter: "jaeger"})
+	assert.Error(t, err)
+}
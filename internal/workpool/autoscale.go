@@ -0,0 +1,163 @@
+package workpool
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/api"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+)
+
+// AutoscaleConfig bounds and tunes an Autoscaler.
+type AutoscaleConfig struct {
+	Min      int
+	Max      int
+	Interval time.Duration
+	// QueuePerWorker is how many queued requests per worker count as a backlog.
+	QueuePerWorker int
+	// LatencyFactor is how far above its baseline the upstream latency may rise
+	// before the pool is shrunk.
+	LatencyFactor float64
+}
+
+// Autoscaler resizes a WorkerPool: it adds a worker while the queue backs up,
+// halves the pool when the upstream API answers with 429s and removes a
+// worker when its latency climbs well above the usual.
+type Autoscaler struct {
+	Pool   *WorkerPool
+	Config AutoscaleConfig
+	// Stats reports the upstream client's counters, usually api.Client.Stats.
+	Stats func() api.Stats
+
+	lastThrottled int64
+	baseline      time.Duration
+
+	// limitMu guards Config.Min and Config.Max, which SetLimits changes
+	limitMu sync.Mutex
+}
+
+func NewAutoscaler(pool *WorkerPool, cfg AutoscaleConfig, stats func() api.Stats) *Autoscaler {
+	if cfg.Min < 1 {
+		cfg.Min = 1
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.QueuePerWorker <= 0 {
+		cfg.QueuePerWorker = 2
+	}
+	if cfg.LatencyFactor <= 1 {
+		cfg.LatencyFactor = 2
+	}
+
+	a := &Autoscaler{
+		Pool:   pool,
+		Config: cfg,
+		Stats:  stats,
+	}
+	if stats != nil {
+		a.lastThrottled = stats().Throttled
+	}
+	return a
+}
+
+// SetLimits changes the bounds the pool is kept within, and resizes it into
+// them straight away if it is outside.
+func (a *Autoscaler) SetLimits(min, max int) {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	a.limitMu.Lock()
+	a.Config.Min, a.Config.Max = min, max
+	a.limitMu.Unlock()
+
+	if size := a.Pool.Size(); size < min || size > max {
+		a.Pool.Resize(clamp(size, min, max))
+	}
+}
+
+func (a *Autoscaler) limits() (int, int) {
+	a.limitMu.Lock()
+	defer a.limitMu.Unlock()
+	return a.Config.Min, a.Config.Max
+}
+
+// Run adjusts the pool every Config.Interval until ctx is cancelled.
+func (a *Autoscaler) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.Config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Step()
+		}
+	}
+}
+
+// Step makes a single scaling decision and returns the resulting pool size.
+func (a *Autoscaler) Step() int {
+	current := a.Pool.Size()
+	target := a.target(current)
+
+	if target != current {
+		logger.Info("Autoscaler: %d -> %d workers", current, target)
+		a.Pool.Resize(target)
+	}
+	return target
+}
+
+func (a *Autoscaler) target(current int) int {
+	target := current
+
+	var throttled int64
+	var latency time.Duration
+	if a.Stats != nil {
+		st := a.Stats()
+		throttled = st.Throttled - a.lastThrottled
+		a.lastThrottled = st.Throttled
+		latency = st.AvgLatency
+	}
+
+	slow := a.baseline > 0 && float64(latency) > float64(a.baseline)*a.Config.LatencyFactor
+	if !slow && latency > 0 {
+		// Only learn the baseline while the upstream looks healthy.
+		if a.baseline == 0 {
+			a.baseline = latency
+		} else {
+			a.baseline += (latency - a.baseline) / 10
+		}
+	}
+
+	queued := a.Pool.Queued()
+	switch {
+	case throttled > 0:
+		target = current / 2
+	case slow:
+		target = current - 1
+	case queued > current*a.Config.QueuePerWorker:
+		target = current + 1
+	}
+
+	min, max := a.limits()
+	return clamp(target, min, max)
+}
+
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
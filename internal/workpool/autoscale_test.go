@@ -0,0 +1,181 @@
+package workpool_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/api"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/workpool"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+)
+
+func TestWorkerPool_Resize(t *testing.T) {
+	ch := channels.New()
+	wp := workpool.New(ch, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	wp.Start(ctx)
+	defer wp.Stop()
+
+	// Block every worker so the number of concurrent fetches equals the pool size.
+	release := make(chan struct{})
+	running := make(chan struct{}, 10)
+	submit := func() {
+		_, err := ch.Submit(ctx, models.DataRequest{
+			ID: "blocking",
+			FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+				running <- struct{}{}
+				<-release
+				return []byte("data"), nil
+			},
+			ParseFunc: func(data []byte) (interface{}, error) { return "parsed", nil },
+			StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error { return nil },
+		})
+		if err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		submit()
+	}
+
+	<-running
+	wp.Resize(4)
+	if wp.Size() != 4 {
+		t.Fatalf("Expected size 4, got %d", wp.Size())
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-running:
+		case <-time.After(time.Second):
+			t.Fatalf("Only %d workers running after Resize(4)", i+1)
+		}
+	}
+
+	wp.Resize(2)
+	if wp.Size() != 2 {
+		t.Fatalf("Expected size 2, got %d", wp.Size())
+	}
+	close(release)
+
+	if err := ch.Wait(ctx, ""); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+}
+
+func TestWorkerPool_ResizeBeforeStart(t *testing.T) {
+	wp := workpool.New(channels.New(), 5)
+	wp.Resize(3)
+	if wp.WorkerCount != 3 || wp.Size() != 3 {
+		t.Errorf("Expected WorkerCount 3, got %d", wp.WorkerCount)
+	}
+}
+
+func TestAutoscaler_Step(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  int
+		queued int
+		// stats[0] is read when the autoscaler is built, one more per Step.
+		stats   []api.Stats
+		want    int
+		minSize int
+		maxSize int
+	}{
+		{
+			name:  "grows when queue backs up",
+			start: 2, queued: 10,
+			stats: []api.Stats{{}, {AvgLatency: 100 * time.Millisecond}},
+			want:  3, minSize: 1, maxSize: 10,
+		},
+		{
+			name:  "bounded by max",
+			start: 3, queued: 50,
+			stats: []api.Stats{{}, {AvgLatency: 100 * time.Millisecond}},
+			want:  3, minSize: 1, maxSize: 3,
+		},
+		{
+			name:  "halves on 429s",
+			start: 8, queued: 50,
+			stats: []api.Stats{{}, {AvgLatency: 100 * time.Millisecond}, {Throttled: 2, AvgLatency: 100 * time.Millisecond}},
+			want:  4, minSize: 1, maxSize: 10,
+		},
+		{
+			name:  "shrinks on rising latency",
+			start: 5, queued: 0,
+			stats: []api.Stats{{}, {AvgLatency: 100 * time.Millisecond}, {AvgLatency: time.Second}},
+			want:  4, minSize: 1, maxSize: 10,
+		},
+		{
+			name:  "bounded by min",
+			start: 2, queued: 0,
+			stats: []api.Stats{{}, {AvgLatency: 100 * time.Millisecond}, {Throttled: 1, AvgLatency: 100 * time.Millisecond}},
+			want:  2, minSize: 2, maxSize: 10,
+		},
+		{
+			name:  "holds steady when idle",
+			start: 3, queued: 0,
+			stats: []api.Stats{{}, {AvgLatency: 100 * time.Millisecond}},
+			want:  3, minSize: 1, maxSize: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &channels.Channels{DataRequest: make(chan models.DataRequest, 100)}
+			for i := 0; i < tt.queued; i++ {
+				if _, err := ch.Submit(context.Background(), models.DataRequest{ID: "q"}); err != nil {
+					t.Fatalf("Submit failed: %v", err)
+				}
+			}
+			wp := workpool.New(ch, tt.start)
+
+			calls := 0
+			stats := func() api.Stats {
+				st := tt.stats[calls]
+				calls++
+				return st
+			}
+			as := workpool.NewAutoscaler(wp, workpool.AutoscaleConfig{Min: tt.minSize, Max: tt.maxSize}, stats)
+
+			var got int
+			for i := 1; i < len(tt.stats); i++ {
+				got = as.Step()
+			}
+			if got != tt.want || wp.Size() != tt.want {
+				t.Errorf("Expected %d workers, got %d (pool %d)", tt.want, got, wp.Size())
+			}
+		})
+	}
+}
+
+func TestAutoscaler_SetLimits(t *testing.T) {
+	ch := &channels.Channels{DataRequest: make(chan models.DataRequest, 100)}
+	wp := workpool.New(ch, 5)
+	as := workpool.NewAutoscaler(wp, workpool.AutoscaleConfig{Min: 1, Max: 10}, nil)
+
+	as.SetLimits(1, 3)
+	if wp.Size() != 3 {
+		t.Errorf("Expected the pool to shrink to the new max of 3, got %d", wp.Size())
+	}
+	as.SetLimits(6, 8)
+	if wp.Size() != 6 {
+		t.Errorf("Expected the pool to grow to the new min of 6, got %d", wp.Size())
+	}
+
+	// Later steps keep to the new bounds
+	for i := 0; i < 100; i++ {
+		if _, err := ch.Submit(context.Background(), models.DataRequest{ID: "q"}); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+	as.Step()
+	as.Step()
+	as.Step()
+	if wp.Size() != 8 {
+		t.Errorf("Expected the pool to grow up to the new max of 8, got %d", wp.Size())
+	}
+}
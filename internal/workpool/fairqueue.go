@@ -0,0 +1,250 @@
+package workpool
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+)
+
+// defaultLaneDepth is how many requests a lane pulls ahead of the workers.
+const defaultLaneDepth = 10
+
+// FairQueue merges the channels of several services so one pool of workers
+// can serve them all. Services are picked by smooth weighted round-robin among
+// those with work waiting, so a busy service cannot starve the others, and
+// within a service the highest Priority request goes first.
+type FairQueue struct {
+	// LaneDepth caps how many requests are pulled off each service's channel
+	// ahead of the workers, keeping backpressure on the producers.
+	LaneDepth int
+
+	mu    sync.Mutex
+	lanes []*lane
+	// wake is closed and replaced whenever a lane gains work or closes.
+	wake  chan struct{}
+	start sync.Once
+}
+
+type lane struct {
+	ch      *channels.Channels
+	weight  int
+	current int
+	items   requestHeap
+	seq     uint64
+	closed  bool
+	// space is signalled when a worker takes an item from a full lane.
+	space chan struct{}
+}
+
+func NewFairQueue() *FairQueue {
+	return &FairQueue{
+		LaneDepth: defaultLaneDepth,
+		wake:      make(chan struct{}),
+	}
+}
+
+// Add registers a service's channels with the given weight. Weights below 1
+// count as 1. Add must be called before the pool is started.
+func (q *FairQueue) Add(ch *channels.Channels, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lanes = append(q.lanes, &lane{
+		ch:     ch,
+		weight: weight,
+		space:  make(chan struct{}, 1),
+	})
+}
+
+// SetWeight changes the weight of the service ch was added with, from its
+// next pick on. It reports false if ch was never added.
+func (q *FairQueue) SetWeight(ch *channels.Channels, weight int) bool {
+	if weight < 1 {
+		weight = 1
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, l := range q.lanes {
+		if l.ch == ch {
+			l.weight = weight
+			return true
+		}
+	}
+	return false
+}
+
+// Channels returns the channels of every registered service.
+func (q *FairQueue) Channels() []*channels.Channels {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]*channels.Channels, 0, len(q.lanes))
+	for _, l := range q.lanes {
+		out = append(out, l.ch)
+	}
+	return out
+}
+
+func (q *FairQueue) run(ctx context.Context) {
+	q.start.Do(func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for _, l := range q.lanes {
+			go q.feed(ctx, l)
+		}
+	})
+}
+
+// feed moves requests from a service's channel into its lane while the lane
+// has room.
+func (q *FairQueue) feed(ctx context.Context, l *lane) {
+	depth := q.LaneDepth
+	if depth <= 0 {
+		depth = defaultLaneDepth
+	}
+
+	for {
+		q.mu.Lock()
+		full := l.items.Len() >= depth
+		q.mu.Unlock()
+		if full {
+			select {
+			case <-l.space:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case req, ok := <-l.ch.DataRequest:
+			q.mu.Lock()
+			if !ok {
+				l.closed = true
+			} else {
+				l.seq++
+				heap.Push(&l.items, queuedRequest{req: req, seq: l.seq})
+			}
+			q.broadcast()
+			q.mu.Unlock()
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// next blocks until a request is available, returning it with the channels it
+// came from. It reports false once every lane is closed and drained, or when
+// ctx or quit is done.
+func (q *FairQueue) next(ctx context.Context, quit <-chan struct{}) (models.DataRequest, *channels.Channels, bool) {
+	for {
+		q.mu.Lock()
+		if l := q.pick(); l != nil {
+			item := heap.Pop(&l.items).(queuedRequest)
+			q.mu.Unlock()
+			select {
+			case l.space <- struct{}{}:
+			default:
+			}
+			return item.req, l.ch, true
+		}
+		if q.drained() {
+			q.mu.Unlock()
+			return models.DataRequest{}, nil, false
+		}
+		wake := q.wake
+		q.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return models.DataRequest{}, nil, false
+		case <-quit:
+			return models.DataRequest{}, nil, false
+		}
+	}
+}
+
+// pick chooses the next lane by smooth weighted round-robin among lanes with
+// work. Callers must hold q.mu.
+func (q *FairQueue) pick() *lane {
+	var best *lane
+	total := 0
+	for _, l := range q.lanes {
+		if l.items.Len() == 0 {
+			continue
+		}
+		l.current += l.weight
+		total += l.weight
+		if best == nil || l.current > best.current {
+			best = l
+		}
+	}
+	if best != nil {
+		best.current -= total
+	}
+	return best
+}
+
+// drained must be called with q.mu held.
+func (q *FairQueue) drained() bool {
+	for _, l := range q.lanes {
+		if !l.closed || l.items.Len() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// broadcast must be called with q.mu held.
+func (q *FairQueue) broadcast() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// queued returns how many requests are waiting across all services.
+func (q *FairQueue) queued() int {
+	n := 0
+	for _, ch := range q.Channels() {
+		n += ch.Stats("").Queued
+	}
+	return n
+}
+
+func (q *FairQueue) close() {
+	for _, ch := range q.Channels() {
+		ch.Close()
+	}
+}
+
+type queuedRequest struct {
+	req models.DataRequest
+	seq uint64
+}
+
+// requestHeap orders by Priority, highest first, then by arrival.
+type requestHeap []queuedRequest
+
+func (h requestHeap) Len() int { return len(h) }
+func (h requestHeap) Less(i, j int) bool {
+	if h[i].req.Priority != h[j].req.Priority {
+		return h[i].req.Priority > h[j].req.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h requestHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *requestHeap) Push(x interface{}) { *h = append(*h, x.(queuedRequest)) }
+func (h *requestHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,236 @@
+package workpool_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/workpool"
+	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+)
+
+// orderRecorder builds requests that record the order in which they are stored.
+type orderRecorder struct {
+	mu    sync.Mutex
+	order []string
+}
+
+func (r *orderRecorder) request(service, id string, priority int) models.DataRequest {
+	return models.DataRequest{
+		ID:       id,
+		Service:  service,
+		Priority: priority,
+		FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+			return []byte(id), nil
+		},
+		ParseFunc: func(data []byte) (interface{}, error) { return string(data), nil },
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
+			r.mu.Lock()
+			r.order = append(r.order, service+":"+d.(string))
+			r.mu.Unlock()
+			return nil
+		},
+	}
+}
+
+func (r *orderRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.order...)
+}
+
+// gate submits a request that blocks the pool's single worker until released,
+// so the test can queue everything else before the worker starts picking.
+func gate(t *testing.T, ctx context.Context, ch *channels.Channels) chan struct{} {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	_, err := ch.Submit(ctx, models.DataRequest{
+		ID: "gate",
+		FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+			close(started)
+			<-release
+			return nil, nil
+		},
+		ParseFunc: func(data []byte) (interface{}, error) { return nil, nil },
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error { return nil },
+	})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	<-started
+	return release
+}
+
+func TestSharedPool_WeightedFairness(t *testing.T) {
+	chA, chB := channels.New(), channels.New()
+	q := workpool.NewFairQueue()
+	q.LaneDepth = 20
+	q.Add(chA, 3)
+	q.Add(chB, 1)
+
+	wp := workpool.NewShared(q, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	wp.Start(ctx)
+	defer wp.Stop()
+
+	release := gate(t, ctx, chA)
+
+	rec := &orderRecorder{}
+	for i := 0; i < 8; i++ {
+		if _, err := chA.Submit(ctx, rec.request("a", "x", 0)); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+		if _, err := chB.Submit(ctx, rec.request("b", "x", 0)); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for _, ch := range []*channels.Channels{chA, chB} {
+		if err := ch.Wait(ctx, ""); err != nil {
+			t.Fatalf("Wait failed: %v", err)
+		}
+	}
+
+	order := rec.get()
+	if len(order) != 16 {
+		t.Fatalf("Expected 16 requests, got %d", len(order))
+	}
+	bInFirst8 := 0
+	for _, o := range order[:8] {
+		if o == "b:x" {
+			bInFirst8++
+		}
+	}
+	if bInFirst8 != 2 {
+		t.Errorf("Expected weight 3:1 to serve 2 of b in the first 8, got %d (%v)", bInFirst8, order)
+	}
+}
+
+func TestSharedPool_SetWeight(t *testing.T) {
+	chA, chB := channels.New(), channels.New()
+	q := workpool.NewFairQueue()
+	q.LaneDepth = 20
+	q.Add(chA, 3)
+	q.Add(chB, 1)
+
+	wp := workpool.NewShared(q, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	wp.Start(ctx)
+	defer wp.Stop()
+
+	release := gate(t, ctx, chA)
+
+	rec := &orderRecorder{}
+	for i := 0; i < 8; i++ {
+		if _, err := chA.Submit(ctx, rec.request("a", "x", 0)); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+		if _, err := chB.Submit(ctx, rec.request("b", "x", 0)); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+	// Swap the weights while the requests are queued
+	if !q.SetWeight(chA, 1) || !q.SetWeight(chB, 3) {
+		t.Fatal("SetWeight did not find a registered service")
+	}
+	if q.SetWeight(channels.New(), 2) {
+		t.Error("SetWeight found an unregistered service")
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for _, ch := range []*channels.Channels{chA, chB} {
+		if err := ch.Wait(ctx, ""); err != nil {
+			t.Fatalf("Wait failed: %v", err)
+		}
+	}
+
+	aInFirst8 := 0
+	for _, o := range rec.get()[:8] {
+		if o == "a:x" {
+			aInFirst8++
+		}
+	}
+	if aInFirst8 != 2 {
+		t.Errorf("Expected weight 1:3 to serve 2 of a in the first 8, got %d (%v)", aInFirst8, rec.get())
+	}
+}
+
+func TestSharedPool_Priority(t *testing.T) {
+	ch := channels.New()
+	q := workpool.NewFairQueue()
+	q.Add(ch, 1)
+
+	wp := workpool.NewShared(q, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	wp.Start(ctx)
+	defer wp.Stop()
+
+	release := gate(t, ctx, ch)
+
+	rec := &orderRecorder{}
+	for _, p := range []struct {
+		id       string
+		priority int
+	}{{"low", 1}, {"high", 5}, {"mid", 3}, {"low2", 1}} {
+		if _, err := ch.Submit(ctx, rec.request("s", p.id, p.priority)); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if err := ch.Wait(ctx, ""); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	want := []string{"s:high", "s:mid", "s:low", "s:low2"}
+	got := rec.get()
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Fatalf("Expected order %v, got %v", want, got)
+		}
+	}
+}
+
+func TestSharedPool_StopDrains(t *testing.T) {
+	chA, chB := channels.New(), channels.New()
+	q := workpool.NewFairQueue()
+	q.Add(chA, 1)
+	q.Add(chB, 1)
+
+	wp := workpool.NewShared(q, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	wp.Start(ctx)
+
+	rec := &orderRecorder{}
+	for i := 0; i < 5; i++ {
+		if _, err := chA.Submit(ctx, rec.request("a", "x", 0)); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+		if _, err := chB.Submit(ctx, rec.request("b", "x", 0)); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+	wp.Stop()
+
+	for _, ch := range []*channels.Channels{chA, chB} {
+		if err := ch.Wait(ctx, ""); err != nil {
+			t.Fatalf("Wait failed: %v", err)
+		}
+	}
+	if got := len(rec.get()); got != 10 {
+		t.Errorf("Expected all 10 queued requests to be processed after Stop, got %d", got)
+	}
+	if wp.Queued() != 0 {
+		t.Errorf("Expected nothing queued, got %d", wp.Queued())
+	}
+}
@@ -2,73 +2,414 @@ package workpool
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/metrics"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/tracing"
 	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
+// DefaultTimeout bounds a single request when neither the request nor the pool sets one.
+const DefaultTimeout = 300 * time.Second
+
+// maxFailures caps how many failures the pool remembers; older ones are dropped first.
+const maxFailures = 1000
+
+// Pipeline stages a request can fail in.
+const (
+	StageFetch = "fetch"
+	StageParse = "parse"
+	StageStore = "store"
+)
+
+// ErrLeaseLost fails a request whose batch lost its lease while it was
+// queued or in flight; another instance may be running the batch by now.
+var ErrLeaseLost = errors.New("batch lease lost")
+
+// Failure records a request that did not make it through the pipeline.
+type Failure struct {
+	Service string
+	ID      string
+	Stage   string
+	Err     error
+	At      time.Time
+}
+
+// PanicError wraps a panic recovered from one of the request funcs.
+type PanicError struct {
+	Stage string
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic during %s: %v", e.Stage, e.Value)
+}
+
+// Outcomes is told how each request ended, e.g. to track when each fetch
+// param was last stored. A request whose store was deferred ends with an
+// error wrapping models.ErrDeferred.
+type Outcomes interface {
+	Observe(service, id string, err error)
+}
+
 type WorkerPool struct {
 	WorkerCount int
-	Channels    *channels.Channels
+	// Channels is the single service a pool built with New serves; nil for
+	// shared pools, which take their work from Queue instead.
+	Channels       *channels.Channels
+	Queue          *FairQueue
+	DefaultTimeout time.Duration
+	// Coalesce makes concurrent requests for the same service and ID share a
+	// single fetch, parse and store.
+	Coalesce bool
+	// Outcomes, if set, is told the outcome of every request.
+	Outcomes Outcomes
+
+	flight    singleflight.Group
+	coalesced atomic.Int64
+
+	mu       sync.Mutex
+	failures []Failure
+
+	// sizeMu guards the running workers; each one exits when its quit channel closes.
+	sizeMu  sync.Mutex
+	ctx     context.Context
+	quits   []chan struct{}
+	started bool
+	nextID  int
 }
 
 func New(channels *channels.Channels, workerCount int) *WorkerPool {
 	return &WorkerPool{
-		WorkerCount: workerCount,
-		Channels:    channels,
+		WorkerCount:    workerCount,
+		Channels:       channels,
+		DefaultTimeout: DefaultTimeout,
+	}
+}
+
+// NewShared builds one pool serving every service registered with q, bounding
+// their combined concurrency to workerCount.
+func NewShared(q *FairQueue, workerCount int) *WorkerPool {
+	return &WorkerPool{
+		WorkerCount:    workerCount,
+		Queue:          q,
+		DefaultTimeout: DefaultTimeout,
 	}
 }
 
+// Start launches the workers. They derive every request context from ctx and
+// exit as soon as it is cancelled, leaving anything still queued untouched.
 func (wp *WorkerPool) Start(ctx context.Context) {
-	for i := 0; i < wp.WorkerCount; i++ {
-		go wp.worker(ctx, i)
+	wp.sizeMu.Lock()
+	defer wp.sizeMu.Unlock()
+
+	wp.ctx = ctx
+	wp.started = true
+	if wp.Queue != nil {
+		wp.Queue.run(ctx)
+	}
+	for len(wp.quits) < wp.WorkerCount {
+		wp.spawn()
 	}
 }
 
-func (wp *WorkerPool) worker(ctx context.Context, id int) {
-	logger.Info("Worker %d started.", id)
-	for req := range wp.Channels.DataRequest {
+// Resize grows or shrinks the pool to n workers. Removed workers finish the
+// request they are on before exiting. Before Start it only sets WorkerCount.
+func (wp *WorkerPool) Resize(n int) {
+	if n < 0 {
+		n = 0
+	}
 
-		wp.Channels.WG.Add(1)
+	wp.sizeMu.Lock()
+	defer wp.sizeMu.Unlock()
 
-		func(req models.DataRequest) {
-			defer wp.Channels.WG.Done()
+	if n == wp.WorkerCount {
+		return
+	}
+	logger.Info("Resizing worker pool from %d to %d workers.", wp.WorkerCount, n)
+	wp.WorkerCount = n
+	if !wp.started {
+		return
+	}
 
-			opCtx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
-			defer cancel()
+	for len(wp.quits) < n {
+		wp.spawn()
+	}
+	for len(wp.quits) > n {
+		last := len(wp.quits) - 1
+		close(wp.quits[last])
+		wp.quits = wp.quits[:last]
+	}
+}
 
-			logger.Info("[%s] Worker %d processing request for ID: %s", req.Service, id, req.ID)
+// Size returns the number of workers the pool is currently running or will start.
+func (wp *WorkerPool) Size() int {
+	wp.sizeMu.Lock()
+	defer wp.sizeMu.Unlock()
+	return wp.WorkerCount
+}
 
-			// 1. Fetch Data
-			data, err := req.FetchFunc(opCtx, req.ID)
-			if err != nil {
-				logger.Error("[%s] Worker %d failed to fetch data for %s: %v", req.Service, id, req.ID, err)
-				return
-			}
+// spawn must be called with sizeMu held.
+func (wp *WorkerPool) spawn() {
+	quit := make(chan struct{})
+	wp.quits = append(wp.quits, quit)
+	go wp.worker(wp.ctx, wp.nextID, quit)
+	wp.nextID++
+}
 
-			// 2. Parse Data
-			parsedData, err := req.ParseFunc(data)
-			if err != nil {
-				logger.Error("[%s] Worker %d failed to parse data for %s: %v", req.Service, id, req.ID, err)
-				return
-			}
+func (wp *WorkerPool) worker(ctx context.Context, id int, quit <-chan struct{}) {
+	log := logger.From(ctx).With(logger.WorkerID, id)
+	log.Info("Worker %d started.", id)
+	defer log.Info("Worker %d stopped.", id)
+
+	for {
+		req, ch, ok := wp.next(ctx, quit)
+		if !ok {
+			return
+		}
+
+		req = ch.Start(req)
+		err := wp.process(ctx, id, req)
+		if wp.Outcomes != nil {
+			wp.Outcomes.Observe(req.Service, req.ID, err)
+		}
+		if errors.Is(err, models.ErrDeferred) {
+			// Done for its run; the store reports the outcome once it lands
+			err = nil
+		}
+		ch.Finish(req, err)
+	}
+}
+
+// next returns the next request and the channels it belongs to, or false
+// when the worker should exit.
+func (wp *WorkerPool) next(ctx context.Context, quit <-chan struct{}) (models.DataRequest, *channels.Channels, bool) {
+	if wp.Queue != nil {
+		return wp.Queue.next(ctx, quit)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-quit:
+	case req, ok := <-wp.Channels.DataRequest:
+		if ok {
+			return req, wp.Channels, true
+		}
+	}
+	return models.DataRequest{}, nil, false
+}
 
-			// 3. Store Data
-			err = req.StoreFunc(opCtx, parsedData)
-			if err != nil {
-				logger.Error("[%s] Worker %d failed to store data for %s: %v", req.Service, id, req.ID, err)
-				return
+// Queued returns how many submitted requests are still waiting for a worker.
+func (wp *WorkerPool) Queued() int {
+	if wp.Queue != nil {
+		return wp.Queue.queued()
+	}
+	return wp.Channels.Stats("").Queued
+}
+
+func (wp *WorkerPool) process(ctx context.Context, id int, req models.DataRequest) error {
+	opCtx, cancel := context.WithTimeout(ctx, wp.timeoutFor(req))
+	defer cancel()
+	if req.Fence != nil {
+		// Stop once the batch's lease is lost, even if queued before that
+		fenced, stop := context.WithCancelCause(opCtx)
+		defer stop(nil)
+		go func() {
+			select {
+			case <-req.Fence.Lost():
+				stop(ErrLeaseLost)
+			case <-fenced.Done():
 			}
+		}()
+		opCtx = fenced
+	}
+
+	// Whatever the services and the API client log for req carries these
+	log := requestLogger(opCtx, id, req)
+	opCtx = log.Context()
+	log.Info("[%s] Worker %d processing request for ID: %s", req.Service, id, req.ID)
+
+	opCtx, span := tracing.Start(trace.ContextWithSpanContext(opCtx, req.Parent), "request",
+		tracing.Service.String(req.Service), tracing.FetchParam.String(req.ID),
+		tracing.RequestID.Int64(int64(req.JobID)), tracing.RunID.String(req.RunID), tracing.WorkerID.Int(id))
+	stage, err := wp.run(opCtx, req)
+	if err != nil && errors.Is(context.Cause(opCtx), ErrLeaseLost) {
+		err = ErrLeaseLost
+	}
+	if errors.Is(err, models.ErrDeferred) {
+		tracing.End(span, nil)
+		log.Info("[%s] Worker %d completed request for ID: %s, to be stored later: %v", req.Service, id, req.ID, err)
+		return err
+	}
+	tracing.End(span, err)
+	if err != nil {
+		log.Error("[%s] Worker %d failed to %s data for %s: %v", req.Service, id, stage, req.ID, err)
+		var pe *PanicError
+		if errors.As(err, &pe) {
+			log.Error("[%s] Worker %d recovered panic for %s:\n%s", req.Service, id, req.ID, pe.Stack)
+		}
+		wp.recordFailure(req, stage, err)
+		return err
+	}
+
+	log.Info("[%s] Worker %d successfully completed request for ID: %s", req.Service, id, req.ID)
+	return nil
+}
+
+func requestLogger(ctx context.Context, id int, req models.DataRequest) logger.Logger {
+	fields := []any{logger.Service, req.Service, logger.WorkerID, id, logger.RequestID, req.JobID, logger.FetchParam, req.ID}
+	if req.RunID != "" {
+		fields = append(fields, logger.RunID, req.RunID)
+	}
+	return logger.From(ctx).With(fields...)
+}
+
+// run executes the pipeline for req, sharing the outcome with any concurrent
+// duplicate when Coalesce is set.
+func (wp *WorkerPool) run(ctx context.Context, req models.DataRequest) (string, error) {
+	if !wp.Coalesce {
+		return runPipeline(ctx, req)
+	}
+
+	key := req.Service + "/" + req.ID
+	leader := false
+	v, err, _ := wp.flight.Do(key, func() (interface{}, error) {
+		leader = true
+		stage, err := runPipeline(ctx, req)
+		return stage, err
+	})
+	if !leader {
+		wp.coalesced.Add(1)
+		logger.From(ctx).Info("[%s] Shared an in-flight request for ID: %s", req.Service, req.ID)
+	}
+	return v.(string), err
+}
 
-			logger.Info("[%s] Worker %d successfully completed request for ID: %s", req.Service, id, req.ID)
-		}(req)
+// Coalesced returns how many requests were answered by another in-flight
+// request for the same service and ID.
+func (wp *WorkerPool) Coalesced() int64 {
+	return wp.coalesced.Load()
+}
+
+// runPipeline runs fetch, parse and store for req, turning a panic in any of
+// them into a *PanicError for the stage it happened in.
+func runPipeline(ctx context.Context, req models.DataRequest) (stage string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Stage: stage, Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	// 1. Fetch Data
+	stage = StageFetch
+	var data []byte
+	err = step(ctx, req.Service, stage, func(ctx context.Context) (err error) {
+		data, err = req.FetchFunc(ctx, req.ID)
+		return err
+	})
+	if err != nil {
+		return stage, err
+	}
+
+	// 2. Parse Data
+	stage = StageParse
+	var parsedData interface{}
+	err = step(ctx, req.Service, stage, func(context.Context) (err error) {
+		parsedData, err = req.ParseFunc(data)
+		return err
+	})
+	if err != nil {
+		return stage, err
+	}
+
+	// 3. Store Data
+	stage = StageStore
+	err = step(ctx, req.Service, stage, func(ctx context.Context) error {
+		return req.StoreFunc(ctx, parsedData, req.Fence)
+	})
+	if err != nil {
+		return stage, err
+	}
+
+	return "", nil
+}
+
+// errPanicked marks the span and duration of a stage that panicked; the
+// panic itself is recovered by runPipeline.
+var errPanicked = errors.New("panicked")
+
+// step runs one stage in its own span and records how long it took and
+// whether it failed.
+func step(ctx context.Context, service, stage string, fn func(context.Context) error) (err error) {
+	ctx, span := tracing.Start(ctx, stage)
+	start := time.Now()
+	panicked := true
+	defer func() {
+		if panicked {
+			err = errPanicked
+		}
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		metrics.StageDuration.WithLabelValues(service, stage, result).Observe(time.Since(start).Seconds())
+		tracing.End(span, err)
+	}()
+	err = fn(ctx)
+	panicked = false
+	return err
+}
+
+func (wp *WorkerPool) timeoutFor(req models.DataRequest) time.Duration {
+	if req.Timeout > 0 {
+		return req.Timeout
+	}
+	if wp.DefaultTimeout > 0 {
+		return wp.DefaultTimeout
 	}
+	return DefaultTimeout
+}
 
-	logger.Info("Worker %d stopped.", id)
+func (wp *WorkerPool) recordFailure(req models.DataRequest, stage string, err error) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.failures = append(wp.failures, Failure{
+		Service: req.Service,
+		ID:      req.ID,
+		Stage:   stage,
+		Err:     err,
+		At:      time.Now(),
+	})
+	if len(wp.failures) > maxFailures {
+		wp.failures = wp.failures[len(wp.failures)-maxFailures:]
+	}
 }
 
+// Failures returns a copy of the most recent failures recorded by the pool.
+func (wp *WorkerPool) Failures() []Failure {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	out := make([]Failure, len(wp.failures))
+	copy(out, wp.failures)
+	return out
+}
+
+// Stop closes the pool's channels: new submits fail with channels.ErrClosed
+// and workers exit once the queued requests are drained.
 func (wp *WorkerPool) Stop() {
-	close(wp.Channels.DataRequest)
+	if wp.Queue != nil {
+		wp.Queue.close()
+		return
+	}
+	wp.Channels.Close()
 }
@@ -3,6 +3,7 @@ package workpool_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -11,6 +12,10 @@ import (
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/workpool"
 	"github.com/AbdulWasayUl/go-api-parser-mono/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestWorkerPool_New(t *testing.T) {
@@ -50,7 +55,7 @@ func TestWorkerPool_SingleJob(t *testing.T) {
 			completed <- true
 			return "parsed", nil
 		},
-		StoreFunc: func(ctx context.Context, d interface{}) error {
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
 			completed <- true
 			return nil
 		},
@@ -62,7 +67,9 @@ func TestWorkerPool_SingleJob(t *testing.T) {
 	wp.Start(ctx)
 	time.Sleep(100 * time.Millisecond)
 
-	ch.DataRequest <- req
+	if _, err := ch.Submit(ctx, req); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
 
 	done := make(chan struct{})
 	go func() {
@@ -118,14 +125,16 @@ func TestWorkerPool_MultipleJobs(t *testing.T) {
 			ParseFunc: func(data []byte) (interface{}, error) {
 				return "result", nil
 			},
-			StoreFunc: func(ctx context.Context, d interface{}) error {
+			StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
 				mu.Lock()
 				completed++
 				mu.Unlock()
 				return nil
 			},
 		}
-		ch.DataRequest <- req
+		if _, err := ch.Submit(ctx, req); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
 	}
 
 	done := make(chan struct{})
@@ -170,7 +179,7 @@ func TestWorkerPool_FetchError(t *testing.T) {
 			parseCalled.Store(true)
 			return nil, nil
 		},
-		StoreFunc: func(ctx context.Context, d interface{}) error {
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
 			storeCalled.Store(true)
 			return nil
 		},
@@ -182,7 +191,9 @@ func TestWorkerPool_FetchError(t *testing.T) {
 	wp.Start(ctx)
 	time.Sleep(100 * time.Millisecond)
 
-	ch.DataRequest <- req
+	if _, err := ch.Submit(ctx, req); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
 
 	done := make(chan struct{})
 	go func() {
@@ -218,7 +229,7 @@ func TestWorkerPool_ParseError(t *testing.T) {
 		ParseFunc: func(data []byte) (interface{}, error) {
 			return nil, errors.New("parse failed")
 		},
-		StoreFunc: func(ctx context.Context, d interface{}) error {
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
 			storeCalled.Store(true)
 			return nil
 		},
@@ -230,7 +241,9 @@ func TestWorkerPool_ParseError(t *testing.T) {
 	wp.Start(ctx)
 	time.Sleep(100 * time.Millisecond)
 
-	ch.DataRequest <- req
+	if _, err := ch.Submit(ctx, req); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
 
 	done := make(chan struct{})
 	go func() {
@@ -264,7 +277,7 @@ func TestWorkerPool_StoreError(t *testing.T) {
 		ParseFunc: func(data []byte) (interface{}, error) {
 			return "parsed", nil
 		},
-		StoreFunc: func(ctx context.Context, d interface{}) error {
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
 			return errors.New("store failed")
 		},
 	}
@@ -275,7 +288,9 @@ func TestWorkerPool_StoreError(t *testing.T) {
 	wp.Start(ctx)
 	time.Sleep(100 * time.Millisecond)
 
-	ch.DataRequest <- req
+	if _, err := ch.Submit(ctx, req); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
 
 	done := make(chan struct{})
 	go func() {
@@ -334,3 +349,536 @@ func TestWorkerPool_NoJobs(t *testing.T) {
 		t.Error("WG.Wait timed out with no jobs")
 	}
 }
+
+func TestWorkerPool_PanicRecovered(t *testing.T) {
+	ch := channels.New()
+	wp := workpool.New(ch, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wp.Start(ctx)
+
+	ch.DataRequest <- models.DataRequest{
+		Service: "test",
+		ID:      "boom",
+		FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+			return []byte("data"), nil
+		},
+		ParseFunc: func(data []byte) (interface{}, error) {
+			panic("bad payload")
+		},
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
+			return nil
+		},
+	}
+
+	// The single worker must survive the panic and pick up the next request.
+	stored := make(chan struct{})
+	ch.DataRequest <- models.DataRequest{
+		Service: "test",
+		ID:      "ok",
+		FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+			return []byte("data"), nil
+		},
+		ParseFunc: func(data []byte) (interface{}, error) {
+			return "parsed", nil
+		},
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
+			close(stored)
+			return nil
+		},
+	}
+
+	select {
+	case <-stored:
+	case <-time.After(2 * time.Second):
+		t.Fatal("worker did not survive the panic")
+	}
+
+	wp.Stop()
+
+	failures := wp.Failures()
+	if len(failures) != 1 {
+		t.Fatalf("Expected 1 recorded failure, got %d", len(failures))
+	}
+	f := failures[0]
+	if f.ID != "boom" || f.Stage != workpool.StageParse {
+		t.Errorf("Unexpected failure: %+v", f)
+	}
+	var pe *workpool.PanicError
+	if !errors.As(f.Err, &pe) {
+		t.Fatalf("Expected PanicError, got %T", f.Err)
+	}
+	if pe.Value != "bad payload" {
+		t.Errorf("Expected panic value to be kept, got %v", pe.Value)
+	}
+}
+
+func TestWorkerPool_RequestContextDerivesFromPool(t *testing.T) {
+	ch := channels.New()
+	wp := workpool.New(ch, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wp.Start(ctx)
+
+	started := make(chan struct{})
+	fetchErr := make(chan error, 1)
+	ch.DataRequest <- models.DataRequest{
+		ID: "slow",
+		FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+			close(started)
+			<-ctx.Done()
+			fetchErr <- ctx.Err()
+			return nil, ctx.Err()
+		},
+		ParseFunc: func(data []byte) (interface{}, error) { return nil, nil },
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error { return nil },
+	}
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-fetchErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("request context was not cancelled with the pool context")
+	}
+}
+
+func TestWorkerPool_RequestTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		defaultTimeout time.Duration
+		reqTimeout     time.Duration
+		want           time.Duration
+	}{
+		{"RequestTimeoutWins", time.Hour, 50 * time.Millisecond, 50 * time.Millisecond},
+		{"PoolDefaultUsed", 50 * time.Millisecond, 0, 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := channels.New()
+			wp := workpool.New(ch, 1)
+			wp.DefaultTimeout = tt.defaultTimeout
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			wp.Start(ctx)
+			defer wp.Stop()
+
+			got := make(chan time.Duration, 1)
+			ch.DataRequest <- models.DataRequest{
+				ID:      "deadline",
+				Timeout: tt.reqTimeout,
+				FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+					deadline, ok := ctx.Deadline()
+					if !ok {
+						got <- 0
+						return nil, errors.New("no deadline")
+					}
+					got <- time.Until(deadline)
+					return nil, errors.New("stop here")
+				},
+				ParseFunc: func(data []byte) (interface{}, error) { return nil, nil },
+				StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error { return nil },
+			}
+
+			select {
+			case d := <-got:
+				if d <= 0 || d > tt.want {
+					t.Errorf("Expected deadline within %v, got %v", tt.want, d)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("request was not processed")
+			}
+		})
+	}
+}
+
+func TestWorkerPool_ExitsOnCancel(t *testing.T) {
+	ch := channels.New()
+	wp := workpool.New(ch, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wp.Start(ctx)
+	cancel()
+
+	// Give the workers a moment to observe the cancellation, then make sure
+	// nothing picks up new work.
+	time.Sleep(50 * time.Millisecond)
+
+	var fetched atomic.Bool
+	ch.DataRequest <- models.DataRequest{
+		ID: "late",
+		FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+			fetched.Store(true)
+			return nil, nil
+		},
+		ParseFunc: func(data []byte) (interface{}, error) { return nil, nil },
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error { return nil },
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if fetched.Load() {
+		t.Error("Workers should not process requests after the pool context is cancelled")
+	}
+}
+
+func TestWorkerPool_WaitCoversQueuedRequests(t *testing.T) {
+	ch := channels.New()
+	wp := workpool.New(ch, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wp.Start(ctx)
+	defer wp.Stop()
+
+	runCtx := channels.WithRunID(ctx, "run-1")
+	var stored atomic.Int32
+	numJobs := 20
+	jobs := make([]*channels.Job, 0, numJobs)
+	for i := 0; i < numJobs; i++ {
+		job, err := ch.Submit(runCtx, models.DataRequest{
+			ID: "job",
+			FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+				time.Sleep(time.Millisecond)
+				return []byte("data"), nil
+			},
+			ParseFunc: func(data []byte) (interface{}, error) { return "parsed", nil },
+			StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
+				stored.Add(1)
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := ch.Wait(ctx, "run-1"); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	if got := stored.Load(); int(got) != numJobs {
+		t.Errorf("Wait returned with %d/%d requests stored", got, numJobs)
+	}
+	for _, job := range jobs {
+		if job.State() != channels.JobDone {
+			t.Errorf("Job %d not done after Wait", job.ID)
+		}
+	}
+	stats := ch.Stats("run-1")
+	if stats.Done != numJobs || stats.Queued != 0 || stats.InFlight != 0 {
+		t.Errorf("Unexpected run stats: %+v", stats)
+	}
+}
+
+func TestWorkerPool_JobErrorReported(t *testing.T) {
+	ch := channels.New()
+	wp := workpool.New(ch, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wp.Start(ctx)
+	defer wp.Stop()
+
+	job, err := ch.Submit(ctx, models.DataRequest{
+		ID: "bad",
+		FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+			return nil, errors.New("fetch failed")
+		},
+		ParseFunc: func(data []byte) (interface{}, error) { return nil, nil },
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error { return nil },
+	})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	if err := job.Wait(ctx); err == nil || err.Error() != "fetch failed" {
+		t.Errorf("Expected job to report the fetch error, got %v", err)
+	}
+	if stats := ch.Stats(""); stats.Failed != 1 {
+		t.Errorf("Expected 1 failed request, got %+v", stats)
+	}
+}
+
+type fakeFence struct {
+	lost chan struct{}
+}
+
+func (f *fakeFence) Lost() <-chan struct{}           { return f.lost }
+func (f *fakeFence) Check(ctx context.Context) error { return nil }
+
+func TestWorkerPool_Fence(t *testing.T) {
+	ch := channels.New()
+	wp := workpool.New(ch, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wp.Start(ctx)
+	defer wp.Stop()
+
+	// The store is handed the request's fence
+	fence := &fakeFence{lost: make(chan struct{})}
+	var stored models.Fence
+	job, err := ch.Submit(channels.WithFence(ctx, fence), models.DataRequest{
+		ID:        "held",
+		FetchFunc: func(ctx context.Context, id string) ([]byte, error) { return nil, nil },
+		ParseFunc: func(data []byte) (interface{}, error) { return nil, nil },
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
+			stored = fence
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if err := job.Wait(ctx); err != nil {
+		t.Fatalf("Expected the request to succeed, got %v", err)
+	}
+	if stored != fence {
+		t.Errorf("Expected the store to get the request's fence, got %v", stored)
+	}
+
+	// Losing the lease stops a request in flight
+	started := make(chan struct{})
+	job, err = ch.Submit(channels.WithFence(ctx, fence), models.DataRequest{
+		ID: "lost",
+		FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		ParseFunc: func(data []byte) (interface{}, error) { return nil, nil },
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
+			t.Error("Store should not be called once the lease is lost")
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	<-started
+	close(fence.lost)
+	if err := job.Wait(ctx); !errors.Is(err, workpool.ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost, got %v", err)
+	}
+}
+
+type outcomes struct {
+	mu   sync.Mutex
+	seen map[string]error
+}
+
+func (o *outcomes) Observe(service, id string, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.seen[service+"/"+id] = err
+}
+
+func TestWorkerPool_Outcomes(t *testing.T) {
+	ch := channels.New()
+	wp := workpool.New(ch, 1)
+	out := &outcomes{seen: make(map[string]error)}
+	wp.Outcomes = out
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wp.Start(ctx)
+	defer wp.Stop()
+
+	for _, id := range []string{"good", "bad", "later"} {
+		job, err := ch.Submit(ctx, models.DataRequest{
+			Service: "weather",
+			ID:      id,
+			FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+				if id == "bad" {
+					return nil, errors.New("fetch failed")
+				}
+				return []byte("{}"), nil
+			},
+			ParseFunc: func(data []byte) (interface{}, error) { return nil, nil },
+			StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
+				if id == "later" {
+					return fmt.Errorf("%w: spooled", models.ErrDeferred)
+				}
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+		err = job.Wait(ctx)
+		if id == "later" && err != nil {
+			t.Errorf("Expected a deferred store to finish the job, got %v", err)
+		}
+	}
+
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if err, ok := out.seen["weather/good"]; !ok || err != nil {
+		t.Errorf("Expected a success for good, got %v (seen: %v)", err, ok)
+	}
+	if err := out.seen["weather/bad"]; err == nil || err.Error() != "fetch failed" {
+		t.Errorf("Expected the fetch error for bad, got %v", err)
+	}
+	if err := out.seen["weather/later"]; !errors.Is(err, models.ErrDeferred) {
+		t.Errorf("Expected a deferred outcome for later, got %v", err)
+	}
+}
+
+func TestWorkerPool_SubmitAfterStop(t *testing.T) {
+	ch := channels.New()
+	wp := workpool.New(ch, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wp.Start(ctx)
+	wp.Stop()
+
+	_, err := ch.Submit(ctx, models.DataRequest{ID: "late"})
+	if !errors.Is(err, channels.ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestWorkerPool_Coalesce(t *testing.T) {
+	tests := []struct {
+		name          string
+		coalesce      bool
+		ids           []string
+		wantFetches   int32
+		wantCoalesced int64
+	}{
+		{"DuplicatesShareOneFetch", true, []string{"Lahore", "Lahore"}, 1, 1},
+		{"DifferentIDsNotShared", true, []string{"Lahore", "Kabul"}, 2, 0},
+		{"DisabledFetchesTwice", false, []string{"Lahore", "Lahore"}, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := channels.New()
+			wp := workpool.New(ch, len(tt.ids))
+			wp.Coalesce = tt.coalesce
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			wp.Start(ctx)
+			defer wp.Stop()
+
+			var fetches, stores atomic.Int32
+			release := make(chan struct{})
+			jobs := make([]*channels.Job, 0, len(tt.ids))
+			for _, id := range tt.ids {
+				job, err := ch.Submit(ctx, models.DataRequest{
+					Service: "weather",
+					ID:      id,
+					FetchFunc: func(ctx context.Context, id string) ([]byte, error) {
+						fetches.Add(1)
+						<-release
+						return []byte("data"), nil
+					},
+					ParseFunc: func(data []byte) (interface{}, error) { return "parsed", nil },
+					StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error {
+						stores.Add(1)
+						return nil
+					},
+				})
+				if err != nil {
+					t.Fatalf("Submit failed: %v", err)
+				}
+				jobs = append(jobs, job)
+			}
+
+			// Let both workers pick up their request before the fetch returns.
+			deadline := time.Now().Add(time.Second)
+			for ch.Stats("").InFlight < len(tt.ids) && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+
+			for _, job := range jobs {
+				if err := job.Wait(ctx); err != nil {
+					t.Fatalf("Job failed: %v", err)
+				}
+			}
+			if got := fetches.Load(); got != tt.wantFetches {
+				t.Errorf("Expected %d fetches, got %d", tt.wantFetches, got)
+			}
+			if got := stores.Load(); got != tt.wantFetches {
+				t.Errorf("Expected %d stores, got %d", tt.wantFetches, got)
+			}
+			if got := wp.Coalesced(); got != tt.wantCoalesced {
+				t.Errorf("Expected %d coalesced, got %d", tt.wantCoalesced, got)
+			}
+		})
+	}
+}
+
+func TestWorkerPool_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(prev)
+
+	ch := channels.New()
+	wp := workpool.New(ch, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	wp.Start(ctx)
+	defer wp.Stop()
+
+	// The request's span is a child of the span it was submitted under
+	batchCtx, batch := otel.Tracer("test").Start(ctx, "batch")
+	job, err := ch.Submit(batchCtx, models.DataRequest{
+		ID:        "Kabul",
+		Service:   "weather_db",
+		FetchFunc: func(ctx context.Context, id string) ([]byte, error) { return []byte("data"), nil },
+		ParseFunc: func(data []byte) (interface{}, error) { return "parsed", nil },
+		StoreFunc: func(ctx context.Context, d interface{}, fence models.Fence) error { return errors.New("store failed") },
+	})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	job.Wait(ctx)
+	batch.End()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	request, ok := spans["request"]
+	if !ok {
+		t.Fatalf("No request span among %v", spans)
+	}
+	if request.Parent().SpanID() != batch.SpanContext().SpanID() {
+		t.Errorf("Expected the request span to be a child of the batch span")
+	}
+	for _, stage := range []string{workpool.StageFetch, workpool.StageParse, workpool.StageStore} {
+		s, ok := spans[stage]
+		if !ok {
+			t.Fatalf("No %s span", stage)
+		}
+		if s.Parent().SpanID() != request.SpanContext().SpanID() {
+			t.Errorf("Expected the %s span to be a child of the request span", stage)
+		}
+	}
+	if spans[workpool.StageStore].Status().Code != codes.Error || request.Status().Code != codes.Error {
+		t.Errorf("Expected the store and request spans to be failed")
+	}
+	if spans[workpool.StageFetch].Status().Code == codes.Error {
+		t.Errorf("Expected the fetch span to succeed")
+	}
+}
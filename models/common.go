@@ -2,17 +2,50 @@ package models
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type DataRequest struct {
-	ID        string
-	Service   string
+	ID      string
+	Service string
+	// RunID groups the requests of one batch run; JobID is set by the channels tracker.
+	RunID string
+	JobID uint64
+	// QueueKey identifies the request in a durable queue, if one is used.
+	QueueKey  string
 	FetchFunc func(ctx context.Context, id string) ([]byte, error)
 	ParseFunc func([]byte) (interface{}, error)
-	StoreFunc func(ctx context.Context, data interface{}) error
+	// StoreFunc writes the parsed data. fence is the request's Fence, which
+	// it must check before writing.
+	StoreFunc func(ctx context.Context, data interface{}, fence Fence) error
+	// Priority orders requests of the same service in a shared pool, highest first.
+	Priority int
+	// Timeout bounds fetch, parse and store together. Zero uses the pool default.
+	Timeout time.Duration
+	// Parent is the span of the batch that submitted the request, which the
+	// request's own span is a child of.
+	Parent trace.SpanContext
+	// Fence is the lease of the batch that submitted the request, if the
+	// batch holds one.
+	Fence Fence
+}
+
+// ErrDeferred is wrapped by a StoreFunc error when the data was kept to be
+// stored later, e.g. spooled while MongoDB is unreachable. The request is
+// done, but not yet a success.
+var ErrDeferred = errors.New("store deferred")
+
+// Fence is a lease whose fencing token guards the writes made under it.
+type Fence interface {
+	// Lost is closed once the lease was lost to another holder
+	Lost() <-chan struct{}
+	// Check fails if a lease with a newer token was granted since, so the
+	// write must not go ahead
+	Check(ctx context.Context) error
 }
 
 // type Service interface {
@@ -26,6 +59,15 @@ type RateLimitSettings struct {
 	PerDuration time.Duration
 }
 
+// Interval is how often a request is allowed, or zero if the settings allow
+// none or more than one per nanosecond.
+func (rl RateLimitSettings) Interval() time.Duration {
+	if rl.MaxRequests < 1 {
+		return 0
+	}
+	return rl.PerDuration / time.Duration(rl.MaxRequests)
+}
+
 type Migration struct {
 	Name string
 	Func func(ctx context.Context, client *mongo.Client) error
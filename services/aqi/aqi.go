@@ -10,26 +10,31 @@ import (
 
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/api"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/config"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/db"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/spool"
 	"github.com/AbdulWasayUl/go-api-parser-mono/models"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// DefaultRateLimit applies unless RATE_LIMITS sets one for the service.
+var DefaultRateLimit = models.RateLimitSettings{MaxRequests: 40, PerDuration: time.Minute}
+
 type Service struct {
 	Config *config.Config
 	Client *api.Client
 	DBName string
-	mu     sync.Mutex
+	// Spool, when set, keeps the records fetched while MongoDB is
+	// unreachable until they can be written
+	Spool *spool.Spool
+	mu    sync.Mutex
 }
 
 func NewService(cfg *config.Config) *Service {
-	rlSettings := models.RateLimitSettings{
-		MaxRequests: 40,
-		PerDuration: time.Minute,
-	}
-	client := api.NewClient(rlSettings)
+	client := api.NewClient(cfg.RateLimit(cfg.DBOpenAQ, DefaultRateLimit))
+	client.Provider = cfg.DBOpenAQ
 
 	return &Service{
 		Config: cfg,
@@ -101,8 +106,13 @@ func (s *Service) StoreData(ctx context.Context, db interface{}, data interface{
 		return fmt.Errorf("expected AQIData, got %T", data)
 	}
 
-	collection := client.Database(s.DBName).Collection(s.Config.CollectionDailyData)
-	_, err := collection.InsertOne(ctx, aqiData)
+	var err error
+	if s.Spool != nil {
+		err = s.Spool.Insert(ctx, client, s.DBName, s.Config.CollectionDailyData, aqiData)
+	} else {
+		collection := client.Database(s.DBName).Collection(s.Config.CollectionDailyData)
+		_, err = collection.InsertOne(ctx, aqiData)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to insert AQI data: %w", err)
 	}
@@ -110,9 +120,33 @@ func (s *Service) StoreData(ctx context.Context, db interface{}, data interface{
 	return nil
 }
 
+// Name identifies the service in locks and logs.
+func (s *Service) Name() string {
+	return s.DBName
+}
+
+// NewRequest builds the pipeline request for a single OpenAQ country ID.
+func (s *Service) NewRequest(client interface{}, id string) models.DataRequest {
+	return models.DataRequest{
+		ID:        id,
+		Service:   s.DBName,
+		FetchFunc: s.FetchData,
+		ParseFunc: s.ParseData,
+		StoreFunc: func(ctx context.Context, data interface{}, fence models.Fence) error {
+			if err := db.CheckFence(ctx, fence); err != nil {
+				return err
+			}
+			// Reported once replayed, if the record is spooled
+			return s.StoreData(spool.WithParam(ctx, s.DBName, id), client, data)
+		},
+		Timeout: s.Config.Timeout(s.DBName),
+	}
+}
+
 func (s *Service) RunBatchJob(ctx context.Context, client interface{}, chans *channels.Channels) error {
+	log := logger.From(ctx)
 
-	logger.Info("[%s] Starting batch job...", s.DBName)
+	log.Info("[%s] Starting batch job...", s.DBName)
 
 	client, ok := client.(*mongo.Client)
 	if !ok {
@@ -121,34 +155,33 @@ func (s *Service) RunBatchJob(ctx context.Context, client interface{}, chans *ch
 
 	params, err := db.GetFetchParams(ctx, client, s.DBName, s.Config.CollectionFetchParams)
 	if err != nil {
-		logger.Error("[%s] Failed to get fetch parameters: %v", s.DBName, err)
+		log.Error("[%s] Failed to get fetch parameters: %v", s.DBName, err)
 		return err
 	}
 
 	for _, param := range params {
 		countryCode, ok := param["country_id"]
 		if !ok {
-			logger.Error("[%s] Invalid country_id in fetch parameters", s.DBName)
+			log.Error("[%s] Invalid country_id in fetch parameters", s.DBName)
 			continue
 		}
 		countryCodeFloat, ok := countryCode.(float64)
 		if !ok {
-			logger.Error("[%s] Invalid country_id type in fetch parameters", s.DBName)
+			log.Error("[%s] Invalid country_id type in fetch parameters", s.DBName)
 			continue
 		}
 		countryIDStr := fmt.Sprintf("%d", int(countryCodeFloat))
 		logger.Debug("countryIDStr: %s", countryIDStr)
 
-		dataReq := models.DataRequest{
-			ID:        countryIDStr,
-			Service:   s.DBName,
-			FetchFunc: s.FetchData,
-			ParseFunc: s.ParseData,
-			StoreFunc: func(ctx context.Context, data interface{}) error {
-				return s.StoreData(ctx, client, data)
-			},
+		if !cluster.Owns(ctx, s.DBName, countryIDStr) {
+			continue
+		}
+		dataReq := s.NewRequest(client, countryIDStr)
+		dataReq.Priority = db.ParamPriority(param)
+		if _, err := chans.Submit(ctx, dataReq); err != nil {
+			log.Error("[%s] Failed to submit request for %s: %v", s.DBName, countryIDStr, err)
+			return err
 		}
-		chans.DataRequest <- dataReq
 	}
 
 	return nil
@@ -10,26 +10,31 @@ import (
 
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/api"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/channels"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/cluster"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/config"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/db"
 	"github.com/AbdulWasayUl/go-api-parser-mono/internal/logger"
+	"github.com/AbdulWasayUl/go-api-parser-mono/internal/spool"
 	"github.com/AbdulWasayUl/go-api-parser-mono/models"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// DefaultRateLimit applies unless RATE_LIMITS sets one for the service.
+var DefaultRateLimit = models.RateLimitSettings{MaxRequests: 20, PerDuration: time.Minute}
+
 type Service struct {
 	Config *config.Config
 	Client *api.Client
 	DBName string
-	mu     sync.Mutex
+	// Spool, when set, keeps the records fetched while MongoDB is
+	// unreachable until they can be written
+	Spool *spool.Spool
+	mu    sync.Mutex
 }
 
 func NewService(cfg *config.Config) *Service {
-	rlSettings := models.RateLimitSettings{
-		MaxRequests: 20,
-		PerDuration: time.Minute,
-	}
-	client := api.NewClient(rlSettings)
+	client := api.NewClient(cfg.RateLimit(cfg.DBWorldTime, DefaultRateLimit))
+	client.Provider = cfg.DBWorldTime
 
 	return &Service{
 		Config: cfg,
@@ -82,15 +87,44 @@ func (s *Service) StoreData(ctx context.Context, db interface{}, data interface{
 		return fmt.Errorf("invalid data type for storing weather data")
 	}
 
-	coll := client.Database(s.DBName).Collection(s.Config.CollectionDailyData)
-	_, err := coll.InsertOne(ctx, weatherData)
+	var err error
+	if s.Spool != nil {
+		err = s.Spool.Insert(ctx, client, s.DBName, s.Config.CollectionDailyData, weatherData)
+	} else {
+		coll := client.Database(s.DBName).Collection(s.Config.CollectionDailyData)
+		_, err = coll.InsertOne(ctx, weatherData)
+	}
 
 	return err
 }
 
+// Name identifies the service in locks and logs.
+func (s *Service) Name() string {
+	return s.DBName
+}
+
+// NewRequest builds the pipeline request for a single timezone.
+func (s *Service) NewRequest(client interface{}, id string) models.DataRequest {
+	return models.DataRequest{
+		ID:        id,
+		Service:   s.DBName,
+		FetchFunc: s.FetchData,
+		ParseFunc: s.ParseData,
+		StoreFunc: func(ctx context.Context, data interface{}, fence models.Fence) error {
+			if err := db.CheckFence(ctx, fence); err != nil {
+				return err
+			}
+			// Reported once replayed, if the record is spooled
+			return s.StoreData(spool.WithParam(ctx, s.DBName, id), client, data)
+		},
+		Timeout: s.Config.Timeout(s.DBName),
+	}
+}
+
 func (s *Service) RunBatchJob(ctx context.Context, client interface{}, chans *channels.Channels) error {
+	log := logger.From(ctx)
 
-	logger.Info("[%s] Starting batch job...", s.DBName)
+	log.Info("[%s] Starting batch job...", s.DBName)
 
 	client, ok := client.(*mongo.Client)
 	if !ok {
@@ -99,29 +133,29 @@ func (s *Service) RunBatchJob(ctx context.Context, client interface{}, chans *ch
 
 	params, err := db.GetFetchParams(ctx, client, s.DBName, s.Config.CollectionFetchParams)
 	if err != nil {
-		logger.Error("[%s] Failed to get fetch parameters: %v", s.DBName, err)
+		log.Error("[%s] Failed to get fetch parameters: %v", s.DBName, err)
 		return err
 	}
 
+	submitted := 0
 	for _, param := range params {
 		timezone, ok := param["timezone"].(string)
 		if !ok {
-			logger.Error("[%s] Invalid city parameter: %v", s.DBName, param["timezone"])
+			log.Error("[%s] Invalid city parameter: %v", s.DBName, param["timezone"])
+			continue
+		}
+		if !cluster.Owns(ctx, s.DBName, timezone) {
 			continue
 		}
-		dataReq := models.DataRequest{
-			ID:        timezone,
-			Service:   s.DBName,
-			FetchFunc: s.FetchData,
-			ParseFunc: s.ParseData,
-			StoreFunc: func(ctx context.Context, data interface{}) error {
-				return s.StoreData(ctx, client, data)
-			},
-			// StoreFunc: s.StoreData,
+		dataReq := s.NewRequest(client, timezone)
+		dataReq.Priority = db.ParamPriority(param)
+		if _, err := chans.Submit(ctx, dataReq); err != nil {
+			log.Error("[%s] Failed to submit request for %s: %v", s.DBName, timezone, err)
+			return err
 		}
-		chans.DataRequest <- dataReq
+		submitted++
 	}
 
-	logger.Info("[%s] Submitted %d requests to the worker pool.", s.DBName, len(params))
+	log.Info("[%s] Submitted %d of %d requests to the worker pool.", s.DBName, submitted, len(params))
 	return nil
 }